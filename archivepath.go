@@ -0,0 +1,119 @@
+package pluginengine
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrUnsafeArchivePath is wrapped by the error Untar and Unzip return for an archive entry that would be written
+// outside the output path: an absolute path, a path that escapes with .., or a symlink or hardlink whose target is
+// outside the output path.
+var ErrUnsafeArchivePath = errors.New("unsafe path in plugin archive")
+
+// extractRoot
+//
+// Creates the output path of an archive if need be, and returns it with its symlinks resolved, which is what the
+// entries of the archive are checked to stay within.
+func extractRoot(outputPath string) (string, error) {
+	if err := os.MkdirAll(outputPath, 0755); err != nil {
+		return "", err
+	}
+
+	return filepath.EvalSymlinks(outputPath)
+}
+
+// within
+//
+// Returns true when path is root or lexically inside it.
+func within(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	return nil == err && (rel == "." || filepath.IsLocal(rel))
+}
+
+// entryPath
+//
+// Returns the path an archive entry extracts to under root. Entry names use / as the separator, a \ is treated as one
+// too so an archive made on Windows can not escape either. The parent directories of the entry that already exist are
+// resolved, so an entry can not be written through a symlink extracted earlier that leads outside root.
+func entryPath(root, name string) (string, error) {
+	clean := strings.ReplaceAll(name, `\`, "/")
+	if strings.HasPrefix(clean, "/") || filepath.IsAbs(name) || len(filepath.VolumeName(name)) > 0 || !filepath.IsLocal(filepath.FromSlash(clean)) {
+		return "", fmt.Errorf("%w: %s", ErrUnsafeArchivePath, name)
+	}
+
+	path := filepath.Join(root, filepath.FromSlash(clean))
+
+	// the deepest parent directory that already exists
+	dir := filepath.Dir(path)
+	for {
+		if _, err := os.Lstat(dir); nil == err {
+			break
+		}
+
+		dir = filepath.Dir(dir)
+	}
+
+	resolved, err := filepath.EvalSymlinks(dir)
+	if nil != err || !within(root, resolved) {
+		return "", fmt.Errorf("%w: %s", ErrUnsafeArchivePath, name)
+	}
+
+	return path, nil
+}
+
+// removeSymlink
+//
+// Removes the symlink at path, if there is one, so an entry extracted to the same path replaces the symlink rather than
+// writing through it.
+func removeSymlink(path string) error {
+	if info, err := os.Lstat(path); nil == err && info.Mode()&os.ModeSymlink != 0 {
+		return os.Remove(path)
+	}
+
+	return nil
+}
+
+// checkSymlink
+//
+// Returns an error wrapping ErrUnsafeArchivePath unless the target of the symlink at path, relative to the symlink's
+// directory, is inside root. A target whose parts already exist is checked with their symlinks resolved too.
+func checkSymlink(root, path, target string) error {
+	unsafe := fmt.Errorf("%w: symlink %s to %s", ErrUnsafeArchivePath, strings.TrimPrefix(path, root+string(filepath.Separator)), target)
+
+	if len(target) == 0 || strings.HasPrefix(target, "/") || strings.HasPrefix(target, `\`) || filepath.IsAbs(target) || len(filepath.VolumeName(target)) > 0 {
+		return unsafe
+	}
+
+	resolved := filepath.Join(filepath.Dir(path), filepath.FromSlash(strings.ReplaceAll(target, `\`, "/")))
+	if !within(root, resolved) {
+		return unsafe
+	}
+
+	// resolved without cleaning it first, so a .. after a symlink in the target goes up from where the symlink leads
+	unclean := filepath.Dir(path) + string(filepath.Separator) + filepath.FromSlash(strings.ReplaceAll(target, `\`, "/"))
+	if real, err := filepath.EvalSymlinks(unclean); nil == err && !within(root, real) {
+		return unsafe
+	}
+
+	return nil
+}
+
+// hardlinkTarget
+//
+// Returns the path of the file a hardlink entry links to, which is named relative to the root of the archive. The
+// target must be a regular file already extracted inside root.
+func hardlinkTarget(root, linkname string) (string, error) {
+	target, err := entryPath(root, linkname)
+	if nil != err {
+		return "", fmt.Errorf("%w: hardlink to %s", ErrUnsafeArchivePath, linkname)
+	}
+
+	if info, err := os.Lstat(target); nil != err || !info.Mode().IsRegular() {
+		return "", fmt.Errorf("%w: hardlink to %s, which is not a file in the archive", ErrUnsafeArchivePath, linkname)
+	}
+
+	return target, nil
+}
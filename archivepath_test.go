@@ -0,0 +1,195 @@
+package pluginengine
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// archiveEntry is an entry of a crafted test archive.
+type archiveEntry struct {
+	name     string
+	typeflag byte
+	linkname string
+	contents string
+}
+
+func writeTestTar(t *testing.T, path string, entries []archiveEntry) {
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	gzipWriter := gzip.NewWriter(f)
+	tarWriter := tar.NewWriter(gzipWriter)
+	for _, entry := range entries {
+		header := &tar.Header{Name: entry.name, Typeflag: entry.typeflag, Linkname: entry.linkname, Mode: 0644, Size: int64(len(entry.contents))}
+		if err := tarWriter.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tarWriter.Write([]byte(entry.contents)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := tarWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gzipWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeTestZip(t *testing.T, path string, entries []archiveEntry) {
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	zipWriter := zip.NewWriter(f)
+	for _, entry := range entries {
+		header := &zip.FileHeader{Name: entry.name, Method: zip.Deflate}
+		header.SetMode(0644)
+
+		contents := entry.contents
+		if entry.typeflag == tar.TypeSymlink {
+			header.SetMode(os.ModeSymlink | 0777)
+			contents = entry.linkname
+		}
+
+		w, err := zipWriter.CreateHeader(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(contents)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := zipWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExtract_Safe(t *testing.T) {
+	entries := []archiveEntry{
+		{name: "lib/", typeflag: tar.TypeDir},
+		{name: "lib/plugin.wasm", typeflag: tar.TypeReg, contents: "\x00asm"},
+		{name: "plugin.yaml", typeflag: tar.TypeReg, contents: "id: com.acme.safe\n"},
+		{name: "current/plugin.wasm", typeflag: tar.TypeSymlink, linkname: "../lib/plugin.wasm"},
+	}
+
+	for _, extract := range []struct {
+		name  string
+		write func(*testing.T, string, []archiveEntry)
+		fn    func(string, string) error
+	}{
+		{"plugin.tar.gz", writeTestTar, Untar},
+		{"plugin.zip", writeTestZip, Unzip},
+	} {
+		dir := t.TempDir()
+		archive := filepath.Join(dir, extract.name)
+		outputPath := filepath.Join(dir, "out")
+
+		all := entries
+		if extract.name == "plugin.tar.gz" {
+			all = append(all, archiveEntry{name: "lib/copy.wasm", typeflag: tar.TypeLink, linkname: "lib/plugin.wasm"})
+		}
+		extract.write(t, archive, all)
+
+		if err := extract.fn(archive, outputPath); err != nil {
+			t.Fatalf("Expected %s to extract, but got %v", extract.name, err)
+		}
+
+		if data, err := os.ReadFile(filepath.Join(outputPath, "current", "plugin.wasm")); err != nil || string(data) != "\x00asm" {
+			t.Errorf("Expected the symlink inside %s to be extracted, but got %q %v", extract.name, data, err)
+		}
+	}
+}
+
+func TestExtract_UnsafePaths(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []archiveEntry
+		zip     bool
+	}{
+		{name: "parent", entries: []archiveEntry{{name: "../escape.txt", typeflag: tar.TypeReg, contents: "x"}}, zip: true},
+		{name: "nested parent", entries: []archiveEntry{{name: "lib/../../escape.txt", typeflag: tar.TypeReg, contents: "x"}}, zip: true},
+		{name: "absolute", entries: []archiveEntry{{name: "/tmp/escape.txt", typeflag: tar.TypeReg, contents: "x"}}, zip: true},
+		{name: "backslash", entries: []archiveEntry{{name: `..\escape.txt`, typeflag: tar.TypeReg, contents: "x"}}, zip: true},
+		{name: "symlink out", entries: []archiveEntry{{name: "link", typeflag: tar.TypeSymlink, linkname: "../.."}}, zip: true},
+		{name: "absolute symlink", entries: []archiveEntry{{name: "link", typeflag: tar.TypeSymlink, linkname: "/etc"}}, zip: true},
+		{name: "hardlink out", entries: []archiveEntry{{name: "link", typeflag: tar.TypeLink, linkname: "../outside.txt"}}},
+		{name: "hardlink to directory", entries: []archiveEntry{{name: "lib/", typeflag: tar.TypeDir}, {name: "link", typeflag: tar.TypeLink, linkname: "lib"}}},
+		{name: "symlink chain", entries: []archiveEntry{
+			{name: "lib/", typeflag: tar.TypeDir},
+			{name: "here", typeflag: tar.TypeSymlink, linkname: "."},
+			{name: "link", typeflag: tar.TypeSymlink, linkname: "here/lib/../.."},
+		}, zip: true},
+	}
+
+	for _, test := range tests {
+		for _, ext := range []string{".tar.gz", ".zip"} {
+			if ext == ".zip" && !test.zip {
+				continue
+			}
+
+			dir := t.TempDir()
+			archive := filepath.Join(dir, "plugin"+ext)
+			outputPath := filepath.Join(dir, "a", "b", "out")
+
+			var err error
+			if ext == ".zip" {
+				writeTestZip(t, archive, test.entries)
+				err = Unzip(archive, outputPath)
+			} else {
+				writeTestTar(t, archive, test.entries)
+				err = Untar(archive, outputPath)
+			}
+
+			if !errors.Is(err, ErrUnsafeArchivePath) {
+				t.Errorf("Expected ErrUnsafeArchivePath for %s in %s, but got %v", test.name, ext, err)
+			}
+
+			for _, escaped := range []string{filepath.Join(dir, "a", "b", "escape.txt"), filepath.Join(dir, "a", "escape.txt"), filepath.Join(outputPath, "link")} {
+				if _, err := os.Lstat(escaped); err == nil {
+					t.Errorf("Expected nothing at %s for %s in %s", escaped, test.name, ext)
+				}
+			}
+		}
+	}
+}
+
+func TestExtract_WriteThroughSymlink(t *testing.T) {
+	dir := t.TempDir()
+	outputPath := filepath.Join(dir, "out")
+	outside := filepath.Join(dir, "outside")
+	if err := os.MkdirAll(outside, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// a symlink left in the output path by an earlier extraction must not be written through
+	if err := os.MkdirAll(outputPath, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(outputPath, "lib")); err != nil {
+		t.Fatal(err)
+	}
+
+	archive := filepath.Join(dir, "plugin.tar.gz")
+	writeTestTar(t, archive, []archiveEntry{{name: "lib/plugin.wasm", typeflag: tar.TypeReg, contents: "x"}})
+
+	if err := Untar(archive, outputPath); !errors.Is(err, ErrUnsafeArchivePath) {
+		t.Errorf("Expected ErrUnsafeArchivePath writing through a symlink, but got %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(outside, "plugin.wasm")); err == nil {
+		t.Errorf("Expected nothing to be written outside the output path")
+	}
+}
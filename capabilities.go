@@ -0,0 +1,288 @@
+package pluginengine
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/gobwas/glob"
+)
+
+type (
+	// CapabilityKind is what a capability allows a plugin to do through the host functions.
+	CapabilityKind string
+
+	// CapabilityRule grants, or denies, the capabilities plugins request in their manifest. Plugin and Capability are
+	// glob patterns, where * matches any characters, matched against the id of the plugin (empty matches every plugin)
+	// and against the capability as the manifest requests it, such as fs.read:/data/**.
+	CapabilityRule struct {
+		Plugin     string `json:"plugin,omitempty" yaml:"plugin"`
+		Capability string `json:"capability" yaml:"capability"`
+		Deny       bool   `json:"deny,omitempty" yaml:"deny"`
+	}
+
+	// capability is a capability requested in a plugin manifest, kind:pattern, compiled to match what a host function
+	// is asked to do.
+	capability struct {
+		raw     string
+		kind    CapabilityKind
+		glob    glob.Glob
+		pattern *topicPattern
+	}
+
+	// capabilityRule is a compiled CapabilityRule.
+	capabilityRule struct {
+		plugin     glob.Glob
+		capability glob.Glob
+		deny       bool
+	}
+)
+
+const (
+	// CapabilityFSRead allows LoadFile to read the files the pattern matches. The pattern is an absolute path, where *
+	// matches within a directory and ** across directories, such as fs.read:/data/**.
+	CapabilityFSRead CapabilityKind = "fs.read"
+	// CapabilityExtensionsCall allows CallExtension to call the extensions whose id the pattern matches, such as
+	// extensions.call:com.acme.*.
+	CapabilityExtensionsCall CapabilityKind = "extensions.call"
+	// CapabilityExtensionsList allows GetExtensions to list the extensions of the extension points the pattern matches.
+	CapabilityExtensionsList CapabilityKind = "extensions.list"
+	// CapabilityExtensionsInvoke allows InvokeExtensions to call the extensions of the extension points the pattern
+	// matches.
+	CapabilityExtensionsInvoke CapabilityKind = "extensions.invoke"
+	// CapabilityEventsPublish allows SendEvent to send the events the pattern matches, an event topic pattern such as
+	// events.publish:editor.*.
+	CapabilityEventsPublish CapabilityKind = "events.publish"
+	// CapabilityEventsSubscribe allows AddEventListener to add listeners for the events the pattern matches. A listener
+	// with a wildcard pattern needs a capability with the same pattern, or one ending in # that covers it, and is not
+	// sent the events a deny rule of the policy matches.
+	CapabilityEventsSubscribe CapabilityKind = "events.subscribe"
+)
+
+// ErrPermissionDenied is wrapped by the error of a host function call the calling plugin has not been granted the
+// capability for. The guest gets the message from the LastError host function.
+var ErrPermissionDenied = errors.New("permission denied")
+
+// parseCapability
+//
+// Parses a capability requested in a plugin manifest, kind:pattern.
+func parseCapability(raw string) (*capability, error) {
+	kind, pattern, ok := strings.Cut(raw, ":")
+	if !ok || len(pattern) == 0 {
+		return nil, errors.New("invalid capability, expected kind:pattern: " + raw)
+	}
+
+	c := &capability{raw: raw, kind: CapabilityKind(kind)}
+
+	var err error
+	switch c.kind {
+	case CapabilityFSRead:
+		if !strings.HasPrefix(filepath.ToSlash(pattern), "/") && !filepath.IsAbs(pattern) {
+			return nil, errors.New("invalid capability, the path pattern is not absolute: " + raw)
+		}
+
+		// cleaned, so /data/../etc/** is the /etc/** it reads
+		c.raw = kind + ":" + path.Clean(filepath.ToSlash(pattern))
+		c.glob, err = glob.Compile(strings.TrimPrefix(c.raw, kind+":"), '/')
+	case CapabilityExtensionsCall, CapabilityExtensionsList, CapabilityExtensionsInvoke:
+		c.glob, err = glob.Compile(pattern)
+	case CapabilityEventsPublish, CapabilityEventsSubscribe:
+		c.pattern, err = compileTopicPattern(pattern)
+	default:
+		return nil, errors.New("unknown capability " + kind + ": " + raw)
+	}
+
+	if nil != err {
+		return nil, fmt.Errorf("invalid capability %s: %w", raw, err)
+	}
+
+	return c, nil
+}
+
+// parseCapabilities
+//
+// Parses the capabilities requested in a plugin manifest.
+func parseCapabilities(requested []string) ([]*capability, error) {
+	capabilities := make([]*capability, 0, len(requested))
+	for _, raw := range requested {
+		c, err := parseCapability(raw)
+		if nil != err {
+			return nil, err
+		}
+		capabilities = append(capabilities, c)
+	}
+
+	return capabilities, nil
+}
+
+// allows
+//
+// Returns true when the capability allows its kind of host function call on the target: a file path, an extension or
+// extension point id, an event name or, for CapabilityEventsSubscribe, a listener's topic pattern.
+func (c *capability) allows(kind CapabilityKind, target string) bool {
+	if c.kind != kind {
+		return false
+	}
+
+	switch kind {
+	case CapabilityEventsPublish:
+		return c.pattern.match(target)
+	case CapabilityEventsSubscribe:
+		return c.covers(target)
+	default:
+		return c.glob.Match(target)
+	}
+}
+
+// covers
+//
+// Returns true when every event the listener pattern matches is matched by the capability's pattern too. Rather than
+// compare the patterns' languages, an exact listener pattern is matched as an event name, and a wildcard one must be
+// the capability's pattern, or start with the segments before a capability pattern's last # segment.
+func (c *capability) covers(listener string) bool {
+	lp, err := compileTopicPattern(listener)
+	switch {
+	case nil != err:
+		return false
+	case c.pattern.any, c.pattern.raw == listener:
+		return true
+	case lp.exact:
+		return c.pattern.match(listener)
+	case strings.HasSuffix(c.pattern.raw, ".#"):
+		base := strings.TrimSuffix(c.pattern.raw, "#")
+		return !strings.ContainsAny(base, "*?[{\\") && strings.HasPrefix(listener, base)
+	default:
+		return false
+	}
+}
+
+// compileCapabilityRules
+//
+// Compiles the rules of the engine's capability policy, see WithCapabilityPolicy.
+func compileCapabilityRules(rules []CapabilityRule) ([]capabilityRule, error) {
+	compiled := make([]capabilityRule, 0, len(rules))
+	for _, rule := range rules {
+		pluginPattern := rule.Plugin
+		if len(pluginPattern) == 0 {
+			pluginPattern = "*"
+		}
+
+		p, err := glob.Compile(pluginPattern)
+		if nil != err {
+			return nil, fmt.Errorf("invalid capability rule plugin pattern %s: %w", rule.Plugin, err)
+		}
+
+		c, err := glob.Compile(rule.Capability)
+		if nil != err {
+			return nil, fmt.Errorf("invalid capability rule pattern %s: %w", rule.Capability, err)
+		}
+
+		compiled = append(compiled, capabilityRule{plugin: p, capability: c, deny: rule.Deny})
+	}
+
+	return compiled, nil
+}
+
+// grantCapabilities
+//
+// Returns the capabilities of those the plugin requests that the engine's policy grants, and the ones it denies. A
+// request is granted when no deny rule and at least one grant rule matches it. Without a policy (see
+// WithCapabilityPolicy) every request is granted, and host function calls are not restricted either.
+func (e *Engine) grantCapabilities(id string, requested []*capability) ([]*capability, []string) {
+	if nil == e.capabilityRules {
+		return requested, nil
+	}
+
+	granted := make([]*capability, 0, len(requested))
+	denied := make([]string, 0)
+
+	for _, c := range requested {
+		grant := false
+		for _, rule := range e.capabilityRules {
+			if !rule.plugin.Match(id) || !rule.capability.Match(c.raw) {
+				continue
+			}
+
+			if rule.deny {
+				grant = false
+				break
+			}
+			grant = true
+		}
+
+		if grant {
+			granted = append(granted, c)
+		} else {
+			denied = append(denied, c.raw)
+		}
+	}
+
+	return granted, denied
+}
+
+// checkCapability
+//
+// Returns an error wrapping ErrPermissionDenied unless the plugin calling a host function has been granted a capability
+// of the kind that allows it on the target, and no deny rule of the engine's policy matches the call itself. Without a
+// policy (see WithCapabilityPolicy) host function calls are not restricted. The result is kept for the LastError host
+// function.
+func (e *Engine) checkCapability(call *pluginCall, kind CapabilityKind, target string) error {
+	var err error
+	switch {
+	case nil == e.capabilityRules:
+	case nil == call:
+		err = fmt.Errorf("%w: %s %s by an unknown plugin", ErrPermissionDenied, kind, target)
+	case e.denies(call.plugin.Id, kind, target):
+		err = fmt.Errorf("%w: plugin %s is denied %s for %s", ErrPermissionDenied, call.plugin.Id, kind, target)
+	case !call.plugin.allows(kind, target):
+		err = fmt.Errorf("%w: plugin %s has no %s capability for %s", ErrPermissionDenied, call.plugin.Id, kind, target)
+	}
+
+	if nil != call {
+		call.setError(err)
+	}
+
+	return err
+}
+
+// denies
+//
+// Returns true when a deny rule of the engine's policy matches the host function call, kind:target, so a granted
+// request broader than the rule, such as fs.read:/** next to a deny of fs.read:/etc/*, does not reach what it denies.
+func (e *Engine) denies(id string, kind CapabilityKind, target string) bool {
+	for _, rule := range e.capabilityRules {
+		if rule.deny && rule.plugin.Match(id) && rule.capability.Match(string(kind)+":"+target) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// denyCapability
+//
+// Records a capability the policy denied the plugin, for the ResolutionReport, once.
+func (p *plugin) denyCapability(raw string) {
+	for _, denied := range p.deniedCapabilities {
+		if denied == raw {
+			return
+		}
+	}
+
+	p.deniedCapabilities = append(p.deniedCapabilities, raw)
+}
+
+// allows
+//
+// Returns true when one of the plugin's granted capabilities allows the host function call, see capability.allows.
+func (p *plugin) allows(kind CapabilityKind, target string) bool {
+	for _, c := range p.granted {
+		if c.allows(kind, target) {
+			return true
+		}
+	}
+
+	return false
+}
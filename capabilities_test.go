@@ -0,0 +1,272 @@
+package pluginengine
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	extism "github.com/extism/go-sdk"
+	gopdk "github.com/spirefy/go-pdk"
+)
+
+func TestCapability_Allows(t *testing.T) {
+	tests := []struct {
+		capability string
+		kind       CapabilityKind
+		target     string
+		allowed    bool
+	}{
+		{"fs.read:/data/**", CapabilityFSRead, "/data/plugins/config.json", true},
+		{"fs.read:/data/*", CapabilityFSRead, "/data/plugins/config.json", false},
+		{"fs.read:/data/../etc/**", CapabilityFSRead, "/etc/passwd", true},
+		{"fs.read:/data/**", CapabilityFSRead, "/etc/passwd", false},
+		{"extensions.call:com.acme.*", CapabilityExtensionsCall, "com.acme.editor.save", true},
+		{"extensions.call:com.acme.*", CapabilityExtensionsList, "com.acme.editor.menu", false},
+		{"extensions.invoke:com.acme.editor.menu", CapabilityExtensionsInvoke, "com.acme.editor.menu", true},
+		{"events.publish:editor.*", CapabilityEventsPublish, "editor.saved", true},
+		{"events.publish:editor.*", CapabilityEventsPublish, "editor.selection.changed", false},
+		{"events.subscribe:editor.*", CapabilityEventsSubscribe, "editor.saved", true},
+		{"events.subscribe:editor.*", CapabilityEventsSubscribe, "editor.*", true},
+		{"events.subscribe:editor.*", CapabilityEventsSubscribe, "editor.#", false},
+		{"events.subscribe:editor.#", CapabilityEventsSubscribe, "editor.sel*.changed", true},
+		{"events.subscribe:editor.#", CapabilityEventsSubscribe, "window.*", false},
+		{"events.subscribe:#", CapabilityEventsSubscribe, "window.#", true},
+	}
+
+	for _, test := range tests {
+		c, err := parseCapability(test.capability)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if allowed := c.allows(test.kind, test.target); allowed != test.allowed {
+			t.Errorf("Expected %s to allow %s %s to be %v, but got %v", test.capability, test.kind, test.target, test.allowed, allowed)
+		}
+	}
+
+	for _, invalid := range []string{"fs.read", "fs.read:data/**", "net.connect:example.com", "events.publish:editor.**"} {
+		if _, err := parseCapability(invalid); err == nil {
+			t.Errorf("Expected the capability %s to be refused", invalid)
+		}
+	}
+}
+
+func TestCapabilityPolicy(t *testing.T) {
+	archive := filepath.Join(t.TempDir(), "plugins.tar.gz")
+	writeTestTar(t, archive, []archiveEntry{
+		{name: "a/plugin.yaml", typeflag: tar.TypeReg, contents: "id: com.acme.a\nversion: 1.0.0\ncapabilities:\n  - fs.read:/data/**\n  - fs.read:/etc/**\n  - events.publish:editor.*\n"},
+		{name: "a/plugin.wasm", typeflag: tar.TypeReg, contents: "\x00asm"},
+		{name: "b/plugin.yaml", typeflag: tar.TypeReg, contents: "id: com.acme.b\nversion: 1.0.0\ncapabilities:\n  - network:example.com\n"},
+		{name: "b/plugin.wasm", typeflag: tar.TypeReg, contents: "\x00asm"},
+	})
+
+	engine, err := NewPluginEngine(nil, extism.LogLevelOff, t.TempDir(), WithCapabilityPolicy(
+		CapabilityRule{Capability: "fs.read:*"},
+		CapabilityRule{Capability: "fs.read:/etc/*", Deny: true},
+		CapabilityRule{Plugin: "com.other.*", Capability: "events.*"},
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := engine.loadPluginArchive(archive, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if nil != engine.GetPlugins()["com.acme.b"] {
+		t.Errorf("Expected a plugin requesting an unknown capability to be refused")
+	}
+
+	p := engine.GetPlugins()["com.acme.a"]["1.0.0"]
+	if nil == p {
+		t.Fatal("Expected com.acme.a to be loaded")
+	}
+
+	report := engine.GetResolutionReport()
+	if denied := report.Plugins[0].DeniedCapabilities; len(denied) != 2 || denied[0] != "fs.read:/etc/**" || denied[1] != "events.publish:editor.*" {
+		t.Errorf("Expected the denied capabilities to be reported, but got %v", denied)
+	}
+
+	call := currentCall(withCallingPlugin(context.Background(), p))
+	if err := engine.checkCapability(call, CapabilityFSRead, "/data/config.json"); err != nil {
+		t.Errorf("Expected a granted capability to allow the read, but got %v", err)
+	}
+
+	for _, denied := range []struct {
+		kind   CapabilityKind
+		target string
+	}{{CapabilityFSRead, "/etc/passwd"}, {CapabilityEventsPublish, "editor.saved"}, {CapabilityExtensionsCall, "com.acme.b.run"}} {
+		if err := engine.checkCapability(call, denied.kind, denied.target); !errors.Is(err, ErrPermissionDenied) {
+			t.Errorf("Expected ErrPermissionDenied for %s %s, but got %v", denied.kind, denied.target, err)
+		}
+	}
+
+	if err := call.lastError(); !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("Expected the last permission error to be kept for the guest, but got %v", err)
+	}
+
+	if err := engine.checkCapability(nil, CapabilityFSRead, "/data/config.json"); !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("Expected ErrPermissionDenied for an unknown plugin, but got %v", err)
+	}
+
+	unrestricted := newTestEngine(t)
+	if err := unrestricted.checkCapability(currentCall(withCallingPlugin(context.Background(), &plugin{})), CapabilityFSRead, "/etc/passwd"); err != nil {
+		t.Errorf("Expected host function calls not to be restricted without a policy, but got %v", err)
+	}
+
+	if _, err := NewPluginEngine(nil, extism.LogLevelOff, t.TempDir(), WithCapabilityPolicy(CapabilityRule{Capability: "fs.read:[/data"})); err == nil {
+		t.Errorf("Expected an invalid capability rule to be refused")
+	}
+}
+
+func TestCapabilityPolicy_DenyBroaderRequest(t *testing.T) {
+	archive := filepath.Join(t.TempDir(), "plugins.tar.gz")
+	writeTestTar(t, archive, []archiveEntry{
+		{name: "a/plugin.yaml", typeflag: tar.TypeReg, contents: "id: com.acme.a\nversion: 1.0.0\ncapabilities:\n  - fs.read:/**\n"},
+		{name: "a/plugin.wasm", typeflag: tar.TypeReg, contents: "\x00asm"},
+	})
+
+	engine, err := NewPluginEngine(nil, extism.LogLevelOff, t.TempDir(), WithCapabilityPolicy(
+		CapabilityRule{Capability: "fs.read:*"},
+		CapabilityRule{Capability: "fs.read:/etc/*", Deny: true},
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := engine.loadPluginArchive(archive, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	p := engine.GetPlugins()["com.acme.a"]["1.0.0"]
+	if nil == p || len(p.granted) != 1 {
+		t.Fatalf("Expected fs.read:/** to be granted, as the deny rule does not match the request, but got %+v", p)
+	}
+
+	// the deny rule is matched against the read itself, not only against the request
+	call := currentCall(withCallingPlugin(context.Background(), p))
+	if err := engine.checkCapability(call, CapabilityFSRead, "/etc/passwd"); !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("Expected ErrPermissionDenied reading /etc/passwd through fs.read:/**, but got %v", err)
+	}
+
+	if err := engine.checkCapability(call, CapabilityFSRead, "/data/config.json"); err != nil {
+		t.Errorf("Expected a read the deny rule does not match to be allowed, but got %v", err)
+	}
+}
+
+func TestReadablePath_Symlink(t *testing.T) {
+	dir := t.TempDir()
+	data := filepath.Join(dir, "data")
+	secret := filepath.Join(dir, "secret.txt")
+	if err := os.MkdirAll(data, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(secret, []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(secret, filepath.Join(data, "link.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := parseCapability("fs.read:" + filepath.ToSlash(data) + "/**")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.allows(CapabilityFSRead, readablePath(filepath.Join(data, "link.txt"))) {
+		t.Errorf("Expected a symlink out of the granted directory not to be readable")
+	}
+}
+
+func TestCapabilityPolicy_ManifestListeners(t *testing.T) {
+	archive := filepath.Join(t.TempDir(), "plugins.tar.gz")
+	writeTestTar(t, archive, []archiveEntry{
+		{name: "a/plugin.yaml", typeflag: tar.TypeReg, contents: "id: com.acme.a\nversion: 1.0.0\ncapabilities:\n  - events.subscribe:editor.#\nlisteners:\n  - event: editor.saved\n    func: onSaved\n  - event: editor.secret\n    func: onSecret\n  - event: system.shutdown\n    func: onShutdown\n"},
+		{name: "a/plugin.wasm", typeflag: tar.TypeReg, contents: "\x00asm"},
+	})
+
+	engine, err := NewPluginEngine(nil, extism.LogLevelOff, t.TempDir(), WithCapabilityPolicy(
+		CapabilityRule{Capability: "events.*"},
+		CapabilityRule{Capability: "events.subscribe:editor.secret", Deny: true},
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := engine.loadPluginArchive(archive, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	p := engine.GetPlugins()["com.acme.a"]["1.0.0"]
+	if nil == p {
+		t.Fatal("Expected com.acme.a to be loaded")
+	}
+
+	listeners := engine.pluginListeners(p)
+	if len(listeners) != 1 || listeners[0].fn != "onSaved" {
+		t.Errorf("Expected only the listener the granted capability allows to be added, but got %d", len(listeners))
+	}
+
+	report := engine.GetResolutionReport()
+	if denied := report.Plugins[0].DeniedCapabilities; len(denied) != 2 || denied[0] != "events.subscribe:editor.secret" || denied[1] != "events.subscribe:system.shutdown" {
+		t.Errorf("Expected the denied listeners to be reported, but got %v", denied)
+	}
+}
+
+func TestCapabilityPolicy_DeniedEvents(t *testing.T) {
+	engine, err := NewPluginEngine(nil, extism.LogLevelOff, t.TempDir(), WithCapabilityPolicy(
+		CapabilityRule{Capability: "events.*"},
+		CapabilityRule{Capability: "events.subscribe:editor.secret", Deny: true},
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := engine.ConfigureTopic("editor.secret", TopicConfig{Retain: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := engine.Publish(context.Background(), "editor.secret", []byte("retained")); err != nil {
+		t.Fatal(err)
+	}
+
+	module := filepath.Join(t.TempDir(), "plugin.wasm")
+	if err := os.WriteFile(module, testWasmModule(0), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// the listener's wildcard pattern is granted, but the policy denies one of the events it matches
+	requested, err := parseCapabilities([]string{"events.subscribe:editor.#"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := &plugin{PathToModule: module, Listeners: []eventListener{{Event: "editor.#", Func: "run"}}}
+	p.granted, _ = engine.grantCapabilities("com.acme.a", requested)
+	engine.addPlugin(p, gopdk.Plugin{Id: "com.acme.a", Version: "1.0.0"})
+
+	if len(engine.pluginListeners(p)) != 1 {
+		t.Fatalf("Expected the granted wildcard listener to be added")
+	}
+
+	if err := engine.instantiate(context.Background(), p); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"editor.saved", "editor.secret"} {
+		if err := engine.Publish(context.Background(), name, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	metrics := engine.GetEventMetrics()
+	if m := metrics["editor.saved"]; m.Delivered != 1 {
+		t.Errorf("Expected an allowed event to reach the wildcard listener, but got %+v", m)
+	}
+
+	if m := metrics["editor.secret"]; m.Delivered != 0 || m.Failed != 0 {
+		t.Errorf("Expected a denied event, retained or sent, not to reach the wildcard listener, but got %+v", m)
+	}
+}
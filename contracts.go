@@ -0,0 +1,267 @@
+package pluginengine
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+	gopdk "github.com/spirefy/go-pdk"
+)
+
+type (
+	// Cardinality is how many extensions an extension point accepts.
+	Cardinality string
+
+	// ExtensionPointContract is what an extension point promises its extensions: how many of them it accepts, and the
+	// JSON Schema of the data they are called with and of what they return. It is declared next to the extension point
+	// in the plugin manifest:
+	//
+	//	extensionPoints:
+	//	  - id: com.acme.editor.formatter
+	//	    version: 1.0.0
+	//	    cardinality: single
+	//	    inputSchema:
+	//	      type: object
+	//	      required: [text]
+	//	    outputSchema:
+	//	      type: string
+	ExtensionPointContract struct {
+		// the default, empty, is CardinalityMany
+		Cardinality Cardinality `json:"cardinality,omitempty" yaml:"cardinality"`
+		// the JSON Schema of the data extensions are called with, and of what they return, empty accepts anything
+		InputSchema  json.RawMessage `json:"inputSchema,omitempty" yaml:"-"`
+		OutputSchema json.RawMessage `json:"outputSchema,omitempty" yaml:"-"`
+	}
+
+	// CardinalityViolation is reported when an extension point has more extensions than its cardinality accepts, or
+	// none when it requires one.
+	CardinalityViolation struct {
+		ExtensionPoint string      `json:"extensionPoint"`
+		Version        string      `json:"version"`
+		Cardinality    Cardinality `json:"cardinality"`
+		// the extension ids left out of the extension point, all but the first in extension order
+		Rejected []string `json:"rejected,omitempty"`
+		Reason   string   `json:"reason"`
+	}
+
+	// extensionPointManifest holds the parts of an extension point in a plugin manifest that are specific to this
+	// engine and not part of gopdk.ExtensionPoint. The schemas are yaml in the manifest and converted to json.
+	extensionPointManifest struct {
+		Id           string      `yaml:"id"`
+		Cardinality  Cardinality `yaml:"cardinality"`
+		InputSchema  interface{} `yaml:"inputSchema"`
+		OutputSchema interface{} `yaml:"outputSchema"`
+	}
+
+	// contractSchemas holds the compiled schemas of an ExtensionPointContract, nil where the contract has none.
+	contractSchemas struct {
+		input  *jsonschema.Schema
+		output *jsonschema.Schema
+	}
+)
+
+const (
+	// CardinalityMany accepts any number of extensions.
+	CardinalityMany Cardinality = "many"
+	// CardinalitySingle requires exactly one extension.
+	CardinalitySingle Cardinality = "single"
+	// CardinalityOptional accepts one extension or none.
+	CardinalityOptional Cardinality = "optional"
+)
+
+// ErrInvalidPayload is wrapped by the error returned when payload validation is on (see WithPayloadValidation) and the
+// data of an extension call, or what the extension returns, does not match the schema of its extension point.
+var ErrInvalidPayload = errors.New("payload does not match the extension point schema")
+
+// compile
+//
+// Checks the contract's cardinality and compiles its schemas. epId names the extension point in errors.
+func (c ExtensionPointContract) compile(epId string) (contractSchemas, error) {
+	schemas := contractSchemas{}
+
+	switch c.Cardinality {
+	case "", CardinalityMany, CardinalitySingle, CardinalityOptional:
+	default:
+		return schemas, errors.New("invalid cardinality of extension point " + epId + ": " + string(c.Cardinality))
+	}
+
+	var err error
+	if schemas.input, err = compileSchema(epId+"/input.json", c.InputSchema); nil != err {
+		return schemas, fmt.Errorf("invalid input schema of extension point %s: %w", epId, err)
+	}
+
+	if schemas.output, err = compileSchema(epId+"/output.json", c.OutputSchema); nil != err {
+		return schemas, fmt.Errorf("invalid output schema of extension point %s: %w", epId, err)
+	}
+
+	return schemas, nil
+}
+
+// compileSchema
+//
+// Compiles the JSON Schema, returning nil when it is empty.
+func compileSchema(url string, schema json.RawMessage) (*jsonschema.Schema, error) {
+	if len(schema) == 0 {
+		return nil, nil
+	}
+
+	return jsonschema.CompileString(url, string(schema))
+}
+
+// validatePayload
+//
+// Checks the data against the schema, if there is one. Empty data is validated as json null. what names the payload in
+// the error, such as "input of extension com.acme.menu.open".
+func validatePayload(schema *jsonschema.Schema, data []byte, what string) error {
+	if nil == schema {
+		return nil
+	}
+
+	var v interface{}
+	if len(data) > 0 {
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+		if err := decoder.Decode(&v); nil != err {
+			return fmt.Errorf("%w: %s is not json: %v", ErrInvalidPayload, what, err)
+		}
+	}
+
+	if err := schema.Validate(v); nil != err {
+		return fmt.Errorf("%w: %s: %v", ErrInvalidPayload, what, err)
+	}
+
+	return nil
+}
+
+// contracts
+//
+// Returns the contracts of the manifest's extension points keyed on extension point id, with the schemas converted to
+// json and checked to compile.
+func (m pluginManifest) contracts() (map[string]ExtensionPointContract, error) {
+	contracts := make(map[string]ExtensionPointContract, len(m.ExtensionPoints))
+	for _, ep := range m.ExtensionPoints {
+		contract := ExtensionPointContract{Cardinality: ep.Cardinality}
+
+		var err error
+		if contract.InputSchema, err = schemaToJSON(ep.InputSchema); nil != err {
+			return nil, err
+		}
+
+		if contract.OutputSchema, err = schemaToJSON(ep.OutputSchema); nil != err {
+			return nil, err
+		}
+
+		if _, err := contract.compile(ep.Id); nil != err {
+			return nil, err
+		}
+
+		contracts[ep.Id] = contract
+	}
+
+	return contracts, nil
+}
+
+// schemaToJSON
+//
+// Converts a schema unmarshalled from yaml to json, returning nil when there is none.
+func schemaToJSON(schema interface{}) (json.RawMessage, error) {
+	if nil == schema {
+		return nil, nil
+	}
+
+	return json.Marshal(schema)
+}
+
+// newExtensionPoint
+//
+// Returns the extension point of plugin p with its contract compiled. A contract that does not compile is left out,
+// with a warning, so the extension point accepts anything; loadPluginArchive and RegisterNativePlugin refuse such
+// contracts before a plugin gets here.
+func (e *Engine) newExtensionPoint(ep gopdk.ExtensionPoint, contract ExtensionPointContract, p plugin) *extensionPoint {
+	schemas, err := contract.compile(ep.Id)
+	if nil != err {
+		e.pluginLogger(&p).Warn("ignoring contract of extension point", "extensionPoint", ep.Id, "error", err)
+		contract, schemas = ExtensionPointContract{}, contractSchemas{}
+	}
+
+	return &extensionPoint{
+		ExtensionPoint: ep,
+		Contract:       contract,
+		Plugin:         p,
+		schemas:        schemas,
+	}
+}
+
+// enforceCardinality
+//
+// Leaves the extensions an extension point does not accept out of it: all but the first in extension order when the
+// cardinality is single or optional. The extensions left out are not returned by GetExtensionsForExtensionPoint nor
+// passed to a host extension point func, but can still be called by id. A single extension point without an extension
+// is reported too. It must be called with the engine lock held, after routeExtensions.
+func (e *Engine) enforceCardinality() {
+	ids := make([]string, 0, len(e.extensionPoints))
+	for id := range e.extensionPoints {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	violations := make([]CardinalityViolation, 0)
+	for _, id := range ids {
+		for _, ep := range e.extensionPoints[id] {
+			ep.rejected = nil
+			if ep.Contract.Cardinality != CardinalitySingle && ep.Contract.Cardinality != CardinalityOptional {
+				continue
+			}
+
+			attached := e.attachedExtensions(ep)
+			violation := CardinalityViolation{ExtensionPoint: ep.Id, Version: ep.Version, Cardinality: ep.Contract.Cardinality}
+
+			switch {
+			case len(attached) > 1:
+				ep.rejected = make(map[*extension]bool, len(attached)-1)
+				for _, ext := range attached[1:] {
+					ep.rejected[ext] = true
+					violation.Rejected = append(violation.Rejected, ext.Id)
+				}
+
+				violation.Reason = "accepts one extension but " + strconv.Itoa(len(attached)) + " are attached, only " + attached[0].Id + " is used"
+			case len(attached) == 0 && ep.Contract.Cardinality == CardinalitySingle:
+				violation.Reason = "requires one extension but none is attached"
+			default:
+				continue
+			}
+
+			violations = append(violations, violation)
+		}
+	}
+
+	e.cardinalityViolations = violations
+}
+
+// payloadSchemas
+//
+// Returns the schemas the payloads of a call to the extension are validated against: those of the highest version of
+// its extension point. It must be called with the engine lock held.
+func (e *Engine) payloadSchemas(ext *extension) contractSchemas {
+	var chosen *extensionPoint
+	var highest *semver
+	for _, ep := range e.extensionPoints[ext.ExtensionPoint] {
+		v, err := parseSemver(ep.Version)
+		if nil == chosen || (nil == err && (nil == highest || v.compare(highest) > 0)) {
+			chosen = ep
+			if nil == err {
+				highest = v
+			}
+		}
+	}
+
+	if nil == chosen {
+		return contractSchemas{}
+	}
+
+	return chosen.schemas
+}
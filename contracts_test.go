@@ -0,0 +1,150 @@
+package pluginengine
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	extism "github.com/extism/go-sdk"
+	gopdk "github.com/spirefy/go-pdk"
+	"gopkg.in/yaml.v3"
+)
+
+func TestEnforceCardinality(t *testing.T) {
+	engine := newTestEngine(t)
+	err := engine.RegisterHostExtensionPointWithContract("com.acme.formatter", "Formatter", "1.0.0", "", ExtensionPointContract{Cardinality: CardinalitySingle}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	violations := engine.GetResolutionReport().CardinalityViolations
+	if len(violations) != 1 || len(violations[0].Rejected) != 0 {
+		t.Fatalf("Expected a single extension point without extensions to be reported, but got %+v", violations)
+	}
+
+	add := func(id, extId string, priority int) {
+		engine.addPlugin(&plugin{Ordering: map[string]ExtensionOrder{extId: {Priority: priority}}}, gopdk.Plugin{
+			Id:         id,
+			Version:    "1.0.0",
+			Extensions: []gopdk.Extension{{Id: extId, ExtensionPoint: "com.acme.formatter"}},
+		})
+	}
+
+	add("com.acme.plain", "com.acme.plain.format", 0)
+	add("com.acme.pretty", "com.acme.pretty.format", 10)
+
+	exts, err := engine.GetExtensionsForExtensionPoint("com.acme.formatter", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(exts) != 1 || exts[0].Id != "com.acme.pretty.format" {
+		t.Errorf("Expected only the highest priority extension of a single extension point, but got %d", len(exts))
+	}
+
+	violations = engine.GetResolutionReport().CardinalityViolations
+	if len(violations) != 1 || len(violations[0].Rejected) != 1 || violations[0].Rejected[0] != "com.acme.plain.format" {
+		t.Fatalf("Expected the other extension to be reported as rejected, but got %+v", violations)
+	}
+
+	if err := engine.UnloadPlugin("com.acme.pretty", "1.0.0"); err != nil {
+		t.Fatal(err)
+	}
+
+	if exts, _ := engine.GetExtensionsForExtensionPoint("com.acme.formatter", nil); len(exts) != 1 || exts[0].Id != "com.acme.plain.format" {
+		t.Errorf("Expected the rejected extension to take over once the other is unloaded")
+	}
+
+	if violations := engine.GetResolutionReport().CardinalityViolations; len(violations) != 0 {
+		t.Errorf("Expected no cardinality violations, but got %+v", violations)
+	}
+}
+
+func TestPayloadValidation(t *testing.T) {
+	engine, err := NewPluginEngine(nil, extism.LogLevelOff, t.TempDir(), WithPayloadValidation())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	contract := ExtensionPointContract{
+		InputSchema:  []byte(`{"type": "object", "required": ["text"], "properties": {"text": {"type": "string"}}}`),
+		OutputSchema: []byte(`{"type": "string"}`),
+	}
+	if err := engine.RegisterHostExtensionPointWithContract("com.acme.formatter", "Formatter", "1.0.0", "", contract, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	native := &testNativePlugin{
+		manifest: NativeManifest{Plugin: gopdk.Plugin{
+			Id:         "com.acme.native",
+			Version:    "1.0.0",
+			Extensions: []gopdk.Extension{{Id: "com.acme.native.format", ExtensionPoint: "com.acme.formatter", Func: "format"}},
+		}},
+		call: func(ctx context.Context, fn string, data []byte) ([]byte, error) {
+			if string(data) == `{"text": "raw"}` {
+				return []byte(`{"not": "a string"}`), nil
+			}
+			return []byte(`"formatted"`), nil
+		},
+	}
+
+	if err := engine.RegisterNativePlugin(native); err != nil {
+		t.Fatal(err)
+	}
+
+	if out, err := engine.CallExtensionFunc("com.acme.native.format", []byte(`{"text": "hello"}`)); err != nil || string(out) != `"formatted"` {
+		t.Errorf("Expected a valid payload to be passed through, but got %q %v", out, err)
+	}
+
+	for _, data := range []string{`{"size": 12}`, `not json`, ``} {
+		if _, err := engine.CallExtensionFunc("com.acme.native.format", []byte(data)); !errors.Is(err, ErrInvalidPayload) {
+			t.Errorf("Expected ErrInvalidPayload for input %q, but got %v", data, err)
+		}
+	}
+
+	if len(native.calls) != 1 {
+		t.Errorf("Expected invalid input not to reach the plugin, but got %d calls", len(native.calls))
+	}
+
+	if _, err := engine.CallExtensionFunc("com.acme.native.format", []byte(`{"text": "raw"}`)); !errors.Is(err, ErrInvalidPayload) {
+		t.Errorf("Expected ErrInvalidPayload for invalid output, but got %v", err)
+	}
+}
+
+func TestPluginManifest_Contracts(t *testing.T) {
+	manifest := `
+id: com.acme.editor
+version: 1.0.0
+extensionPoints:
+  - id: com.acme.editor.formatter
+    version: 1.0.0
+    cardinality: optional
+    inputSchema:
+      type: object
+      required: [text]
+`
+
+	m := pluginManifest{}
+	if err := yaml.Unmarshal([]byte(manifest), &m); err != nil {
+		t.Fatal(err)
+	}
+
+	contracts, err := m.contracts()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	contract := contracts["com.acme.editor.formatter"]
+	if contract.Cardinality != CardinalityOptional || string(contract.InputSchema) != `{"required":["text"],"type":"object"}` || nil != contract.OutputSchema {
+		t.Errorf("Expected the contract from the manifest, but got %+v", contract)
+	}
+
+	for _, invalid := range []extensionPointManifest{
+		{Id: "com.acme.a", Cardinality: "several"},
+		{Id: "com.acme.b", InputSchema: map[string]interface{}{"type": 5}},
+	} {
+		if _, err := (pluginManifest{ExtensionPoints: []extensionPointManifest{invalid}}).contracts(); err == nil {
+			t.Errorf("Expected the contract of %s to be refused", invalid.Id)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package pluginengine
+
+import (
+	"errors"
+	"sort"
+)
+
+// dependency is a hard requirement declared in a plugin manifest. Either Plugin or ExtensionPoint is set, along
+// with an optional Version constraint (see parseVersionConstraint) the provider's version must satisfy. A plugin
+// is only resolved, and can only be instantiated, when all of its dependencies are provided by resolved plugins.
+//
+//	dependencies:
+//	  - plugin: com.acme.core
+//	    version: ^1.0
+//	  - extensionPoint: com.acme.menu
+//	    version: ">=1.0 <2.0"
+type dependency struct {
+	Plugin         string `json:"plugin,omitempty" yaml:"plugin"`
+	ExtensionPoint string `json:"extensionPoint,omitempty" yaml:"extensionPoint"`
+	Version        string `json:"version,omitempty" yaml:"version"`
+}
+
+// String
+//
+// Returns a short description of the dependency for messages, e.g. "plugin com.acme.core ^1.0".
+func (d dependency) String() string {
+	s := "plugin " + d.Plugin
+	if len(d.ExtensionPoint) > 0 {
+		s = "extension point " + d.ExtensionPoint
+	}
+
+	if len(d.Version) > 0 {
+		s += " " + d.Version
+	}
+
+	return s
+}
+
+// validate
+//
+// Returns an error when the dependency names neither a plugin nor an extension point, as nothing could provide it.
+func (d dependency) validate() error {
+	if len(d.Plugin) == 0 && len(d.ExtensionPoint) == 0 {
+		return errors.New("invalid dependency, it needs a plugin or an extensionPoint")
+	}
+
+	return nil
+}
+
+// versionMatches
+//
+// Returns true when version satisfies the constraint. An empty constraint matches any version.
+func versionMatches(version, constraint string) bool {
+	if len(constraint) == 0 {
+		return true
+	}
+
+	c, err := constraintFromVersions([]string{constraint})
+	if nil != err {
+		return false
+	}
+
+	v, err := parseSemver(version)
+	if nil != err {
+		return false
+	}
+
+	return c.check(v)
+}
+
+// dependencyProviders
+//
+// Returns the resolved plugins that satisfy the dependency of plugin p and whether the dependency is satisfied at all.
+// A plugin dependency is provided by the highest matching resolved version of that plugin. An extension point
+// dependency is provided by the resolved owners of every matching extension point version, host extension points and
+// p's own extension points are satisfied without a provider.
+func (e *Engine) dependencyProviders(p *plugin, dep dependency) ([]*plugin, bool) {
+	if len(dep.ExtensionPoint) > 0 {
+		providers := make([]*plugin, 0)
+		satisfied := false
+
+		for _, ep := range e.extensionPoints[dep.ExtensionPoint] {
+			if !versionMatches(ep.Version, dep.Version) {
+				continue
+			}
+
+			if len(ep.Plugin.Id) == 0 || (ep.Plugin.Id == p.Id && ep.Plugin.Version == p.Version) {
+				satisfied = true
+				continue
+			}
+
+			owner := e.plugins[ep.Plugin.Id][ep.Plugin.Version]
+			if nil != owner && owner.Resolved {
+				satisfied = true
+				providers = append(providers, owner)
+			}
+		}
+
+		return providers, satisfied
+	}
+
+	var best *plugin
+	var bestVersion *semver
+
+	for version, candidate := range e.plugins[dep.Plugin] {
+		if !candidate.Resolved || !versionMatches(version, dep.Version) {
+			continue
+		}
+
+		v, err := parseSemver(version)
+		if nil != err {
+			// only reachable without a version constraint
+			if nil == best {
+				best = candidate
+			}
+			continue
+		}
+
+		if nil == bestVersion || v.compare(bestVersion) > 0 {
+			best, bestVersion = candidate, v
+		}
+	}
+
+	if nil == best {
+		return nil, false
+	}
+
+	return []*plugin{best}, true
+}
+
+// resolvePlugins
+//
+// Computes the plugin dependency graph and marks a plugin resolved only when every dependency in its closure is
+// satisfied. Resolution is repeated until nothing changes, so the order plugins were loaded in does not matter.
+// Plugins that take part in a dependency cycle never become resolved.
+func (e *Engine) resolvePlugins() {
+	all := e.sortedPlugins()
+
+	for _, p := range all {
+		p.Resolved = false
+		p.dependsOn = nil
+	}
+
+	for changed := true; changed; {
+		changed = false
+
+		for _, p := range all {
+			if p.Resolved {
+				continue
+			}
+
+			dependsOn := make([]*plugin, 0)
+			satisfied := true
+
+			for _, dep := range p.Dependencies {
+				providers, ok := e.dependencyProviders(p, dep)
+				if !ok {
+					satisfied = false
+					break
+				}
+
+				dependsOn = append(dependsOn, providers...)
+			}
+
+			if satisfied {
+				p.Resolved = true
+				p.dependsOn = dependsOn
+				changed = true
+			}
+		}
+	}
+}
+
+// sortedPlugins
+//
+// Returns every plugin version registered with the engine ordered by id and version, so that work done across all
+// plugins happens in a stable order regardless of map iteration.
+func (e *Engine) sortedPlugins() []*plugin {
+	all := make([]*plugin, 0)
+	for _, versions := range e.plugins {
+		for _, p := range versions {
+			all = append(all, p)
+		}
+	}
+
+	sort.SliceStable(all, func(i, j int) bool {
+		if all[i].Id != all[j].Id {
+			return all[i].Id < all[j].Id
+		}
+		return all[i].Version < all[j].Version
+	})
+
+	return all
+}
+
+// startOrder
+//
+// Returns the given plugins together with everything they depend on, in topological order: a plugin always comes
+// after the plugins it depends on, so instantiating in this order means a plugin's start can call its dependencies.
+func (e *Engine) startOrder(roots []*plugin) []*plugin {
+	order := make([]*plugin, 0)
+	visited := make(map[*plugin]bool)
+
+	var visit func(p *plugin)
+	visit = func(p *plugin) {
+		if visited[p] {
+			return
+		}
+
+		visited[p] = true
+		for _, dep := range p.dependsOn {
+			visit(dep)
+		}
+
+		order = append(order, p)
+	}
+
+	for _, p := range roots {
+		visit(p)
+	}
+
+	return order
+}
@@ -0,0 +1,111 @@
+package pluginengine
+
+import (
+	"archive/tar"
+	"context"
+	"path/filepath"
+	"testing"
+
+	gopdk "github.com/spirefy/go-pdk"
+)
+
+func addTestPlugin(e *Engine, id, version string, deps []dependency, eps ...string) *plugin {
+	p := &plugin{Dependencies: deps}
+	plug := gopdk.Plugin{Id: id, Version: version}
+
+	for _, ep := range eps {
+		plug.ExtensionPoints = append(plug.ExtensionPoints, gopdk.ExtensionPoint{Id: ep, Version: "1.0.0"})
+	}
+
+	e.addPlugin(p, plug)
+
+	return p
+}
+
+func TestResolvePlugins(t *testing.T) {
+	engine := newTestEngine(t)
+
+	// load the dependent plugins first to make sure load order does not matter
+	app := addTestPlugin(engine, "com.acme.app", "1.0.0", []dependency{{Plugin: "com.acme.ui", Version: "^2.0"}})
+	ui := addTestPlugin(engine, "com.acme.ui", "2.1.0", []dependency{{ExtensionPoint: "com.acme.core.commands", Version: "^1.0"}})
+	old := addTestPlugin(engine, "com.acme.legacy", "1.0.0", []dependency{{Plugin: "com.acme.ui", Version: "^1.0"}})
+
+	if app.Resolved || ui.Resolved {
+		t.Fatalf("Expected plugins to be unresolved before their dependencies are loaded")
+	}
+
+	core := addTestPlugin(engine, "com.acme.core", "1.0.0", nil, "com.acme.core.commands")
+
+	if !core.Resolved || !ui.Resolved || !app.Resolved {
+		t.Errorf("Expected dependency closure to be resolved, got core=%v ui=%v app=%v", core.Resolved, ui.Resolved, app.Resolved)
+	}
+
+	if old.Resolved {
+		t.Errorf("Expected plugin with unsatisfied version constraint to stay unresolved")
+	}
+
+	order := engine.startOrder([]*plugin{app})
+	expected := []*plugin{core, ui, app}
+	if len(order) != len(expected) {
+		t.Fatalf("Expected start order of %d plugins, but got %d", len(expected), len(order))
+	}
+
+	for i, p := range expected {
+		if order[i] != p {
+			t.Errorf("Expected %s at position %d of start order, but got %s", p.Id, i, order[i].Id)
+		}
+	}
+}
+
+func TestResolvePlugins_Cycle(t *testing.T) {
+	engine := newTestEngine(t)
+
+	a := addTestPlugin(engine, "com.acme.a", "1.0.0", []dependency{{Plugin: "com.acme.b"}})
+	b := addTestPlugin(engine, "com.acme.b", "1.0.0", []dependency{{Plugin: "com.acme.a"}})
+
+	if a.Resolved || b.Resolved {
+		t.Errorf("Expected plugins in a dependency cycle to stay unresolved")
+	}
+
+	if err := engine.instantiateWithDependencies(context.Background(), a); err == nil {
+		t.Errorf("Expected error instantiating an unresolved plugin")
+	}
+}
+
+func TestResolve_LateExtensionPoint(t *testing.T) {
+	engine := newTestEngine(t)
+
+	p := &plugin{}
+	engine.addPlugin(p, gopdk.Plugin{
+		Id:         "com.acme.menuitems",
+		Version:    "1.0.0",
+		Extensions: []gopdk.Extension{{Id: "com.acme.menuitems.file", ExtensionPoint: "com.acme.late.menu"}},
+	})
+
+	if len(engine.unresolved) != 1 {
+		t.Fatalf("Expected extension to stay unresolved until its extension point is loaded")
+	}
+
+	engine.RegisterHostExtensionPoint("com.acme.late.menu", "Menu", "1.0.0", "", nil)
+
+	if nil == engine.GetExtensionForId("com.acme.menuitems.file") {
+		t.Errorf("Expected extension to resolve once its extension point is registered")
+	}
+}
+
+func TestLoadPluginArchive_EmptyDependency(t *testing.T) {
+	archive := filepath.Join(t.TempDir(), "plugins.tar.gz")
+	writeTestTar(t, archive, []archiveEntry{
+		{name: "a/plugin.yaml", typeflag: tar.TypeReg, contents: "id: com.acme.a\nversion: 1.0.0\ndependencies:\n  - version: ^1.0\n"},
+		{name: "a/plugin.wasm", typeflag: tar.TypeReg, contents: "\x00asm"},
+	})
+
+	engine := newTestEngine(t)
+	if err := engine.loadPluginArchive(archive, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if nil != engine.GetPlugins()["com.acme.a"] {
+		t.Errorf("Expected a dependency without a plugin or an extensionPoint to be refused")
+	}
+}
@@ -0,0 +1,247 @@
+package pluginengine
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultDownloadTimeout is the overall time allowed for a single plugin archive download, including redirects.
+	defaultDownloadTimeout = 5 * time.Minute
+	// downloadsDir is the directory of the engine's pluginPath that downloaded archives are cached and extracted in,
+	// each in a directory of its own named after the URL, see downloadDir.
+	downloadsDir = "downloads"
+)
+
+// downloadMeta is stored next to a downloaded archive so that a later Load of the same URL can make a conditional
+// request and skip the download when the server reports the archive has not changed.
+type downloadMeta struct {
+	URL          string `json:"url"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+}
+
+// archiveNameFromURL
+//
+// Returns the .tar.gz or .zip file name at the end of the URL path, or an empty string if the URL does not point to
+// a supported plugin archive.
+func archiveNameFromURL(u *url.URL) string {
+	name := path.Base(u.Path)
+	lower := strings.ToLower(name)
+	if strings.HasSuffix(lower, ".tar.gz") || strings.HasSuffix(lower, ".zip") {
+		return name
+	}
+
+	return ""
+}
+
+// downloadDir
+//
+// Returns the directory the archive at the URL is downloaded to and extracted in. It is named after the SHA-256 of the
+// whole URL, so archives of the same name at different URLs do not overwrite each other.
+func (e *Engine) downloadDir(rawURL string) string {
+	sum := sha256.Sum256([]byte(rawURL))
+	return filepath.Join(e.pluginPath, downloadsDir, hex.EncodeToString(sum[:16]))
+}
+
+// isDownloaded
+//
+// Returns true when the archive was downloaded by Load, so it is extracted next to it rather than in pluginPath.
+func (e *Engine) isDownloaded(archive string) bool {
+	return within(filepath.Join(e.pluginPath, downloadsDir), archive)
+}
+
+// readDownloadMeta
+//
+// Reads the cache metadata saved alongside a previously downloaded archive. A missing or unreadable file simply means
+// there is nothing cached, so nil is returned rather than an error.
+func readDownloadMeta(metaPath string) *downloadMeta {
+	data, err := os.ReadFile(metaPath)
+	if nil != err {
+		return nil
+	}
+
+	meta := &downloadMeta{}
+	if err := json.Unmarshal(data, meta); nil != err {
+		return nil
+	}
+
+	return meta
+}
+
+// download
+//
+// This receiver function downloads the plugin archive at the provided http/https URL in to the engine's pluginPath
+// (see downloadDir) and returns the local path of the archive. Redirects are followed by the engine's http client. If
+// the archive was downloaded before, the ETag and Last-Modified values the server returned are sent back as
+// If-None-Match and If-Modified-Since so that an unchanged archive is not downloaded again and the cached copy is used
+// instead. An archive larger than the MaxArchiveSize of the engine's extract limits is refused while it downloads.
+func (e *Engine) download(rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if nil != err {
+		return "", err
+	}
+
+	name := archiveNameFromURL(u)
+	if len(name) == 0 {
+		return "", errors.New("plugin URL does not point to a .tar.gz or .zip archive: " + rawURL)
+	}
+
+	dir := e.downloadDir(rawURL)
+	if err := os.MkdirAll(dir, 0755); nil != err {
+		return "", err
+	}
+
+	archivePath := filepath.Join(dir, name)
+	metaPath := archivePath + ".meta"
+
+	req, err := http.NewRequestWithContext(e.context, http.MethodGet, rawURL, nil)
+	if nil != err {
+		return "", err
+	}
+
+	// only send conditional headers when we still have the archive they describe
+	meta := readDownloadMeta(metaPath)
+	if nil != meta && meta.URL == rawURL {
+		if _, err := os.Stat(archivePath); nil == err {
+			if len(meta.ETag) > 0 {
+				req.Header.Set("If-None-Match", meta.ETag)
+			}
+			if len(meta.LastModified) > 0 {
+				req.Header.Set("If-Modified-Since", meta.LastModified)
+			}
+		}
+	}
+
+	resp, err := e.httpClient.Do(req)
+	if nil != err {
+		return "", err
+	}
+
+	defer func(body io.ReadCloser) {
+		err := body.Close()
+		if err != nil {
+			e.logger.Warn("error closing download response body", "url", rawURL, "error", err)
+		}
+	}(resp.Body)
+
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		// the cached archive is still current
+		return archivePath, nil
+	case http.StatusOK:
+	default:
+		return "", fmt.Errorf("unexpected status downloading plugin %s: %s", rawURL, resp.Status)
+	}
+
+	// write to a temp file first so that a failed or partial download never replaces a good cached archive
+	tmp, err := os.CreateTemp(dir, name+".*.part")
+	if nil != err {
+		return "", err
+	}
+
+	body := io.Reader(resp.Body)
+	limit := e.extractLimits.MaxArchiveSize
+	if limit > 0 {
+		// one byte over the limit is enough to tell the archive is too large
+		body = io.LimitReader(resp.Body, limit+1)
+	}
+
+	n, err := io.Copy(tmp, body)
+	if nil == err && limit > 0 && n > limit {
+		err = fmt.Errorf("%w: %s is larger than %d bytes", ErrArchiveLimit, rawURL, limit)
+	}
+	if closeErr := tmp.Close(); nil == err {
+		err = closeErr
+	}
+
+	if nil != err {
+		_ = os.Remove(tmp.Name())
+		return "", err
+	}
+
+	if err := os.Rename(tmp.Name(), archivePath); nil != err {
+		_ = os.Remove(tmp.Name())
+		return "", err
+	}
+
+	meta = &downloadMeta{
+		URL:          rawURL,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+	}
+
+	if len(meta.ETag) > 0 || len(meta.LastModified) > 0 {
+		data, err := json.Marshal(meta)
+		if nil == err {
+			err = os.WriteFile(metaPath, data, 0644)
+		}
+
+		if nil != err {
+			// not fatal, the next load just downloads the archive again
+			e.logger.Warn("error saving plugin download cache metadata", "url", rawURL, "error", err)
+		}
+	} else {
+		_ = os.Remove(metaPath)
+	}
+
+	return archivePath, nil
+}
+
+// downloadSignature
+//
+// Downloads the detached signature published next to the plugin archive at rawURL (the URL with .sig appended to its
+// path) to the signature file of the downloaded archive, see SignArchive. A signature the server does not have, or one
+// that could not be downloaded, leaves the archive without a signature file rather than with a stale one.
+func (e *Engine) downloadSignature(rawURL, archivePath string) error {
+	sigPath := archivePath + signatureSuffix
+	_ = os.Remove(sigPath)
+
+	u, err := url.Parse(rawURL)
+	if nil != err {
+		return err
+	}
+	u.Path += signatureSuffix
+	u.RawPath = ""
+
+	req, err := http.NewRequestWithContext(e.context, http.MethodGet, u.String(), nil)
+	if nil != err {
+		return err
+	}
+
+	resp, err := e.httpClient.Do(req)
+	if nil != err {
+		return err
+	}
+
+	defer func(body io.ReadCloser) {
+		_ = body.Close()
+	}(resp.Body)
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil
+	default:
+		return fmt.Errorf("unexpected status downloading plugin signature %s: %s", u.String(), resp.Status)
+	}
+
+	// a signature is a line of base64, anything much longer is not one
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if nil != err {
+		return err
+	}
+
+	return os.WriteFile(sigPath, data, 0644)
+}
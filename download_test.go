@@ -0,0 +1,166 @@
+package pluginengine
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	extism "github.com/extism/go-sdk"
+)
+
+func createPluginArchive(t *testing.T, id, version string) []byte {
+	var buf bytes.Buffer
+	gzipWriter := gzip.NewWriter(&buf)
+	tarWriter := tar.NewWriter(gzipWriter)
+
+	files := map[string]string{
+		"plugin.yaml": "id: " + id + "\nversion: " + version + "\n",
+		"plugin.wasm": "\x00asm",
+	}
+
+	for name, contents := range files {
+		header := &tar.Header{Name: name, Mode: 0644, Size: int64(len(contents)), Typeflag: tar.TypeReg}
+		if err := tarWriter.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tarWriter.Write([]byte(contents)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := tarWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gzipWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func newTestEngine(t *testing.T) *Engine {
+	engine, err := NewPluginEngine(nil, extism.LogLevelOff, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return engine
+}
+
+func TestLoad_URL(t *testing.T) {
+	archive := createPluginArchive(t, "com.acme.download", "1.0.0")
+	downloads := 0
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/old/plugin.tar.gz", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/plugins/plugin.tar.gz", http.StatusFound)
+	})
+	mux.HandleFunc("/plugins/plugin.tar.gz", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		downloads++
+		w.Header().Set("ETag", `"v1"`)
+		_, _ = w.Write(archive)
+	})
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	engine := newTestEngine(t)
+
+	for i := 0; i < 2; i++ {
+		if err := engine.Load(server.URL + "/old/plugin.tar.gz"); err != nil {
+			t.Fatalf("Expected no error loading plugin URL, but got %v", err)
+		}
+	}
+
+	if downloads != 1 {
+		t.Errorf("Expected archive to be downloaded once, but got %d downloads", downloads)
+	}
+
+	if nil == engine.GetPlugins()["com.acme.download"]["1.0.0"] {
+		t.Errorf("Expected downloaded plugin to be registered")
+	}
+
+	if _, err := os.Stat(filepath.Join(engine.downloadDir(server.URL+"/old/plugin.tar.gz"), "plugin.tar.gz")); err != nil {
+		t.Errorf("Expected archive in plugin path, but got %v", err)
+	}
+}
+
+func TestLoad_URLSameName(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/a/plugin.tar.gz", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"a"`)
+		_, _ = w.Write(createPluginArchive(t, "com.acme.a", "1.0.0"))
+	})
+	mux.HandleFunc("/b/plugin.tar.gz", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"a"` {
+			t.Errorf("Expected the ETag of one URL not to be sent for another")
+		}
+		_, _ = w.Write(createPluginArchive(t, "com.acme.b", "1.0.0"))
+	})
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	engine := newTestEngine(t)
+	for _, u := range []string{"/a/plugin.tar.gz", "/b/plugin.tar.gz", "/a/plugin.tar.gz"} {
+		if err := engine.Load(server.URL + u); err != nil {
+			t.Fatalf("Expected no error loading %s, but got %v", u, err)
+		}
+	}
+
+	if nil == engine.GetPlugins()["com.acme.a"] || nil == engine.GetPlugins()["com.acme.b"] {
+		t.Errorf("Expected the plugins of both archives named plugin.tar.gz to be loaded")
+	}
+
+	if engine.downloadDir(server.URL+"/a/plugin.tar.gz") == engine.downloadDir(server.URL+"/b/plugin.tar.gz") {
+		t.Errorf("Expected each URL to be downloaded to a directory of its own")
+	}
+}
+
+func TestLoad_URLTooLarge(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(make([]byte, 4096))
+	}))
+	defer server.Close()
+
+	limits := DefaultExtractLimits()
+	limits.MaxArchiveSize = 1024
+	engine, err := NewPluginEngine(nil, extism.LogLevelOff, t.TempDir(), WithExtractLimits(limits))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := engine.Load(server.URL + "/plugin.zip"); !errors.Is(err, ErrArchiveLimit) {
+		t.Errorf("Expected ErrArchiveLimit for an archive larger than MaxArchiveSize, but got %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(engine.downloadDir(server.URL+"/plugin.zip"), "plugin.zip")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected the partial download to be removed, but got %v", err)
+	}
+}
+
+func TestLoad_URLErrors(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	engine := newTestEngine(t)
+
+	if err := engine.Load(server.URL + "/missing.zip"); err == nil {
+		t.Errorf("Expected error for missing archive, but got nil")
+	}
+
+	if err := engine.Load(server.URL + "/plugin.wasm"); err == nil {
+		t.Errorf("Expected error for non archive URL, but got nil")
+	}
+}
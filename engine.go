@@ -2,14 +2,20 @@ package pluginengine
 
 import (
 	"context"
+	"crypto/ed25519"
 	"errors"
 	"fmt"
+	"io"
 	"io/fs"
-	"net/url"
+	"log/slog"
+	"net/http"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
-	"unicode"
+	"sync"
+	"time"
 
 	extism "github.com/extism/go-sdk"
 	gopdk "github.com/spirefy/go-pdk"
@@ -22,41 +28,120 @@ type (
 		gopdk.ExtensionPoint `json:"extensionPoint" yaml:"extensionPoint"`
 		// Because this outer ExtensionPoint wrapper allows for host extension points, which are native to Go, a func pointer
 		// to call upon that extension point is necessary. This is not the typical wasm string func name to call, but an
-		// actual Go function provided by the host to be called
-		Func       func([]*extension) error
-		Extensions []*extension `json:"extensions" yaml:"extensions"`
-		Plugin     plugin       `json:"plugin" yaml:"plugin"`
+		// actual Go function provided by the host to be called, see notifyExtensionPoints
+		Func       HostExtensionPointFunc
+		Extensions []*extension           `json:"extensions" yaml:"extensions"`
+		Plugin     plugin                 `json:"plugin" yaml:"plugin"`
+		Contract   ExtensionPointContract `json:"contract" yaml:"contract"`
+		// the extensions Func was last called with
+		notified []*extension
+		// the extensions left out because of the contract's cardinality, see enforceCardinality
+		rejected map[*extension]bool
+		schemas  contractSchemas
 	}
 
 	extension struct {
 		gopdk.Extension `json:"extension" yaml:"extension"`
-		Plugin          plugin `json:"plugin" yaml:"plugin"`
-		Resolved        bool   `json:"resolved" yaml:"resolved"`
+		Plugin          plugin         `json:"plugin" yaml:"plugin"`
+		Resolved        bool           `json:"resolved" yaml:"resolved"`
+		Order           ExtensionOrder `json:"order" yaml:"order"`
 	}
 
 	plugin struct {
-		Plugin       *extism.Plugin `json:"plugin" yaml:"plugin"`
-		PathToModule string         `json:"pathToModule" yaml:"pathToModule"`
-		Resolved     bool           `json:"resolved" yaml:"resolved"`
-		LoadOnStart  bool           `json:"loadOnStart" yaml:"loadOnStart"`
+		Id           string `json:"id" yaml:"id"`
+		Version      string `json:"version" yaml:"version"`
+		PathToModule string `json:"pathToModule" yaml:"pathToModule"`
+		Archive      string `json:"archive" yaml:"archive"` // the .tar.gz or .zip the plugin was loaded from
+		// the SHA-256 of the module the plugin is pinned to, see checkModuleDigest, empty when it is not pinned
+		Hash string `json:"hash" yaml:"hash"`
+		// the host function capabilities the plugin's manifest requests, see CapabilityKind
+		Capabilities []string `json:"capabilities" yaml:"capabilities"`
+		// the requested capabilities the engine's capability policy grants, and the ones it denies
+		granted            []*capability
+		deniedCapabilities []string
+		// the name of the trusted key the archive is signed with, empty when its signature was not verified
+		Signer       string       `json:"signer" yaml:"signer"`
+		Resolved     bool         `json:"resolved" yaml:"resolved"`
+		LoadOnStart  bool         `json:"loadOnStart" yaml:"loadOnStart"`
+		Dependencies []dependency `json:"dependencies" yaml:"dependencies"`
+		// default time limit for calls in to this plugin when the caller's context has no deadline, 0 is no limit
+		Timeout time.Duration `json:"timeout" yaml:"timeout"`
+		// how many instances of this plugin may run calls at once, 0 uses the engine's instance pool size
+		PoolSize int `json:"poolSize" yaml:"poolSize"`
+		// the event listeners declared in the plugin's manifest, registered on the event bus when the plugin is added
+		Listeners []eventListener `json:"listeners" yaml:"listeners"`
+		// the priority and before/after constraints of the plugin's extensions, keyed on extension id
+		Ordering map[string]ExtensionOrder `json:"ordering" yaml:"ordering"`
+		// the contracts of the plugin's extension points, keyed on extension point id
+		Contracts map[string]ExtensionPointContract `json:"contracts" yaml:"contracts"`
+		// the resolved plugins this plugin's dependencies are provided by, set by resolvePlugins
+		dependsOn []*plugin
+		// the running instances of the plugin, see instancePool
+		instances *instancePool
+		// set for a Go plugin registered with RegisterNativePlugin, which is called in place of a wasm instance
+		native *nativeInstance
+		// the extensions this plugin contributes, resolved or not
+		extensions []*extension
 	}
 
+	// Engine is safe for concurrent use. mu guards the registry (plugins, extension points, extensions and the
+	// lifecycle flags) and is never held while calling in to a plugin, since plugins call back in to the engine
+	// through the host functions.
 	Engine struct {
+		mu              sync.RWMutex
 		context         context.Context
 		logLevel        extism.LogLevel
 		plugins         map[string]map[string]*plugin
 		extensionPoints map[string][]*extensionPoint
 		extensions      map[string]*extension
-		unresolved      []*extension
-		hostFuncs       []extism.HostFunction
-		pluginPath      string // path where .tar.gz and .zip plugins will be extracted to (overwrite every time)
+		// This map holds pointers to plugins keyed on an extension ID. This can be used by CallExtension to quickly
+		// access the plugin of the extension ID to make a call to and check if its instantiated or not and resolved.
+		// It is rebuilt by routeExtensions on every resolve, which also decides which plugin an extension ID that is
+		// contributed by more than one plugin is routed to.
+		callableExtensions map[string]*plugin
+		extensionConflicts []ExtensionConflict
+		orderingCycles     []OrderingCycle
+		// the extension points with more or fewer extensions than their contract accepts, see enforceCardinality
+		cardinalityViolations []CardinalityViolation
+		unresolved            []*extension
+		hostFuncs             []extism.HostFunction
+		pluginPath            string       // path where .tar.gz and .zip plugins will be extracted to (overwrite every time)
+		httpClient            *http.Client // used by Load to download plugin archives from http/https locations
+		started               bool         // set by Start, plugins (re)loaded after that are started right away
+		shutdown              bool         // set by Shutdown, no plugin can be instantiated after that
+		watchInterval         time.Duration
+		watchListeners        []func(WatchEvent)
+		poolSize              int         // default number of instances per plugin, see instancePool
+		listeners             []*listener // the event listeners, in the order they were registered
+		notifications         []extensionPointNotification
+		notifying             bool                         // set while notifyExtensionPoints is calling host extension point funcs
+		events                *eventBus                    // delivers events to the listeners, see ConfigureTopic
+		validatePayloads      bool                         // set by WithPayloadValidation
+		logger                *slog.Logger                 // set by WithLogger, filtered by logLevel, see newEngineLogger
+		extractLimits         ExtractLimits                // set by WithExtractLimits, bounds what a plugin archive extracts to
+		signaturePolicy       SignaturePolicy              // set by WithSignaturePolicy, see checkSignature
+		trustedKeys           map[string]ed25519.PublicKey // set by WithTrustedKeys, keyed on signer name
+		lockfile              Lockfile                     // set by WithLockfile, see checkArchiveDigest
+		capabilityPolicy      []CapabilityRule             // set by WithCapabilityPolicy, nil does not restrict plugins
+		capabilityRules       []capabilityRule             // the compiled capabilityPolicy, see grantCapabilities
+		digestMismatches      []DigestMismatch
 	}
 )
 
-// This variable will hold pointers to plugins keyed on an extension ID. This can be used
-// by CallExtension to quickly access the extension ID to make a call to and check if its instantiated
-// or not and resolved.
-var callableExtensions = make(map[string]*plugin)
+// defaultStopTimeout is how long Shutdown waits for queued events to be delivered, and for each plugin's stop
+// function, when the context has no deadline. It is a var so tests can shorten it.
+var defaultStopTimeout = 10 * time.Second
+
+var (
+	// ErrEngineShutdown is returned when a plugin would need to be instantiated after the engine was shut down.
+	ErrEngineShutdown = errors.New("plugin engine has been shut down")
+	// ErrCallTimeout is wrapped by the error returned when an extension call runs past its deadline.
+	ErrCallTimeout = errors.New("extension call timed out")
+	// ErrReentrantCall is wrapped by the error returned when a plugin calls back in to itself, through the host
+	// functions, while it is starting or while all of its instances are in use. Rather than wait on itself, the call
+	// fails right away.
+	ErrReentrantCall = errors.New("reentrant plugin call")
+)
 
 func findFilesWithExtensions(root string, extensions []string) ([]string, error) {
 	var matchingFiles []string
@@ -87,10 +172,38 @@ func findFilesWithExtensions(root string, extensions []string) ([]string, error)
 //
 // This method will add the plugin passed in to the engine's plugins property. It will ensure that if a plugin
 // at the name and version provided does not yet exist, the map of internalPlugin objects is created.
-// It's important to note that if a plugin already exists at the name and version intersection, it is replaced. This
-// should allow for reloading (and eventual GC of old plugins as they are replaced) if need be.
+// It's important to note that if a plugin already exists at the name and version intersection, it is replaced. The
+// old plugin is unloaded first (see unloadPlugin) so its extensions and extension points are not left behind.
 func (e *Engine) addPlugin(p *plugin, plug gopdk.Plugin) {
+	e.mu.Lock()
+	stopping := e.registerPlugin(p, plug)
+	e.mu.Unlock()
+
+	e.notifyExtensionPoints()
+	e.stopPlugins(stopping)
+}
+
+// registerPlugin
+//
+// Does the work of addPlugin with the engine lock held. It returns the plugins that have to be stopped once the lock
+// is released: a replaced plugin and the running plugins that depend on it.
+func (e *Engine) registerPlugin(p *plugin, plug gopdk.Plugin) []*plugin {
+	stopping := make([]*plugin, 0)
+
 	if nil != e.plugins && nil != p {
+		if existing := e.plugins[plug.Id][plug.Version]; nil != existing && existing != p {
+			stopping = append(e.runningDependents(existing), existing)
+			e.unloadPlugin(existing)
+		}
+
+		if nil == p.instances {
+			size := p.PoolSize
+			if size <= 0 {
+				size = e.poolSize
+			}
+			p.instances = newInstancePool(size)
+		}
+
 		pv := e.plugins[plug.Id]
 
 		if nil == pv {
@@ -99,6 +212,9 @@ func (e *Engine) addPlugin(p *plugin, plug gopdk.Plugin) {
 		}
 
 		pv[plug.Version] = p
+		p.extensions = nil
+		p.Id = plug.Id
+		p.Version = plug.Version
 		p.LoadOnStart = plug.LoadOnStart
 
 		// now add all of this plugins extensions to the unresolved list... a call to engine.resolve() will then try to
@@ -109,31 +225,37 @@ func (e *Engine) addPlugin(p *plugin, plug gopdk.Plugin) {
 					Extension: ex,
 					Plugin:    *p,
 					Resolved:  false,
+					Order:     p.Ordering[ex.Id],
 				}
 
-				// for each extension, add a reference pointer to THIS plugin so that when calling any extension
-				// that is part of the same plugin owner, the pointer to the extism.Plugin instance can be used.
-				if callableExtensions[ex.Id] != nil {
-					fmt.Println("It appears an extension is already added to the callableExtensions at id: ", ex.Id)
-				} else {
-					callableExtensions[ex.Id] = p
-				}
-
+				// the extension is routed to THIS plugin by routeExtensions, once resolve has run
+				p.extensions = append(p.extensions, ee)
 				e.unresolved = append(e.unresolved, ee)
 			}
 		}
 
+		for _, l := range p.Listeners {
+			// a wasm plugin's manifest listeners need the same events.subscribe capability as AddEventListener
+			if nil == p.native && nil != e.capabilityRules &&
+				(e.denies(p.Id, CapabilityEventsSubscribe, l.Event) || !p.allows(CapabilityEventsSubscribe, l.Event)) {
+				e.pluginLogger(p).Warn("event listener denied by policy", "event", l.Event, "func", l.Func)
+				p.denyCapability(string(CapabilityEventsSubscribe) + ":" + l.Event)
+				continue
+			}
+
+			if el, err := newListener(l.Event, p, l.Func, nil); nil == err {
+				e.addListener(el)
+			} else {
+				e.pluginLogger(p).Warn("ignoring event listener", "event", l.Event, "func", l.Func, "error", err)
+			}
+		}
+
 		// now add all the plugins extension points to the engines extension points using the ExtensionPoint object
 		// that will tie this plugin instance to it as well.
 
 		if nil != plug.ExtensionPoints && len(plug.ExtensionPoints) > 0 {
 			for _, ep := range plug.ExtensionPoints {
-				eep := &extensionPoint{
-					ExtensionPoint: ep,
-					Func:           nil,
-					Extensions:     nil,
-					Plugin:         *p,
-				}
+				eep := e.newExtensionPoint(ep, p.Contracts[ep.Id], *p)
 
 				eps := e.extensionPoints[ep.Id]
 				if nil == eps {
@@ -148,47 +270,26 @@ func (e *Engine) addPlugin(p *plugin, plug gopdk.Plugin) {
 	}
 
 	e.resolve()
+
+	return stopping
 }
 
-// validateVersion
+// isSemverValid
 //
-// TODO: For now this just returns true. It needs to add a check to make sure a version string matches a semver version
-// value
+// Returns true when the version string is a full Semantic Versioning 2.0.0 version, including optional pre-release
+// and build metadata (e.g. 1.4.0-rc.1+build.5).
 func isSemverValid(version string) bool {
-	// Split the version string into major, minor, and patch components
-	parts := strings.Split(version, ".")
-	if len(parts) != 3 {
-		return false
-	}
-
-	// Check if each component is a non-negative integer
-	for _, part := range parts {
-		if !isValidNumber(part) {
-			return false
-		}
-	}
-	return true
-}
-
-// isValidNumber
-// helper func used by isSemverValid
-func isValidNumber(str string) bool {
-	if len(str) == 0 || str[0] == '-' {
-		return false
-	}
-
-	for _, c := range str {
-		if !unicode.IsDigit(c) {
-			return false
-		}
-	}
-	return true
+	_, err := parseSemver(version)
+	return nil == err
 }
 
 // GetExtensionForId
 //
 // This function will look for a single extension based on it's id (and version?) and return it if found, nil otherwise
 func (e *Engine) GetExtensionForId(eid string) *gopdk.Extension {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
 	ext := e.extensions[eid]
 
 	if nil != ext && ext.Resolved {
@@ -200,54 +301,84 @@ func (e *Engine) GetExtensionForId(eid string) *gopdk.Extension {
 
 // GetExtensionsForExtensionPoint
 //
-// This method will look for a matching endpoint in the map of endpoints and if found and the version provided is not
-// nil, look for a matching version (TODO: version range may be added in future). If version is nil, the first
-// extension point's extensions are returned.
+// This method will look for a matching endpoint in the map of endpoints and if found and versions is not empty, return
+// the extensions of every extension point version that matches. versions may hold a single exact version, a single
+// constraint expression (e.g. ^1.2, ~1.4.0 or ">=1.0 <2.0", see parseVersionConstraint), or a [lower, upper] pair that
+// is treated as an inclusive range, an empty upper bound meaning an exact match on the lower one. Extensions of all
+// matching extension point versions are merged. If versions is empty, the first extension point's extensions are
+// returned. Extensions are returned in the order set by their priority and before/after constraints (see
+// ExtensionOrder), by id where nothing else decides.
 func (e *Engine) GetExtensionsForExtensionPoint(epoint string, versions []string) ([]*gopdk.Extension, error) {
-	fmt.Println("Looking for extension point: ", epoint)
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	e.logger.Debug("looking for extension point", "extensionPoint", epoint, "versions", versions)
 	eps := e.extensionPoints[epoint]
-	if nil != eps {
-		fmt.Println("WE GOT EPS FOR EP: ", epoint)
-	}
-	var lowerVersion, upperVersion string
 
+	var constraint versionConstraint
 	if len(versions) > 0 {
-		lowerVersion = versions[0]
-		if len(versions) > 1 {
-			upperVersion = versions[1]
+		var err error
+		constraint, err = constraintFromVersions(versions)
+		if nil != err {
+			return nil, err
 		}
+	}
 
-		if !isSemverValid(lowerVersion) {
-			return nil, errors.New("version or lower bound version is not a valid SemVer: " + lowerVersion)
+	if nil != eps && len(eps) > 0 {
+		if nil == constraint {
+			// no version provided
+			//so get them all
+			exts := make([]*gopdk.Extension, 0)
+			for _, epex := range e.attachedExtensions(eps[0]) {
+				exts = append(exts, &epex.Extension)
+			}
+			return exts, nil
 		}
 
-		if len(upperVersion) > 0 && !isSemverValid(upperVersion) {
-			return nil, errors.New("version or upper bound version is not a valid SemVer: " + upperVersion)
+		type matchingPoint struct {
+			version *semver
+			ep      *extensionPoint
 		}
-	}
 
-	if nil != eps && len(eps) > 0 {
-		if len(lowerVersion) > 0 {
-			for _, epVer := range eps {
-				// if only one version we need an exact match
-				if len(upperVersion) <= 0 {
-					if epVer.Version == lowerVersion {
-						exts := make([]*gopdk.Extension, 0)
-						for _, epex := range epVer.Extensions {
-							exts = append(exts, &epex.Extension)
-						}
-						return exts, nil
+		matches := make([]matchingPoint, 0)
+		for _, epVer := range eps {
+			v, err := parseSemver(epVer.Version)
+			if nil != err {
+				// an extension point with an invalid version can only be found without a version
+				continue
+			}
+
+			if constraint.check(v) {
+				matches = append(matches, matchingPoint{version: v, ep: epVer})
+			}
+		}
+
+		if len(matches) > 0 {
+			sort.SliceStable(matches, func(i, j int) bool {
+				return matches[i].version.compare(matches[j].version) > 0
+			})
+
+			// the same extension may be attached to more than one version of the extension point, only return it once,
+			// and skip extensions shadowed by another plugin's extension with the same id
+			seen := make(map[*extension]bool)
+			merged := make([]*extension, 0)
+			for _, match := range matches {
+				for _, epex := range e.attachedExtensions(match.ep) {
+					if !seen[epex] {
+						seen[epex] = true
+						merged = append(merged, epex)
 					}
-				} else {
-					fmt.Println("Looking at range from lower to upper: ", lowerVersion, upperVersion)
-					// we have a range of versions so the epVer.Version needs to be >= lowerVersion and <= upperVersion
 				}
 			}
-		} else {
-			// no version provided
-			//so get them all
-			exts := make([]*gopdk.Extension, 0)
-			for _, epex := range eps[0].Extensions {
+
+			// the extensions of each version are ordered already, the merged list keeps the highest version first
+			// where priority and constraints leave the order open
+			if len(matches) > 1 {
+				merged, _ = orderExtensions(merged)
+			}
+
+			exts := make([]*gopdk.Extension, 0, len(merged))
+			for _, epex := range merged {
 				exts = append(exts, &epex.Extension)
 			}
 			return exts, nil
@@ -294,79 +425,197 @@ func (e *Engine) loadPluginManifests(path, ext string) error {
 	files, err := findFilesWithExtensions(path, []string{".gz", ".zip"})
 
 	if err != nil {
-		// Handle error
-		fmt.Println("Some sort of error looking for .tar.gz or .zip plugin archive files: ", err)
+		e.logger.Error("error looking for .tar.gz or .zip plugin archives", "path", path, "error", err)
 		return err
 	}
 
 	// we need to extract the plugin archives to the plugin engine provided output path
 	for _, file := range files {
-		f := getPluginName(file)
+		// errors are already reported per archive, and we do NOT return because other plugins can still be
+		// extracted/loaded and work fine
+		_ = e.loadPluginArchive(file, ext)
+	}
 
-		outputPath := filepath.Join(e.pluginPath, f)
+	return nil
+}
 
-		if strings.HasSuffix(file, ".tar.gz") {
-			err = Untar(file, outputPath)
-			if err != nil {
-				// TODO: Log error.. but do NOT return because other plugins can still be extracted/loaded and work fine
-				fmt.Println("Error unzipping .tar.gz plugin: ", file, err)
-			}
-		} else if strings.HasSuffix(file, ".zip") {
-			err = Unzip(file, outputPath)
-			if err != nil {
-				// TODO: Log error.. but do NOT return because other plugins can still be extracted/loaded and work fine
-				fmt.Println("Error unzipping zip plugin: ", file, err)
-			}
-		} else if strings.HasSuffix(file, ext) {
-			err = Unzip(file, outputPath)
-			if err != nil {
-				// TODO: Log error.. but do NOT return because other plugins can still be extracted/loaded and work fine
-				fmt.Println("Error unzipping zip plugin: ", file, err)
-			}
+// loadPluginArchive
+//
+// This receiver function extracts a single .tar.gz or .zip plugin archive to the engine's pluginPath output location
+// and parses the .yaml plugin manifest(s) found within it, registering each plugin via addPlugin. It is shared by
+// loadPluginManifests for archives found on the local file system and by Load for archives downloaded from a URL.
+// The archive's signature is checked first, according to the engine's signature policy (see WithSignaturePolicy), and
+// the plugins loaded from it record the trusted key it is signed with.
+func (e *Engine) loadPluginArchive(file, ext string) error {
+	e.clearDigestMismatches(file)
+
+	// the archive is only opened once, so the bytes whose signature and digest are checked are the ones extracted even
+	// if the file is replaced meanwhile
+	reader, err := os.Open(file)
+	if nil != err {
+		e.logger.Error("error opening plugin archive", "archive", file, "error", err)
+		return err
+	}
+
+	defer func(reader *os.File) {
+		_ = reader.Close()
+	}(reader)
+
+	info, err := reader.Stat()
+	if nil == err {
+		err = e.extractLimits.checkArchiveSize(file, info.Size())
+	}
+
+	var digest []byte
+	if nil == err && e.verifiesArchive(file) {
+		digest, err = readerDigest(io.NewSectionReader(reader, 0, info.Size()))
+	}
+
+	if nil != err {
+		e.logger.Error("error reading plugin archive", "archive", file, "error", err)
+		return err
+	}
+
+	// nothing of an archive is extracted before its signature and pinned digest are checked
+	signer, err := e.checkSignature(file, digest)
+	if nil != err {
+		e.logger.Error("error verifying plugin archive signature", "archive", file, "error", err)
+		return err
+	}
+
+	if err := e.checkArchiveDigest(file, digest); nil != err {
+		e.logger.Error("error checking plugin archive digest", "archive", file, "error", err)
+		return err
+	}
+
+	f := getPluginName(file)
+
+	outputPath := filepath.Join(e.pluginPath, f)
+	if e.isDownloaded(file) {
+		// next to the archive, in the download's own directory
+		outputPath = filepath.Join(filepath.Dir(file), f)
+	}
+
+	// read from the start, and no further than the size that was checked
+	content := io.NewSectionReader(reader, 0, info.Size())
+
+	// extracted next to the output path first, so a failed extraction leaves the files of a running plugin alone
+	if strings.HasSuffix(file, ".tar.gz") {
+		err = extractStaged(outputPath, func(dir string) error {
+			return untar(content, info.Size(), file, dir, e.extractLimits)
+		})
+	} else if strings.HasSuffix(file, ".zip") || strings.HasSuffix(file, ext) {
+		err = extractStaged(outputPath, func(dir string) error {
+			return unzip(content, info.Size(), file, dir, e.extractLimits)
+		})
+	}
+
+	if nil != err {
+		e.logger.Error("error extracting plugin archive", "archive", file, "error", err)
+		return err
+	}
+
+	// looking for the extracted yaml plugin descriptor manifest file
+	files, err := findFilesWithExtensions(outputPath, []string{".yaml"})
+	if nil != err {
+		e.logger.Error("error looking for yaml plugin manifests", "archive", file, "error", err)
+		return err
+	}
+
+	for _, f := range files {
+		// grab the base path where the plugin was extracted
+		base, _ := filepath.Split(f)
+
+		// get the WASM file
+		wasm, err2 := findFilesWithExtensions(base, []string{".wasm"})
+		if nil != err2 {
+			e.logger.Error("error looking for .wasm module", "manifest", f, "error", err2)
+		}
+
+		if len(wasm) == 0 {
+			e.logger.Warn("no .wasm module found alongside plugin manifest", "manifest", f)
+			continue
 		}
 
-		// Because an error could occur, but we're in a loop that needs to process potentially multiple plugins, we're
-		// checking if the error is nil
+		// read the bytes of the configuration file in
+		data, err := os.ReadFile(f)
+		if err != nil {
+			e.logger.Error("error reading plugin manifest", "manifest", f, "error", err)
+		}
+
+		p := gopdk.Plugin{}
+		err = yaml.Unmarshal(data, &p)
+
+		// the engine specific parts of the manifest, such as dependencies
+		m := pluginManifest{}
 		if nil == err {
-			// looking for the extracted yaml plugin descriptor manifest file
-			files, err := findFilesWithExtensions(outputPath, []string{".yaml"})
-			if nil != err {
-				fmt.Println("Error trying to find YAML plugin manifest files")
-			} else if len(files) > 0 {
-				for _, f := range files {
-					// grab the base path where the plugin was extracted
-					base, _ := filepath.Split(f)
-
-					// get the WASM file
-					wasm, err2 := findFilesWithExtensions(base, []string{".wasm"})
-					if nil != err2 {
-						fmt.Println("Error walking base path: ", err2)
-					}
+			err = yaml.Unmarshal(data, &m)
+		}
 
-					// read the bytes of the configuration file in
-					data, err := os.ReadFile(f)
-					if err != nil {
-						fmt.Println("Error reading file:", err)
-					}
+		var timeout time.Duration
+		if nil == err {
+			timeout, err = m.timeout()
+		}
 
-					p := gopdk.Plugin{}
-					err = yaml.Unmarshal(data, &p)
+		var contracts map[string]ExtensionPointContract
+		if nil == err {
+			contracts, err = m.contracts()
+		}
 
-					if nil != err {
-						fmt.Println("Got error unmarshalling: ", err)
-					} else {
-						plug := &plugin{
-							PathToModule: wasm[0],
-							Plugin:       nil,
-							Resolved:     false,
-						}
+		if nil == err && m.PoolSize < 0 {
+			err = errors.New("invalid plugin poolSize: " + strconv.Itoa(m.PoolSize))
+		}
 
-						// register plugin, extension points and extensions
-						e.addPlugin(plug, p)
-					}
-				}
+		if nil == err && len(m.Hash) > 0 {
+			_, err = parseDigest(m.Hash)
+		}
+
+		var capabilities []*capability
+		if nil == err {
+			capabilities, err = parseCapabilities(m.Capabilities)
+		}
+
+		for _, d := range m.Dependencies {
+			if nil == err {
+				err = d.validate()
+			}
+		}
+
+		for _, l := range m.Listeners {
+			if nil == err {
+				_, err = compileTopicPattern(l.Event)
 			}
 		}
+
+		if nil != err {
+			e.logger.Error("invalid plugin manifest", "manifest", f, "plugin", p.Id, "version", p.Version, "error", err)
+		} else if hash, err := e.checkModuleDigest(file, p, m.Hash, wasm[0]); nil != err {
+			// only this plugin is left out, the archive's other plugins still load
+			e.logger.Error("error checking plugin module digest", "manifest", f, "plugin", p.Id, "version", p.Version, "error", err)
+		} else {
+			plug := &plugin{
+				PathToModule: wasm[0],
+				Hash:         hash,
+				Resolved:     false,
+				Dependencies: m.Dependencies,
+				Archive:      file,
+				Signer:       signer,
+				Timeout:      timeout,
+				PoolSize:     m.PoolSize,
+				Listeners:    m.Listeners,
+				Ordering:     m.ordering(),
+				Contracts:    contracts,
+				Capabilities: m.Capabilities,
+			}
+
+			plug.granted, plug.deniedCapabilities = e.grantCapabilities(p.Id, capabilities)
+			if len(plug.deniedCapabilities) > 0 {
+				e.logger.Warn("plugin capabilities denied by policy", "plugin", p.Id, "version", p.Version, "capabilities", plug.deniedCapabilities)
+			}
+
+			// register plugin, extension points and extensions
+			e.addPlugin(plug, p)
+		}
 	}
 
 	return nil
@@ -375,27 +624,56 @@ func (e *Engine) loadPluginManifests(path, ext string) error {
 // instantiate
 //
 // this function will create the plugin instance and call the plugin's start lifecycle exported function. This
-// function should be called when another plugin's extension function is to be called and the plugin is not yet created
-func (e *Engine) instantiate(plugin *plugin) error {
-	ctx := e.context
+// function should be called when another plugin's extension function is to be called and the plugin is not yet created.
+// ctx is the context of the call that needs the plugin, so its deadline also limits the plugin's start. Once started,
+// the plugin's listeners are sent the retained events they missed while it was not running.
+func (e *Engine) instantiate(ctx context.Context, plugin *plugin) error {
+	var created bool
+	var err error
+	if nil != plugin.native {
+		created, err = plugin.native.start(withCallingPlugin(ctx, plugin))
+	} else {
+		created, err = plugin.instances.start(func() (*extism.Plugin, error) {
+			return e.newInstance(ctx, plugin)
+		})
+	}
+
+	if created {
+		// a retained event whose delivery started the plugin reaches its listeners through that delivery
+		retainedCtx := context.WithValue(e.context, retainedSeqKey{}, ctx.Value(retainedSeqKey{}))
+		e.deliverRetained(retainedCtx, e.pluginListeners(plugin))
+	}
+
+	return err
+}
+
+// newInstance
+//
+// Creates a new extism instance of the plugin's wasm module and calls its start lifecycle exported function. Every
+// instance in a plugin's instance pool is created by this function, so each one is started. ctx is the context of the
+// call the instance is created for: when it is done before start returns, the instance is closed and not used.
+func (e *Engine) newInstance(ctx context.Context, plugin *plugin) (*extism.Plugin, error) {
 	compilationCache := wazero.NewCompilationCache()
 	defer func(cache wazero.CompilationCache, ctx context.Context) {
 		err := cache.Close(ctx)
 		if err != nil {
-			fmt.Println("Error closing cache: ", err)
+			e.pluginLogger(plugin).Warn("error closing compilation cache", "error", err)
 		}
 	}(compilationCache, ctx)
 
+	// closing the module when the call context is done is what lets a deadline stop a plugin that is stuck
 	config := extism.PluginConfig{
 		EnableWasi:    true,
 		ModuleConfig:  wazero.NewModuleConfig(),
-		RuntimeConfig: wazero.NewRuntimeConfig().WithCompilationCache(compilationCache),
+		RuntimeConfig: wazero.NewRuntimeConfig().WithCompilationCache(compilationCache).WithCloseOnContextDone(true),
 	}
 
 	manifest := extism.Manifest{
 		Wasm: []extism.Wasm{
 			extism.WasmFile{
 				Path: plugin.PathToModule,
+				// extism verifies the module against it, so a module replaced after it was loaded is not run
+				Hash: plugin.Hash,
 			},
 		},
 	}
@@ -403,19 +681,65 @@ func (e *Engine) instantiate(plugin *plugin) error {
 	pluginInstance, err := extism.NewPlugin(ctx, manifest, config, e.hostFuncs)
 
 	if err != nil {
-		fmt.Printf("Failed to initialize plugin: %v\n", err)
+		e.pluginLogger(plugin).Error("failed to initialize plugin", "error", err)
+		return nil, err
 	}
 
-	plugin.Plugin = pluginInstance
+	// the plugin's own log output goes to the engine's logger, tagged with the plugin
+	pluginInstance.SetLogger(e.pluginLogFunc(plugin))
 
-	_, _, err = pluginInstance.Call("start", nil)
+	_, _, err = pluginInstance.CallWithContext(withCallingPlugin(ctx, plugin), "start", nil)
+
+	if nil != err && nil != ctx.Err() {
+		// the module was closed when the context was done
+		if closeErr := pluginInstance.CloseWithContext(context.WithoutCancel(ctx)); nil != closeErr {
+			e.pluginLogger(plugin).Warn("error closing aborted plugin instance", "error", closeErr)
+		}
+
+		return nil, fmt.Errorf("calling plugin start: %w", ctx.Err())
+	}
 
 	if nil != err {
-		fmt.Println("Error calling plugin: ", err)
+		e.pluginLogger(plugin).Error("error calling plugin start", "error", err)
+	}
+
+	return pluginInstance, nil
+}
+
+// instantiateWithDependencies
+//
+// Instantiates the plugin after first instantiating every plugin it depends on, in topological order. Plugins that are
+// already instantiated are left alone. A plugin whose dependencies are not resolved can not be instantiated. ctx is the
+// context of the call that needs the plugin, see instantiate.
+func (e *Engine) instantiateWithDependencies(ctx context.Context, p *plugin) error {
+	e.mu.RLock()
+	if e.shutdown {
+		e.mu.RUnlock()
+		return ErrEngineShutdown
+	}
+
+	if !p.Resolved {
+		e.mu.RUnlock()
+		return errors.New("can not instantiate a plugin that is not yet resolved: " + p.Id + " " + p.Version)
+	}
+
+	order := e.startOrder([]*plugin{p})
+	e.mu.RUnlock()
+
+	call := currentCall(ctx)
+	for _, dep := range order {
+		if !dep.running() {
+			// a plugin calling back in to itself from its start or stop, directly or through other plugins, would
+			// wait for them to finish
+			if call.calls(dep) {
+				return fmt.Errorf("%w: plugin %s called back in to itself while starting or stopping", ErrReentrantCall, pluginKey(dep.Id, dep.Version))
+			}
+
+			if err := e.instantiate(ctx, dep); nil != err {
+				return err
+			}
+		}
 	}
-	//} else {
-	//	return errors.New("can not instantiate a plugin that is not yet resolved: " + plugin.Details.Id)
-	// }
 
 	return nil
 }
@@ -425,64 +749,234 @@ func (e *Engine) instantiate(plugin *plugin) error {
 // This method is called by an application to start the engine. This should occur after the Load() has finished and all
 // plugins are found/parsed/resolved. Start will cycle through all plugins to find any with a startOnLoad flag which
 // would indicate the plugin should be instantiated. For plugins that do not have startOnLoad set, they will be
-// instantiated when first used via a call to an extension.
+// instantiated when first used via a call to an extension. Plugins are instantiated in dependency (topological) order,
+// so any plugin a startOnLoad plugin depends on is instantiated before it. Unresolved plugins are not instantiated.
 func (e *Engine) Start() error {
-	for _, plugin := range e.plugins {
-		if len(plugin) > 0 {
-			for _, verPlugin := range plugin {
-				if verPlugin.LoadOnStart {
-					err := e.instantiate(verPlugin)
-
-					if nil != err {
-						fmt.Println("Error instantiating plugin: ", err)
-					}
-				}
+	e.mu.Lock()
+	e.started = true
+	e.mu.Unlock()
+
+	e.startPlugins()
+
+	return nil
+}
+
+// startPlugins
+//
+// Instantiates every resolved startOnLoad plugin, and the plugins it depends on, that is not already instantiated.
+func (e *Engine) startPlugins() {
+	e.mu.RLock()
+	if e.shutdown {
+		e.mu.RUnlock()
+		return
+	}
+
+	roots := make([]*plugin, 0)
+	for _, verPlugin := range e.sortedPlugins() {
+		if verPlugin.LoadOnStart {
+			if !verPlugin.Resolved {
+				e.pluginLogger(verPlugin).Warn("not starting plugin with unresolved dependencies")
+				continue
 			}
+
+			roots = append(roots, verPlugin)
 		}
 	}
 
-	return nil
+	order := e.startOrder(roots)
+	e.mu.RUnlock()
+
+	for _, verPlugin := range order {
+		if !verPlugin.running() {
+			err := e.instantiate(e.context, verPlugin)
+
+			if nil != err {
+				e.pluginLogger(verPlugin).Error("error instantiating plugin", "error", err)
+			}
+		}
+	}
+}
+
+// Shutdown
+//
+// This method is called by an application to stop the engine. Every instantiated plugin has its optional exported stop
+// function called, in reverse dependency order so a plugin is stopped before the plugins it depends on, and is then
+// closed to release its wasm runtime. If ctx has no deadline, each plugin is given defaultStopTimeout to finish calls
+// in progress and run stop, so a misbehaving plugin can not hang process exit. Plugins are closed even when stop fails
+// or times out. Events already queued for async and ordered topics are delivered first, until ctx is done or, if it
+// has no deadline, for defaultStopTimeout, so a subscriber that never returns does not keep the plugins from being
+// stopped. After Shutdown no plugin will be instantiated again.
+func (e *Engine) Shutdown(ctx context.Context) error {
+	closeCtx := ctx
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		closeCtx, cancel = context.WithTimeout(ctx, defaultStopTimeout)
+		defer cancel()
+	}
+
+	var errs []error
+	if err := e.events.close(closeCtx); nil != err {
+		e.logger.Error("error delivering queued events", "error", err)
+		errs = append(errs, err)
+	}
+
+	e.mu.Lock()
+	e.shutdown = true
+
+	running := make([]*plugin, 0)
+	for _, p := range e.sortedPlugins() {
+		if p.running() {
+			running = append(running, p)
+		}
+	}
+
+	order := e.startOrder(running)
+	e.mu.Unlock()
+
+	for i := len(order) - 1; i >= 0; i-- {
+		if err := e.stop(ctx, order[i]); nil != err {
+			e.pluginLogger(order[i]).Error("error stopping plugin", "error", err)
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// stopPlugins
+//
+// Stops the plugins in the order given, which must not be called with the engine lock held.
+func (e *Engine) stopPlugins(plugins []*plugin) {
+	for _, p := range plugins {
+		if err := e.stop(e.context, p); nil != err {
+			e.pluginLogger(p).Error("error stopping plugin", "error", err)
+		}
+	}
+}
+
+// stop
+//
+// Takes every instance out of the plugin's pool, waiting for calls in progress to finish, then calls the exported stop
+// function of each instance that has one and closes it. An instance still running a call once ctx is done is closed
+// without calling stop, as an instance cannot run two calls at once. If the plugin is no longer registered (unloaded
+// or replaced) or the engine is shut down, its pool is closed so no new instance is created, otherwise the plugin is
+// instantiated again on next use. A native plugin has its Stop called instead, which is given up on once ctx is done,
+// and in the same cases it is closed so it is not started again.
+func (e *Engine) stop(ctx context.Context, p *plugin) error {
+	stopCtx := ctx
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		stopCtx, cancel = context.WithTimeout(ctx, defaultStopTimeout)
+		defer cancel()
+	}
+
+	e.mu.RLock()
+	final := e.shutdown || e.plugins[p.Id][p.Version] != p
+	e.mu.RUnlock()
+
+	if nil != p.native {
+		// Stop is Go code that may not watch ctx, so it is not waited for past the deadline
+		done := make(chan error, 1)
+		go func() {
+			done <- p.native.stop(withCallingPlugin(stopCtx, p), final)
+		}()
+
+		select {
+		case err := <-done:
+			if nil != err {
+				return errors.New("calling stop on plugin " + pluginKey(p.Id, p.Version) + ": " + err.Error())
+			}
+		case <-stopCtx.Done():
+			return errors.New("calling stop on plugin " + pluginKey(p.Id, p.Version) + ": " + stopCtx.Err().Error())
+		}
+
+		return nil
+	}
+
+	var errs []error
+	idle, busy := p.instances.drain(stopCtx, final)
+	for _, inst := range idle {
+		if inst.FunctionExists("stop") {
+			if _, _, err := inst.CallWithContext(stopCtx, "stop", nil); nil != err {
+				errs = append(errs, errors.New("calling stop on plugin "+pluginKey(p.Id, p.Version)+": "+err.Error()))
+			}
+		}
+	}
+
+	for _, inst := range append(idle, busy...) {
+		// close even if the deadline has passed, the runtime still has to be released
+		if err := inst.CloseWithContext(context.WithoutCancel(ctx)); nil != err {
+			errs = append(errs, errors.New("closing plugin "+pluginKey(p.Id, p.Version)+": "+err.Error()))
+		}
+	}
+
+	return errors.Join(errs...)
 }
 
 // Load
 //
 // This recv/func is going to load plugins found in the provided path on the local filesystem. This path should be an
-// absolute path on a local file system or a URL to an archived plugin file. The archive needs to be in a .tar.gz or
-// .zip format. If the path provided is an http/https location, it will download the plugin to the engine plugin path
-// and then unzip/untar it there.
+// absolute path on a local file system (a relative one is taken from the working directory), or a URL to an archived
+// plugin file. The archive needs to be in a .tar.gz or .zip format. If the path provided is an http/https location, it
+// will download the plugin to the engine plugin path and then unzip/untar it there.
 func (e *Engine) Load(path string) error {
 	// First make sure that path is NOT a URL to a single plugin file
 	lower := strings.ToLower(path)
-	if strings.HasPrefix(lower, "http") {
-		// This is a URL
-		u, err := url.Parse(lower)
-		fmt.Println("u, err: ", u, err)
-		// return for now as nil since we're not doing URLs yet
-		// TODO: FIX THIS
-		return nil
+	if strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "https://") {
+		// This is a URL, so download the archive in to the plugin path (or reuse the cached copy) and then load it
+		// exactly the same way a local archive is loaded
+		archive, err := e.download(path)
+		if nil != err {
+			return err
+		}
+
+		// the signature is published next to the archive, without it the signature policy decides
+		if e.signaturePolicy != SignatureOff && len(e.signaturePolicy) > 0 {
+			if err := e.downloadSignature(path, archive); nil != err {
+				e.logger.Warn("error downloading plugin archive signature", "url", path, "error", err)
+			}
+		}
+
+		return e.loadPluginArchive(archive, "")
 	}
 
-	base, err := os.Getwd()
+	newPath, err := realPath(path)
 	if err != nil {
 		return err
 	}
 
-	newPath := filepath.Join(base, lower)
-
 	err = e.loadPluginManifests(newPath, "")
 	if nil != err {
-		fmt.Println("Error loading plugins: ", err)
+		e.logger.Error("error loading plugins", "path", newPath, "error", err)
 	}
 
-	e.resolve()
 	return nil
 }
 
+// realPath
+//
+// Returns the absolute path of the file or directory with its symlinks resolved, so an archive is known by the same
+// path however it was given. A path whose symlinks can not be resolved, such as one that does not exist, is only made
+// absolute.
+func realPath(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if nil != err {
+		return "", err
+	}
+
+	if resolved, err := filepath.EvalSymlinks(abs); nil == err {
+		return resolved, nil
+	}
+
+	return abs, nil
+}
+
 // resolve
 //
-// This method will loop through all plugins and unresolved extensions, attempting to ensure all extensions of a given
-// plugin have been resolved to loaded plugins with matching extension points. Only when all extensions of a plugin
-// are resolved will a plugin's status change to resolved.
+// This method will loop through all unresolved extensions, attaching each to the loaded extension points it anchors
+// to. Extensions whose extension point is not loaded yet stay unresolved until a later call. It then resolves the
+// plugin dependency graph (see resolvePlugins), so a plugin's status is only resolved when all of its dependencies are.
+// It must be called with the engine lock held.
 func (e *Engine) resolve() {
 	if nil != e.unresolved && len(e.unresolved) > 0 {
 		leftover := make([]*extension, 0)
@@ -493,15 +987,13 @@ func (e *Engine) resolve() {
 				eps := e.extensionPoints[v.ExtensionPoint]
 				if nil != eps && len(eps) > 0 {
 					for _, ep := range eps {
-						if v.ExtensionPoint == ep.Id {
-							ep.Extensions = append(ep.Extensions, v)
-							v.Resolved = true
-							e.extensions[v.Id] = v
-						} else {
-							// not found, append to leftover
-							leftover = append(leftover, v)
-						}
+						ep.Extensions = append(ep.Extensions, v)
 					}
+
+					v.Resolved = true
+				} else {
+					// not found, append to leftover
+					leftover = append(leftover, v)
 				}
 			}
 		}
@@ -509,6 +1001,12 @@ func (e *Engine) resolve() {
 		// set the leftover unresolved
 		e.unresolved = leftover
 	}
+
+	e.orderExtensionPoints()
+	e.resolvePlugins()
+	e.routeExtensions()
+	e.enforceCardinality()
+	e.queueExtensionPointNotifications()
 }
 
 // RegisterHostExtensionPoint
@@ -516,8 +1014,24 @@ func (e *Engine) resolve() {
 // This method allows a host/client application that is using the Plugin Engine to register extension points. This is
 // useful if the host/client app has some specific things it wants to allow anchor points for plugins to attach to.
 // Ideally a host/client app may ship/install/start with plugins already, but this gives the ability for the host/client
-// to have native code functions tied to extension points that are then filled by plugin extensions.
-func (e *Engine) RegisterHostExtensionPoint(id, name, version, description string) {
+// to have native code functions tied to extension points that are then filled by plugin extensions. When fn is not
+// nil it is called with the extension point's extensions every time extensions attach to or detach from it, starting
+// with the extensions that are already loaded, so the host can e.g. rebuild a menu when a plugin adds menu items.
+func (e *Engine) RegisterHostExtensionPoint(id, name, version, description string, fn HostExtensionPointFunc) {
+	_ = e.RegisterHostExtensionPointWithContract(id, name, version, description, ExtensionPointContract{}, fn)
+}
+
+// RegisterHostExtensionPointWithContract
+//
+// Registers a host extension point the same as RegisterHostExtensionPoint, with the contract its extensions are held
+// to: how many of them it accepts, and the JSON Schema of their payloads. An error is returned, and nothing registered,
+// when the cardinality is unknown or a schema does not compile.
+func (e *Engine) RegisterHostExtensionPointWithContract(id, name, version, description string, contract ExtensionPointContract, fn HostExtensionPointFunc) error {
+	schemas, err := contract.compile(id)
+	if nil != err {
+		return err
+	}
+
 	ep := &extensionPoint{
 		ExtensionPoint: gopdk.ExtensionPoint{
 			Id:          id,
@@ -525,8 +1039,15 @@ func (e *Engine) RegisterHostExtensionPoint(id, name, version, description strin
 			Name:        name,
 			Version:     version,
 		},
+		Func:     fn,
+		Contract: contract,
+		schemas:  schemas,
 	}
 
+	e.mu.Lock()
+	defer e.notifyExtensionPoints()
+	defer e.mu.Unlock()
+
 	exps := e.extensionPoints[id]
 	if nil == exps {
 		exps = make([]*extensionPoint, 0)
@@ -536,41 +1057,165 @@ func (e *Engine) RegisterHostExtensionPoint(id, name, version, description strin
 	// reassign because exps may be a new larger ref.. has to be reassigned
 	e.extensionPoints[id] = exps
 	e.resolve()
+
+	return nil
 }
 
+// GetPlugins
+//
+// Returns the loaded plugins keyed on plugin id and version. The maps are a copy, so they can be used while plugins are
+// loaded and unloaded concurrently.
 func (e *Engine) GetPlugins() map[string]map[string]*plugin {
-	return e.plugins
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	plugins := make(map[string]map[string]*plugin, len(e.plugins))
+	for id, versions := range e.plugins {
+		plugins[id] = make(map[string]*plugin, len(versions))
+		for version, p := range versions {
+			plugins[id][version] = p
+		}
+	}
+
+	return plugins
 }
 
+// CallExtensionFunc
+//
+// Calls the function of the extension with the data, instantiating the extension's plugin first if need be. It is the
+// same as CallExtensionFuncWithContext using the engine's context, so only the plugin's manifest timeout applies.
 func (e *Engine) CallExtensionFunc(extensionId string, data []byte) ([]byte, error) {
-	callable := callableExtensions[extensionId]
+	return e.CallExtensionFuncWithContext(e.context, extensionId, data)
+}
+
+// CallExtensionFuncWithContext
+//
+// Calls the function of the extension with the data, instantiating the extension's plugin first if need be. The call
+// honors the deadline and cancellation of ctx. When ctx has no deadline the plugin's manifest timeout, if any, is
+// applied. A call that runs past its deadline is aborted and returns an error wrapping ErrCallTimeout. Because the
+// wasm module is closed when a call is aborted, the plugin instance is discarded and a new one created on next use.
+// Concurrent calls to the same plugin each borrow their own instance from the plugin's instance pool, waiting (until
+// ctx is done) for one to be free when all of the pool's instances are busy. A plugin calling back in to itself through
+// the host functions does not wait, it gets an error wrapping ErrReentrantCall instead. With WithPayloadValidation, the
+// data and the result are checked against the schemas of the extension point, and an error wrapping ErrInvalidPayload
+// is returned when they do not match.
+func (e *Engine) CallExtensionFuncWithContext(ctx context.Context, extensionId string, data []byte) ([]byte, error) {
+	e.mu.RLock()
+	callable := e.callableExtensions[extensionId]
+	extension := e.extensions[extensionId]
+	var schemas contractSchemas
+	if e.validatePayloads && nil != extension {
+		schemas = e.payloadSchemas(extension)
+	}
+	e.mu.RUnlock()
 
 	if nil != callable {
-		extension := e.extensions[extensionId]
-		if nil == callable.Plugin {
-			fmt.Println("Instantiating plugin: ", extensionId)
-			if err := e.instantiate(callable); err != nil {
-				fmt.Println("Problem instantiating callable plugin: ", extension.Func)
-			}
+
+		if nil == extension {
+			return nil, errors.New("extension is not resolved: " + extensionId)
 		}
 
-		_, d, err := callable.Plugin.Call(extension.Func, data)
-		if nil != err {
+		if err := validatePayload(schemas.input, data, "input of extension "+extensionId); nil != err {
 			return nil, err
 		}
 
-		return d, nil
+		out, err := e.callPlugin(ctx, callable, extension.Func, data, "extension "+extensionId)
+		if nil == err {
+			err = validatePayload(schemas.output, out, "output of extension "+extensionId)
+		}
+
+		return out, err
 	}
 
 	return nil, nil
 }
 
+// callPlugin
+//
+// Calls the exported function fn of the plugin with the data, instantiating the plugin (and its dependencies) first if
+// need be. It applies the plugin's manifest timeout when ctx has no deadline, which limits instantiating the plugin as
+// well as the call, and borrows an instance from the plugin's pool for the call, or calls a native plugin directly.
+// target names what is being called in errors, such as "extension com.acme.menu.open".
+func (e *Engine) callPlugin(ctx context.Context, p *plugin, fn string, data []byte, target string) ([]byte, error) {
+	if _, ok := ctx.Deadline(); !ok && p.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.Timeout)
+		defer cancel()
+	}
+
+	if !p.running() {
+		e.pluginLogger(p).Debug("instantiating plugin", "func", fn)
+		if err := e.instantiateWithDependencies(ctx, p); err != nil {
+			e.pluginLogger(p).Error("error instantiating plugin", "func", fn, "error", err)
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return nil, fmt.Errorf("%w: %s starting plugin %s", ErrCallTimeout, target, pluginKey(p.Id, p.Version))
+			}
+
+			return nil, err
+		}
+	}
+
+	if nil != p.native {
+		return e.callNative(ctx, p, fn, data, target)
+	}
+
+	create := func() (*extism.Plugin, error) {
+		return e.newInstance(ctx, p)
+	}
+
+	var inst *extism.Plugin
+	var err error
+	if currentCall(ctx).calls(p) {
+		// a plugin calling back in to itself holds one of its instances, and would wait forever for it to be released
+		// if every instance is held by the calls it was made from
+		inst, err = p.instances.tryAcquire(create)
+	} else {
+		inst, err = p.instances.acquire(ctx, create)
+	}
+	if nil != err {
+		if errors.Is(err, errPoolFull) {
+			return nil, fmt.Errorf("%w: %s with no free instance", ErrReentrantCall, target)
+		}
+
+		if errors.Is(err, context.DeadlineExceeded) {
+			return nil, fmt.Errorf("%w: %s waiting for a plugin instance", ErrCallTimeout, target)
+		}
+
+		return nil, err
+	}
+
+	// host functions called back during the call know which plugin is calling them
+	_, d, err := inst.CallWithContext(withCallingPlugin(ctx, p), fn, data)
+	if nil != err {
+		if nil != ctx.Err() {
+			// the module was closed when the context was done, so this instance can not be used again
+			if closeErr := p.instances.discard(context.WithoutCancel(ctx), inst); nil != closeErr {
+				e.pluginLogger(p).Warn("error closing aborted plugin instance", "error", closeErr)
+			}
+
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return nil, fmt.Errorf("%w: %s", ErrCallTimeout, target)
+			}
+
+			return nil, fmt.Errorf("call to %s aborted: %w", target, ctx.Err())
+		}
+
+		p.instances.release(inst)
+		return nil, err
+	}
+
+	p.instances.release(inst)
+	return d, nil
+}
+
 // NewPluginEngine
 //
 // This function will create a new plugin engine instance. Passed in are host functions per the Extism (WASI)
 // Host Function spec. This allows consumers of this engine to provide its own host functions that plugins will be
-// able to utilize along with the plugin engine host functions.
-func NewPluginEngine(hostFuncs []extism.HostFunction, logLevel extism.LogLevel, pluginOutputPath string) (*Engine, error) {
+// able to utilize along with the plugin engine host functions. Options, such as WithInstancePoolSize, adjust the engine's
+// defaults. logLevel also filters what plugins log, extism's process wide log level (see extism.SetLogLevel) is
+// lowered to it if needed but never raised, so one engine does not change what the others' plugins log.
+func NewPluginEngine(hostFuncs []extism.HostFunction, logLevel extism.LogLevel, pluginOutputPath string, options ...EngineOption) (*Engine, error) {
 	plugins := make(map[string]map[string]*plugin)
 	unresolved := make([]*extension, 0)
 	extensionPoints := make(map[string][]*extensionPoint)
@@ -584,13 +1229,34 @@ func NewPluginEngine(hostFuncs []extism.HostFunction, logLevel extism.LogLevel,
 
 	// instantiate as we need this in the host functions
 	engine := &Engine{
-		context:         context.Background(),
-		logLevel:        logLevel,
-		plugins:         plugins,
-		unresolved:      unresolved,
-		extensions:      extensions,
-		extensionPoints: extensionPoints,
-		pluginPath:      pluginOutputPath,
+		context:            context.Background(),
+		logLevel:           logLevel,
+		plugins:            plugins,
+		unresolved:         unresolved,
+		extensions:         extensions,
+		callableExtensions: make(map[string]*plugin),
+		extensionPoints:    extensionPoints,
+		pluginPath:         pluginOutputPath,
+		httpClient:         &http.Client{Timeout: defaultDownloadTimeout},
+		watchInterval:      defaultWatchInterval,
+		poolSize:           defaultInstancePoolSize,
+		events:             newEventBus(),
+		extractLimits:      DefaultExtractLimits(),
+		signaturePolicy:    SignatureOff,
+	}
+	engine.events.deliver = engine.deliverEvent
+
+	for _, option := range options {
+		option(engine)
+	}
+
+	engine.logger = newEngineLogger(engine.logger, logLevel)
+	lowerExtismLogLevel(logLevel)
+
+	if nil != engine.capabilityPolicy {
+		if engine.capabilityRules, err = compileCapabilityRules(engine.capabilityPolicy); nil != err {
+			return nil, err
+		}
 	}
 
 	hfs := append(hostFuncs, engine.GetHostFuncs()...)
@@ -0,0 +1,369 @@
+package pluginengine
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	extism "github.com/extism/go-sdk"
+	gopdk "github.com/spirefy/go-pdk"
+)
+
+// testWasmModule returns a minimal wasm module with the exports an extism plugin is called through: run returns right
+// away, and spin loops until the call is aborted. start runs the function at the index, 0 to return right away or 2
+// to spin.
+func testWasmModule(start byte) []byte {
+	return []byte{
+		0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00, // magic and version
+		0x01, 0x05, 0x01, 0x60, 0x00, 0x01, 0x7f, // type section: () -> i32
+		0x03, 0x04, 0x03, 0x00, 0x00, 0x00, // function section: three functions of that type
+		0x07, 0x16, 0x03, // export section: start, run and spin
+		0x05, 's', 't', 'a', 'r', 't', 0x00, start,
+		0x03, 'r', 'u', 'n', 0x00, 0x01,
+		0x04, 's', 'p', 'i', 'n', 0x00, 0x02,
+		0x0a, 0x15, 0x03, // code section
+		0x04, 0x00, 0x41, 0x00, 0x0b, // i32.const 0
+		0x04, 0x00, 0x41, 0x00, 0x0b, // i32.const 0
+		0x09, 0x00, 0x03, 0x40, 0x0c, 0x00, 0x0b, 0x41, 0x00, 0x0b, // loop br 0 end, i32.const 0
+	}
+}
+
+// addTestWasmPlugin adds the plugin id running testWasmModule(start), with the extensions id.run and id.spin of
+// com.acme.menu.
+func addTestWasmPlugin(t *testing.T, engine *Engine, id string, start byte) *plugin {
+	module := filepath.Join(t.TempDir(), "plugin.wasm")
+	if err := os.WriteFile(module, testWasmModule(start), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	engine.RegisterHostExtensionPoint("com.acme.menu", "Menu", "1.0.0", "", nil)
+
+	p := &plugin{PathToModule: module}
+	engine.addPlugin(p, gopdk.Plugin{
+		Id:      id,
+		Version: "1.0.0",
+		Extensions: []gopdk.Extension{
+			{Id: id + ".run", ExtensionPoint: "com.acme.menu", Func: "run"},
+			{Id: id + ".spin", ExtensionPoint: "com.acme.menu", Func: "spin"},
+		},
+	})
+
+	return p
+}
+
+// testStopWasmModule returns a minimal wasm module whose start and run return right away. stop runs the function at
+// the index, 3 to pass n to the stopped host function of newStoppedHostFunc, or 4 to spin.
+func testStopWasmModule(n, stop byte) []byte {
+	return []byte{
+		0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00, // magic and version
+		0x01, 0x09, 0x02, 0x60, 0x00, 0x01, 0x7f, 0x60, 0x01, 0x7f, 0x00, // type section: () -> i32 and (i32) -> ()
+		0x02, 0x1c, 0x01, // import section: stopped of extism:host/user
+		0x10, 'e', 'x', 't', 'i', 's', 'm', ':', 'h', 'o', 's', 't', '/', 'u', 's', 'e', 'r',
+		0x07, 's', 't', 'o', 'p', 'p', 'e', 'd', 0x00, 0x01,
+		0x03, 0x05, 0x04, 0x00, 0x00, 0x00, 0x00, // function section: four functions of type () -> i32
+		0x07, 0x16, 0x03, // export section: start, run and stop
+		0x05, 's', 't', 'a', 'r', 't', 0x00, 0x01,
+		0x03, 'r', 'u', 'n', 0x00, 0x02,
+		0x04, 's', 't', 'o', 'p', 0x00, stop,
+		0x0a, 0x1e, 0x04, // code section
+		0x04, 0x00, 0x41, 0x00, 0x0b, // i32.const 0
+		0x04, 0x00, 0x41, 0x00, 0x0b, // i32.const 0
+		0x08, 0x00, 0x41, n, 0x10, 0x00, 0x41, 0x00, 0x0b, // stopped(n), i32.const 0
+		0x09, 0x00, 0x03, 0x40, 0x0c, 0x00, 0x0b, 0x41, 0x00, 0x0b, // loop br 0 end, i32.const 0
+	}
+}
+
+// newStoppedHostFunc returns the stopped host function testStopWasmModule calls, which appends n to the returned list.
+func newStoppedHostFunc() (extism.HostFunction, func() []uint64) {
+	var mu sync.Mutex
+	stopped := make([]uint64, 0)
+	fn := extism.NewHostFunctionWithStack("stopped", func(ctx context.Context, p *extism.CurrentPlugin, stack []uint64) {
+		mu.Lock()
+		defer mu.Unlock()
+		stopped = append(stopped, stack[0])
+	}, []extism.ValueType{extism.ValueTypeI32}, nil)
+
+	return fn, func() []uint64 {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]uint64(nil), stopped...)
+	}
+}
+
+// addTestStopPlugin adds a plugin running testStopWasmModule(n, stop), with the extension id.run of com.acme.menu.
+func addTestStopPlugin(t *testing.T, engine *Engine, id string, n, stop byte, deps []dependency) *plugin {
+	module := filepath.Join(t.TempDir(), "plugin.wasm")
+	if err := os.WriteFile(module, testStopWasmModule(n, stop), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	p := &plugin{PathToModule: module, Dependencies: deps}
+	engine.addPlugin(p, gopdk.Plugin{
+		Id:         id,
+		Version:    "1.0.0",
+		Extensions: []gopdk.Extension{{Id: id + ".run", ExtensionPoint: "com.acme.menu", Func: "run"}},
+	})
+
+	return p
+}
+
+func TestShutdown_ReverseDependencyOrder(t *testing.T) {
+	stoppedFunc, stopped := newStoppedHostFunc()
+	engine, err := NewPluginEngine([]extism.HostFunction{stoppedFunc}, extism.LogLevelOff, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	engine.RegisterHostExtensionPoint("com.acme.menu", "Menu", "1.0.0", "", nil)
+	addTestStopPlugin(t, engine, "com.acme.ui", 2, 3, []dependency{{Plugin: "com.acme.core", Version: "^1.0"}})
+	addTestStopPlugin(t, engine, "com.acme.core", 1, 3, nil)
+
+	// instantiates com.acme.core first, as com.acme.ui depends on it
+	if _, err := engine.CallExtensionFunc("com.acme.ui.run", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := engine.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if order := stopped(); len(order) != 2 || order[0] != 2 || order[1] != 1 {
+		t.Errorf("Expected com.acme.ui to be stopped before com.acme.core, but got %v", order)
+	}
+
+	if _, err := engine.CallExtensionFunc("com.acme.ui.run", nil); !errors.Is(err, ErrEngineShutdown) {
+		t.Errorf("Expected ErrEngineShutdown for a call after Shutdown, but got %v", err)
+	}
+}
+
+func TestShutdown_Deadline(t *testing.T) {
+	stoppedFunc, _ := newStoppedHostFunc()
+	engine, err := NewPluginEngine([]extism.HostFunction{stoppedFunc}, extism.LogLevelOff, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the plugin's stop export never returns
+	engine.RegisterHostExtensionPoint("com.acme.menu", "Menu", "1.0.0", "", nil)
+	addTestStopPlugin(t, engine, "com.acme.slow", 0, 4, nil)
+
+	if _, err := engine.CallExtensionFunc("com.acme.slow.run", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if err := engine.Shutdown(ctx); err == nil {
+		t.Errorf("Expected an error for a plugin that did not stop in time")
+	}
+
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected Shutdown to return at the deadline, but it took %v", elapsed)
+	}
+}
+
+// newExtensionPlugin returns a native plugin contributing the extension extId, which runs fn, to com.acme.menu.
+func newExtensionPlugin(id, extId string, deps []NativeDependency, fn func(ctx context.Context, fn string, data []byte) ([]byte, error)) *testNativePlugin {
+	return &testNativePlugin{
+		manifest: NativeManifest{
+			Plugin: gopdk.Plugin{
+				Id:         id,
+				Version:    "1.0.0",
+				Extensions: []gopdk.Extension{{Id: extId, ExtensionPoint: "com.acme.menu", Func: "run"}},
+			},
+			Dependencies: deps,
+		},
+		call: fn,
+	}
+}
+
+func TestShutdown_NativeDeadline(t *testing.T) {
+	engine := newTestEngine(t)
+	engine.RegisterHostExtensionPoint("com.acme.menu", "Menu", "1.0.0", "", nil)
+
+	release := make(chan struct{})
+	defer close(release)
+
+	slow := newExtensionPlugin("com.acme.slow", "com.acme.slow.run", nil, nil)
+	slow.stop = func(context.Context) error {
+		// ignores ctx, as a misbehaving plugin would
+		<-release
+		return nil
+	}
+
+	if err := engine.RegisterNativePlugin(slow); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := engine.CallExtensionFunc("com.acme.slow.run", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if err := engine.Shutdown(ctx); err == nil {
+		t.Errorf("Expected an error for a plugin that did not stop in time")
+	}
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected Shutdown to return at the deadline, but it took %v", elapsed)
+	}
+}
+
+func TestShutdown_BlockingSubscriber(t *testing.T) {
+	timeout := defaultStopTimeout
+	defaultStopTimeout = 50 * time.Millisecond
+	defer func() { defaultStopTimeout = timeout }()
+
+	engine := newTestEngine(t)
+	engine.RegisterHostExtensionPoint("com.acme.menu", "Menu", "1.0.0", "", nil)
+
+	if err := engine.ConfigureTopic("status", TopicConfig{Mode: DeliveryAsync}); err != nil {
+		t.Fatal(err)
+	}
+
+	release := make(chan struct{})
+	defer close(release)
+
+	delivering := make(chan struct{})
+	_, _ = engine.Subscribe("status", func(event Event) {
+		close(delivering)
+		<-release
+	})
+
+	np := newExtensionPlugin("com.acme.status", "com.acme.status.run", nil, nil)
+	if err := engine.RegisterNativePlugin(np); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := engine.CallExtensionFunc("com.acme.status.run", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := engine.Publish(context.Background(), "status", []byte("busy")); err != nil {
+		t.Fatal(err)
+	}
+	<-delivering
+
+	done := make(chan error, 1)
+	go func() {
+		done <- engine.Shutdown(context.Background())
+	}()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Errorf("Expected an error for the queued events that were not delivered")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected Shutdown without a deadline to give up on a subscriber that never returns")
+	}
+
+	np.mu.Lock()
+	defer np.mu.Unlock()
+
+	if np.stops != 1 {
+		t.Errorf("Expected the plugins to be stopped after giving up on the subscriber, but got %d stops", np.stops)
+	}
+}
+
+func TestCallTimeout_CallerCancel(t *testing.T) {
+	engine := newTestEngine(t)
+	addTestWasmPlugin(t, engine, "com.acme.cancel", 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+
+	_, err := engine.CallExtensionFuncWithContext(ctx, "com.acme.cancel.spin", nil)
+	if !errors.Is(err, context.Canceled) || errors.Is(err, ErrCallTimeout) {
+		t.Errorf("Expected a cancelled call to return context.Canceled, but got %v", err)
+	}
+}
+
+func TestCallTimeout_Deadline(t *testing.T) {
+	engine := newTestEngine(t)
+	addTestWasmPlugin(t, engine, "com.acme.deadline", 0)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	if _, err := engine.CallExtensionFuncWithContext(ctx, "com.acme.deadline.spin", nil); !errors.Is(err, ErrCallTimeout) {
+		t.Errorf("Expected ErrCallTimeout for a call past its deadline, but got %v", err)
+	}
+
+	// the aborted instance is replaced on the next call
+	if _, err := engine.CallExtensionFunc("com.acme.deadline.run", nil); err != nil {
+		t.Errorf("Expected the plugin to be callable after an aborted call, but got %v", err)
+	}
+}
+
+func TestCallTimeout_PluginDefault(t *testing.T) {
+	engine := newTestEngine(t)
+	p := addTestWasmPlugin(t, engine, "com.acme.default", 0)
+	p.Timeout = 20 * time.Millisecond
+
+	// no deadline of its own, so the plugin's timeout applies
+	start := time.Now()
+	if _, err := engine.CallExtensionFunc("com.acme.default.spin", nil); !errors.Is(err, ErrCallTimeout) {
+		t.Errorf("Expected ErrCallTimeout from the plugin's timeout, but got %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected the call to be aborted at the plugin's timeout, but it took %v", elapsed)
+	}
+}
+
+func TestCallTimeout_Manifest(t *testing.T) {
+	tests := []struct {
+		timeout  string
+		expected time.Duration
+		valid    bool
+	}{
+		{"", 0, true},
+		{"50ms", 50 * time.Millisecond, true},
+		{"soon", 0, false},
+		{"-1s", 0, false},
+	}
+
+	for _, test := range tests {
+		timeout, err := pluginManifest{Timeout: test.timeout}.timeout()
+		if test.valid != (nil == err) || timeout != test.expected {
+			t.Errorf("Expected %v (valid %v) for timeout %q, but got %v %v", test.expected, test.valid, test.timeout, timeout, err)
+		}
+	}
+}
+
+func TestCallTimeout_Start(t *testing.T) {
+	engine := newTestEngine(t)
+
+	// the plugin's start export never returns
+	p := addTestWasmPlugin(t, engine, "com.acme.wasm", 2)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if _, err := engine.CallExtensionFuncWithContext(ctx, "com.acme.wasm.run", nil); !errors.Is(err, ErrCallTimeout) {
+		t.Errorf("Expected ErrCallTimeout for a plugin whose start does not return, but got %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected the call to be aborted at its deadline, but it took %v", elapsed)
+	}
+
+	// the manifest timeout limits the start of a call without a deadline the same way
+	p.Timeout = 50 * time.Millisecond
+	if _, err := engine.CallExtensionFunc("com.acme.wasm.run", nil); !errors.Is(err, ErrCallTimeout) {
+		t.Errorf("Expected ErrCallTimeout from the plugin's timeout, but got %v", err)
+	}
+
+	if p.running() {
+		t.Errorf("Expected no instance to be kept of a start that was aborted")
+	}
+}
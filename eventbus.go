@@ -0,0 +1,522 @@
+package pluginengine
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sort"
+	"sync"
+)
+
+const (
+	// defaultEventWorkers is how many events of async and ordered topics are delivered at the same time, unless the
+	// engine is created WithEventWorkers.
+	defaultEventWorkers = 4
+	// defaultEventQueueSize is how many events an async or ordered topic holds when its TopicConfig has no QueueSize.
+	defaultEventQueueSize = 256
+	// maxIdleTopicMetrics is how many topics that were not configured keep their metrics once the bus has dropped
+	// their state, the least recently used are forgotten first.
+	maxIdleTopicMetrics = 1024
+)
+
+type (
+	// DeliveryMode is how the events of a topic reach their listeners.
+	DeliveryMode string
+
+	// BackpressurePolicy is what happens to an event sent to an async or ordered topic whose queue is full.
+	BackpressurePolicy string
+
+	// TopicConfig sets how the events of a topic (event name) are delivered, see ConfigureTopic.
+	TopicConfig struct {
+		Mode DeliveryMode `json:"mode" yaml:"mode"`
+		// how many events may wait for delivery, 0 uses defaultEventQueueSize. Not used by DeliverySync.
+		QueueSize    int                `json:"queueSize" yaml:"queueSize"`
+		Backpressure BackpressurePolicy `json:"backpressure" yaml:"backpressure"`
+		// keep the topic's last event and send it to listeners that subscribe, or whose plugin starts, after it was sent
+		Retain bool `json:"retain" yaml:"retain"`
+	}
+
+	// EventMetrics counts what happened to the events of a topic since the engine was created. The counts of a topic
+	// that was not configured are only kept for the maxIdleTopicMetrics most recently used of those, and start over
+	// when it is used again after being forgotten.
+	EventMetrics struct {
+		Published uint64 `json:"published"`
+		// listener calls that succeeded and that failed, an event with three listeners counts three times
+		Delivered uint64 `json:"delivered"`
+		Failed    uint64 `json:"failed"`
+		// events that were dropped because the topic's queue was full
+		Dropped uint64 `json:"dropped"`
+		// events waiting in the topic's queue, and being delivered, at the time of the call
+		Queued   int `json:"queued"`
+		InFlight int `json:"inFlight"`
+		// whether the topic holds a retained event
+		Retained bool `json:"retained"`
+	}
+
+	// deliveringTopicKey is the context key under which a worker stores the topic it is delivering an event of.
+	deliveringTopicKey struct{}
+
+	// retainedSeqKey is the context key under which the seq of the retained event being delivered is stored, so a
+	// plugin that its delivery starts is not sent it a second time as a retained event.
+	retainedSeqKey struct{}
+
+	// queuedEvent is an event waiting in a topic's queue, with the plugin that sent it (nil for the host).
+	queuedEvent struct {
+		ctx    context.Context
+		source *plugin
+		event  Event
+	}
+
+	// retainedEvent is the last event of a retained topic. seq orders the retained events of all topics by when they
+	// were sent.
+	retainedEvent struct {
+		seq    uint64
+		source *plugin
+		event  Event
+	}
+
+	// topic holds the delivery config, queue and retained event of one event name. The bus only keeps the topics
+	// that were configured, and the others while they have events queued, being delivered or retained.
+	topic struct {
+		name       string
+		config     TopicConfig
+		configured bool
+		queue      []queuedEvent
+		busy       int  // events of the topic being delivered, by a worker or by a sync publish
+		ready      bool // the topic is in the bus' ready list
+		retained   *retainedEvent
+		metrics    *EventMetrics // the topic's counters, see topicCounters
+	}
+
+	// topicCounters holds the metrics of one event name apart from its topic, so they outlive the topic's state.
+	topicCounters struct {
+		metrics EventMetrics
+		idle    *list.Element // the counters' place in the bus' idle list while the bus has no topic for the name
+	}
+
+	// eventBus
+	//
+	// Delivers the events of async and ordered topics with a fixed set of workers shared by all topics. A topic with
+	// queued events is put on the ready list, and a worker takes the first event of the first ready topic. An ordered
+	// topic only goes back on the ready list once its event has been delivered, so its events are delivered one at a
+	// time in the order they were sent, while the events of an async topic are taken by as many workers as are free.
+	eventBus struct {
+		mu       sync.Mutex
+		cond     *sync.Cond // signalled when a topic becomes ready, queue space is freed, or the bus is closed
+		topics   map[string]*topic
+		ready    []*topic
+		counters map[string]*topicCounters
+		idle     *list.List // the names of the counters without a topic, the most recently used first
+		workers  int
+		defaults TopicConfig
+		running  bool
+		closed   bool
+		seq      uint64 // the seq of the last retained event
+		done     sync.WaitGroup
+		// delivers an event to the topic's listeners, returning the number of listener calls that succeeded and failed
+		deliver func(ctx context.Context, source *plugin, event Event) (int, int, error)
+	}
+)
+
+const (
+	// DeliverySync delivers an event to each listener in turn before the sender continues. It is the default.
+	DeliverySync DeliveryMode = "sync"
+	// DeliveryAsync queues the event and returns right away. Queued events are delivered by the event workers,
+	// concurrently and in no particular order.
+	DeliveryAsync DeliveryMode = "async"
+	// DeliveryOrdered queues the event and returns right away. The topic's events are delivered one at a time, in the
+	// order they were sent.
+	DeliveryOrdered DeliveryMode = "ordered"
+
+	// BackpressureBlock makes the sender wait for room in the queue, or until its context is done. It is the default.
+	// A listener sending from an event worker gets ErrEventDropped instead, as the other workers could all be waiting
+	// in turn, with nothing left to make room.
+	BackpressureBlock BackpressurePolicy = "block"
+	// BackpressureDropOldest drops the oldest queued event to make room for the new one.
+	BackpressureDropOldest BackpressurePolicy = "dropOldest"
+	// BackpressureDropNewest drops the event being sent, which returns ErrEventDropped.
+	BackpressureDropNewest BackpressurePolicy = "dropNewest"
+)
+
+// ErrEventDropped is returned when an event is not queued because its topic's queue is full.
+var ErrEventDropped = errors.New("event dropped, topic queue is full")
+
+func newEventBus() *eventBus {
+	defaults, _ := TopicConfig{}.validate()
+
+	b := &eventBus{
+		topics:   make(map[string]*topic),
+		counters: make(map[string]*topicCounters),
+		idle:     list.New(),
+		workers:  defaultEventWorkers,
+		defaults: defaults,
+	}
+	b.cond = sync.NewCond(&b.mu)
+
+	return b
+}
+
+// validate
+//
+// Checks the mode and policy of the config, filling in the defaults for those left empty.
+func (c TopicConfig) validate() (TopicConfig, error) {
+	switch c.Mode {
+	case "":
+		c.Mode = DeliverySync
+	case DeliverySync, DeliveryAsync, DeliveryOrdered:
+	default:
+		return c, errors.New("invalid event delivery mode: " + string(c.Mode))
+	}
+
+	switch c.Backpressure {
+	case "":
+		c.Backpressure = BackpressureBlock
+	case BackpressureBlock, BackpressureDropOldest, BackpressureDropNewest:
+	default:
+		return c, errors.New("invalid event backpressure policy: " + string(c.Backpressure))
+	}
+
+	if c.QueueSize <= 0 {
+		c.QueueSize = defaultEventQueueSize
+	}
+
+	return c, nil
+}
+
+// topic
+//
+// Returns the topic of the event name, creating it with the default config. It must be called with the bus lock held.
+func (b *eventBus) topic(name string) *topic {
+	t := b.topics[name]
+	if nil == t {
+		c := b.counters[name]
+		if nil == c {
+			c = &topicCounters{}
+			b.counters[name] = c
+		} else if nil != c.idle {
+			b.idle.Remove(c.idle)
+			c.idle = nil
+		}
+
+		t = &topic{name: name, config: b.defaults, metrics: &c.metrics}
+		b.topics[name] = t
+	}
+
+	return t
+}
+
+// configure
+//
+// Sets the topic's delivery config. Events already queued stay queued and are delivered under the new config.
+func (b *eventBus) configure(name string, config TopicConfig) error {
+	config, err := config.validate()
+	if nil != err {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	t := b.topic(name)
+	t.config = config
+	t.configured = true
+	b.schedule(t)
+
+	return nil
+}
+
+// release
+//
+// Drops the state of a topic that was not configured once nothing of it is left to deliver or retain, so events sent
+// to ever new names do not grow the bus. Its metrics are kept on the idle list, which forgets the least recently used
+// beyond maxIdleTopicMetrics. It must be called with the bus lock held.
+func (b *eventBus) release(t *topic) {
+	if t.configured || t.ready || t.busy > 0 || len(t.queue) > 0 || nil != t.retained {
+		return
+	}
+
+	if b.topics[t.name] != t {
+		return
+	}
+
+	delete(b.topics, t.name)
+	b.counters[t.name].idle = b.idle.PushFront(t.name)
+
+	for b.idle.Len() > maxIdleTopicMetrics {
+		delete(b.counters, b.idle.Remove(b.idle.Back()).(string))
+	}
+}
+
+// schedule
+//
+// Puts the topic on the ready list when it has a queued event a worker may take. It must be called with the bus lock
+// held.
+func (b *eventBus) schedule(t *topic) {
+	if t.ready || len(t.queue) == 0 || (t.config.Mode != DeliveryAsync && t.busy > 0) {
+		return
+	}
+
+	t.ready = true
+	b.ready = append(b.ready, t)
+	b.cond.Broadcast()
+}
+
+// publish
+//
+// Delivers a sync topic's event right away, or queues it for the workers applying the topic's backpressure policy.
+func (b *eventBus) publish(ctx context.Context, source *plugin, event Event) error {
+	b.mu.Lock()
+	t := b.topic(event.Name)
+	t.metrics.Published++
+
+	if t.config.Retain {
+		b.seq++
+		t.retained = &retainedEvent{seq: b.seq, source: source, event: event}
+		ctx = context.WithValue(ctx, retainedSeqKey{}, b.seq)
+	}
+
+	if t.config.Mode == DeliverySync {
+		t.busy++
+		b.mu.Unlock()
+
+		delivered, failed, err := b.deliver(ctx, source, event)
+
+		b.mu.Lock()
+		t.busy--
+		t.metrics.Delivered += uint64(delivered)
+		t.metrics.Failed += uint64(failed)
+		b.schedule(t)
+		b.release(t)
+		b.mu.Unlock()
+
+		return err
+	}
+
+	defer b.mu.Unlock()
+
+	if b.closed {
+		t.metrics.Dropped++
+		b.release(t)
+		return ErrEngineShutdown
+	}
+
+	if len(t.queue) >= t.config.QueueSize {
+		switch t.config.Backpressure {
+		case BackpressureDropNewest:
+			t.metrics.Dropped++
+			return ErrEventDropped
+		case BackpressureDropOldest:
+			t.queue = t.queue[1:]
+			t.metrics.Dropped++
+		default:
+			// a worker does not wait, as the workers that could make room may all be waiting as well, for the queue
+			// of this topic or of another, or for the one ordered topic event that this worker is delivering
+			if nil != ctx.Value(deliveringTopicKey{}) {
+				t.metrics.Dropped++
+				return ErrEventDropped
+			}
+
+			// wake up to check ctx as well as the queue when ctx is done
+			stop := context.AfterFunc(ctx, func() {
+				b.mu.Lock()
+				b.cond.Broadcast()
+				b.mu.Unlock()
+			})
+			defer stop()
+
+			for len(t.queue) >= t.config.QueueSize && !b.closed && nil == ctx.Err() {
+				b.cond.Wait()
+			}
+
+			if b.closed || nil != ctx.Err() {
+				t.metrics.Dropped++
+				b.release(t)
+				if b.closed {
+					return ErrEngineShutdown
+				}
+				return ctx.Err()
+			}
+		}
+	}
+
+	// the sender's context is not cancelled along with the sender, only its values are carried over
+	t.queue = append(t.queue, queuedEvent{ctx: detachCall(ctx), source: source, event: event})
+	b.start()
+	b.schedule(t)
+
+	return nil
+}
+
+// start
+//
+// Starts the workers on the first queued event. It must be called with the bus lock held.
+func (b *eventBus) start() {
+	if b.running {
+		return
+	}
+
+	b.running = true
+	for i := 0; i < max(b.workers, 1); i++ {
+		b.done.Add(1)
+		go b.work()
+	}
+}
+
+// work
+//
+// The worker loop, taking events off ready topics until the bus is closed and every queued event has been delivered.
+func (b *eventBus) work() {
+	defer b.done.Done()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for {
+		for len(b.ready) == 0 && !b.closed {
+			b.cond.Wait()
+		}
+
+		if len(b.ready) == 0 {
+			return
+		}
+
+		t := b.ready[0]
+		b.ready = b.ready[1:]
+		t.ready = false
+
+		qe := t.queue[0]
+		t.queue = t.queue[1:]
+		t.busy++
+
+		// an async topic's next event may be taken by another worker right away, and there is room for a blocked sender
+		b.schedule(t)
+		b.cond.Broadcast()
+		b.mu.Unlock()
+
+		delivered, failed, _ := b.deliver(context.WithValue(qe.ctx, deliveringTopicKey{}, t), qe.source, qe.event)
+
+		b.mu.Lock()
+		t.busy--
+		t.metrics.Delivered += uint64(delivered)
+		t.metrics.Failed += uint64(failed)
+		b.schedule(t)
+		b.release(t)
+	}
+}
+
+// close
+//
+// Stops accepting queued events and waits, until ctx is done, for the workers to deliver the events already queued.
+func (b *eventBus) close(ctx context.Context) error {
+	b.mu.Lock()
+	b.closed = true
+	b.cond.Broadcast()
+	b.mu.Unlock()
+
+	finished := make(chan struct{})
+	go func() {
+		b.done.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+		return errors.New("queued events were not all delivered: " + ctx.Err().Error())
+	}
+}
+
+// metrics
+//
+// Returns a copy of the metrics of every topic the bus has counters for, with the queue state of those it has a topic
+// for.
+func (b *eventBus) metrics() map[string]EventMetrics {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	metrics := make(map[string]EventMetrics, len(b.counters))
+	for name, c := range b.counters {
+		m := c.metrics
+		if t := b.topics[name]; nil != t {
+			m.Queued = len(t.queue)
+			m.InFlight = t.busy
+			m.Retained = nil != t.retained
+		}
+		metrics[name] = m
+	}
+
+	return metrics
+}
+
+// record
+//
+// Adds the listener calls made outside of publish and the workers, such as retained deliveries, to the topic metrics.
+func (b *eventBus) record(name string, delivered, failed int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	t := b.topic(name)
+	t.metrics.Delivered += uint64(delivered)
+	t.metrics.Failed += uint64(failed)
+	b.release(t)
+}
+
+// retainedEvents
+//
+// Returns the retained events whose name is matched, in the order they were sent.
+func (b *eventBus) retainedEvents(match func(name string) bool) []retainedEvent {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	retained := make([]retainedEvent, 0)
+	for name, t := range b.topics {
+		if nil != t.retained && match(name) {
+			retained = append(retained, *t.retained)
+		}
+	}
+
+	sort.Slice(retained, func(i, j int) bool {
+		return retained[i].seq < retained[j].seq
+	})
+
+	return retained
+}
+
+// clearRetained
+//
+// Drops the retained events whose name is matched and returns how many were dropped.
+func (b *eventBus) clearRetained(match func(name string) bool) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	cleared := 0
+	for name, t := range b.topics {
+		if nil != t.retained && match(name) {
+			t.retained = nil
+			cleared++
+			b.release(t)
+		}
+	}
+
+	return cleared
+}
+
+// ConfigureTopic
+//
+// Sets how the events of the name are delivered to their listeners: synchronously, asynchronously through the event
+// workers, or in order through the event workers, together with the size of the topic's queue and what happens when
+// it is full. A retained topic keeps its last event for listeners that come later, see ClearRetained. Topics that are
+// not configured use the engine's default, DeliverySync unless the engine is created WithEventDelivery.
+func (e *Engine) ConfigureTopic(name string, config TopicConfig) error {
+	return e.events.configure(name, config)
+}
+
+// GetEventMetrics
+//
+// Returns the delivery metrics of every topic, keyed on event name. Only the maxIdleTopicMetrics most recently used
+// topics that were not configured are included, configure a topic to keep counting its events for the life of the
+// engine.
+func (e *Engine) GetEventMetrics() map[string]EventMetrics {
+	return e.events.metrics()
+}
@@ -0,0 +1,354 @@
+package pluginengine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	extism "github.com/extism/go-sdk"
+	gopdk "github.com/spirefy/go-pdk"
+)
+
+func TestEventBus_Ordered(t *testing.T) {
+	engine, err := NewPluginEngine(nil, extism.LogLevelOff, t.TempDir(), WithEventWorkers(8))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := engine.ConfigureTopic("editor.selection", TopicConfig{Mode: DeliveryOrdered}); err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	received := make([]string, 0)
+	_, _ = engine.Subscribe("editor.selection", func(event Event) {
+		// later events would overtake this one if the topic were not ordered
+		if string(event.Data) == "0" {
+			time.Sleep(20 * time.Millisecond)
+		}
+
+		mu.Lock()
+		received = append(received, string(event.Data))
+		mu.Unlock()
+	})
+
+	for _, data := range []string{"0", "1", "2", "3", "4"} {
+		if err := engine.Publish(context.Background(), "editor.selection", []byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := engine.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(received) != 5 {
+		t.Fatalf("Expected all queued events to be delivered by Shutdown, but got %v", received)
+	}
+
+	for i, data := range received {
+		if data != string(rune('0'+i)) {
+			t.Errorf("Expected events in the order they were sent, but got %v", received)
+			break
+		}
+	}
+
+	if m := engine.GetEventMetrics()["editor.selection"]; m.Published != 5 || m.Delivered != 5 || m.Queued != 0 {
+		t.Errorf("Expected 5 published and delivered events, but got %+v", m)
+	}
+}
+
+func TestEventBus_Async(t *testing.T) {
+	engine, err := NewPluginEngine(nil, extism.LogLevelOff, t.TempDir(), WithEventWorkers(4), WithEventDelivery(TopicConfig{Mode: DeliveryAsync}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	release := make(chan struct{})
+	started := make(chan struct{}, 4)
+	_, _ = engine.Subscribe("window.resized", func(event Event) {
+		started <- struct{}{}
+		<-release
+	})
+
+	for i := 0; i < 4; i++ {
+		if err := engine.Publish(context.Background(), "window.resized", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// every worker picks up an event of the same topic at the same time
+	for i := 0; i < 4; i++ {
+		select {
+		case <-started:
+		case <-time.After(time.Second):
+			t.Fatalf("Expected async events to be delivered concurrently, but only %d started", i)
+		}
+	}
+
+	if m := engine.GetEventMetrics()["window.resized"]; m.InFlight != 4 {
+		t.Errorf("Expected 4 events in flight, but got %+v", m)
+	}
+
+	close(release)
+	_ = engine.Shutdown(context.Background())
+}
+
+func TestEventBus_Backpressure(t *testing.T) {
+	tests := []struct {
+		policy   BackpressurePolicy
+		expected []string
+		err      error
+	}{
+		{BackpressureDropNewest, []string{"busy", "1", "2"}, ErrEventDropped},
+		{BackpressureDropOldest, []string{"busy", "2", "3"}, nil},
+		{BackpressureBlock, []string{"busy", "1", "2"}, context.DeadlineExceeded},
+	}
+
+	for _, test := range tests {
+		engine, err := NewPluginEngine(nil, extism.LogLevelOff, t.TempDir(), WithEventWorkers(1))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := engine.ConfigureTopic("status", TopicConfig{Mode: DeliveryOrdered, QueueSize: 2, Backpressure: test.policy}); err != nil {
+			t.Fatal(err)
+		}
+
+		release := make(chan struct{})
+		started := make(chan struct{})
+		received := make([]string, 0)
+		_, _ = engine.Subscribe("status", func(event Event) {
+			if string(event.Data) == "busy" {
+				close(started)
+				<-release
+			}
+			received = append(received, string(event.Data))
+		})
+
+		// keep the only worker busy, so the next events wait in the queue of 2
+		_ = engine.Publish(context.Background(), "status", []byte("busy"))
+		<-started
+
+		_ = engine.Publish(context.Background(), "status", []byte("1"))
+		_ = engine.Publish(context.Background(), "status", []byte("2"))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		err = engine.Publish(ctx, "status", []byte("3"))
+		cancel()
+
+		if !errors.Is(err, test.err) && !(nil == test.err && nil == err) {
+			t.Errorf("Expected %v publishing to a full %s queue, but got %v", test.err, test.policy, err)
+		}
+
+		close(release)
+		_ = engine.Shutdown(context.Background())
+
+		if len(received) != len(test.expected) || received[1] != test.expected[1] || received[2] != test.expected[2] {
+			t.Errorf("Expected %v delivered with %s, but got %v", test.expected, test.policy, received)
+		}
+
+		if m := engine.GetEventMetrics()["status"]; m.Dropped != 1 || m.Published != 4 {
+			t.Errorf("Expected 1 of 4 events dropped with %s, but got %+v", test.policy, m)
+		}
+	}
+}
+
+func TestEventBus_PublishFromWorker(t *testing.T) {
+	engine, err := NewPluginEngine(nil, extism.LogLevelOff, t.TempDir(), WithEventWorkers(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := engine.ConfigureTopic("status", TopicConfig{Mode: DeliveryOrdered, QueueSize: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the listener sends two events to its own ordered topic while its worker is busy delivering to it
+	results := make(chan []error, 1)
+	native := &testNativePlugin{manifest: NativeManifest{
+		Plugin:    gopdk.Plugin{Id: "com.acme.status", Version: "1.0.0"},
+		Listeners: []NativeListener{{Event: "status", Func: "onStatus"}},
+	}}
+	native.call = func(ctx context.Context, fn string, data []byte) ([]byte, error) {
+		results <- []error{engine.Publish(ctx, "status", []byte("1")), engine.Publish(ctx, "status", []byte("2"))}
+		return nil, nil
+	}
+
+	if err := engine.RegisterNativePlugin(native); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := engine.Publish(context.Background(), "status", []byte("go")); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case errs := <-results:
+		if errs[0] != nil || !errors.Is(errs[1], ErrEventDropped) {
+			t.Errorf("Expected the event that does not fit the queue to be dropped, but got %v", errs)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out, the listener is waiting for its own worker")
+	}
+
+	if err := engine.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if m := engine.GetEventMetrics()["status"]; m.Dropped != 1 || m.Published != 3 {
+		t.Errorf("Expected 1 of 3 events dropped, but got %+v", m)
+	}
+}
+
+func TestEventBus_PublishFromWorkers(t *testing.T) {
+	engine, err := NewPluginEngine(nil, extism.LogLevelOff, t.TempDir(), WithEventWorkers(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := engine.ConfigureTopic("status", TopicConfig{Mode: DeliveryAsync, QueueSize: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	// both workers deliver an event whose listener sends to the topic while its queue is full, so neither worker is
+	// left to make room for the other
+	started := make(chan struct{}, 2)
+	release := make(chan struct{})
+	finished := make(chan struct{})
+	results := make(chan error, 2)
+	native := &testNativePlugin{manifest: NativeManifest{
+		Plugin:    gopdk.Plugin{Id: "com.acme.status", Version: "1.0.0"},
+		Listeners: []NativeListener{{Event: "status", Func: "onStatus"}},
+	}}
+	native.call = func(ctx context.Context, fn string, data []byte) ([]byte, error) {
+		var event Event
+		_ = json.Unmarshal(data, &event)
+		if string(event.Data) == "busy" {
+			started <- struct{}{}
+			<-release
+			results <- engine.Publish(ctx, "status", []byte("more"))
+			// neither worker takes the queued event before both have sent
+			<-finished
+		}
+		return nil, nil
+	}
+
+	if err := engine.RegisterNativePlugin(native); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := engine.Publish(context.Background(), "status", []byte("busy")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		<-started
+	}
+
+	if err := engine.Publish(context.Background(), "status", []byte("queued")); err != nil {
+		t.Fatal(err)
+	}
+	close(release)
+
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-results:
+			if !errors.Is(err, ErrEventDropped) {
+				t.Errorf("Expected the event sent to the full queue to be dropped, but got %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out, the workers are waiting for each other")
+		}
+	}
+	close(finished)
+
+	if err := engine.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if m := engine.GetEventMetrics()["status"]; m.Dropped != 2 || m.Published != 5 || m.Delivered != 3 {
+		t.Errorf("Expected 2 of 5 events dropped and 3 delivered, but got %+v", m)
+	}
+}
+
+func TestEventBus_Topics(t *testing.T) {
+	engine, err := NewPluginEngine(nil, extism.LogLevelOff, t.TempDir(), WithEventDelivery(TopicConfig{Mode: DeliveryAsync}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := engine.ConfigureTopic("status", TopicConfig{Mode: DeliveryOrdered}); err != nil {
+		t.Fatal(err)
+	}
+	if err := engine.ConfigureTopic("editor.sync", TopicConfig{}); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 100; i++ {
+		_ = engine.Publish(context.Background(), "editor.opened."+strconv.Itoa(i), nil)
+		_ = engine.Publish(context.Background(), "editor.sync", nil)
+	}
+
+	if err := engine.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// the events of names that were not configured leave only their metrics behind once delivered
+	if len(engine.events.topics) != 2 {
+		t.Errorf("Expected only the configured topics to be kept, but got %d", len(engine.events.topics))
+	}
+
+	metrics := engine.GetEventMetrics()
+	if len(metrics) != 102 || metrics["editor.sync"].Published != 100 || metrics["editor.opened.0"].Published != 1 {
+		t.Errorf("Expected the metrics of every topic to be kept, but got %d", len(metrics))
+	}
+}
+
+func TestEventBus_IdleTopicMetrics(t *testing.T) {
+	engine := newTestEngine(t)
+
+	for i := 0; i < 2; i++ {
+		if err := engine.Publish(context.Background(), "editor.saved", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if m := engine.GetEventMetrics()["editor.saved"]; m.Published != 2 {
+		t.Errorf("Expected an idle sync topic to keep counting its events, but got %+v", m)
+	}
+
+	for i := 0; i < maxIdleTopicMetrics; i++ {
+		_ = engine.Publish(context.Background(), "editor.opened."+strconv.Itoa(i), nil)
+	}
+
+	metrics := engine.GetEventMetrics()
+	if len(metrics) != maxIdleTopicMetrics {
+		t.Errorf("Expected the metrics of %d idle topics to be kept, but got %d", maxIdleTopicMetrics, len(metrics))
+	}
+
+	if _, ok := metrics["editor.saved"]; ok {
+		t.Errorf("Expected the least recently used topic to be forgotten")
+	}
+
+	if m := metrics["editor.opened.0"]; m.Published != 1 {
+		t.Errorf("Expected a more recently used topic to keep its metrics, but got %+v", m)
+	}
+}
+
+func TestConfigureTopic_Invalid(t *testing.T) {
+	engine := newTestEngine(t)
+
+	if err := engine.ConfigureTopic("status", TopicConfig{Mode: "later"}); err == nil {
+		t.Errorf("Expected an invalid delivery mode to be refused")
+	}
+
+	if err := engine.ConfigureTopic("status", TopicConfig{Backpressure: "spill"}); err == nil {
+		t.Errorf("Expected an invalid backpressure policy to be refused")
+	}
+}
@@ -0,0 +1,305 @@
+package pluginengine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+type (
+	// Event is what every listener of an event receives. Data is marshalled by the sender, and it is up to each
+	// listener to unmarshal it. Wasm listeners are called with the Event marshalled as json, Data base64 encoded.
+	Event struct {
+		Name string `json:"name"`
+		Data []byte `json:"data"`
+		// the plugin (id@version) that sent the event, empty when it was published by the host
+		Source string `json:"source,omitempty"`
+	}
+
+	// eventListener is a listener declared in a plugin manifest, or registered at runtime through the
+	// AddEventListener host function: the exported func of the plugin to call for each event the topic pattern (see
+	// topicPattern) matches.
+	//
+	//	listeners:
+	//	  - event: editor.selection.#
+	//	    func: onSelection
+	eventListener struct {
+		Event string `json:"event" yaml:"event"`
+		Func  string `json:"func" yaml:"func"`
+	}
+
+	// Subscription describes a listener registered on the event bus, see GetSubscriptions.
+	Subscription struct {
+		Pattern string `json:"pattern"`
+		// the plugin (id@version) and exported func the events are delivered to, both empty for a host listener
+		Plugin string `json:"plugin,omitempty"`
+		Func   string `json:"func,omitempty"`
+	}
+
+	// listener is a registration on the event bus, either a plugin's exported func or a host Go handler.
+	listener struct {
+		pattern *topicPattern
+		plugin  *plugin // nil for host handlers
+		fn      string
+		handler func(Event)
+	}
+)
+
+// newListener
+//
+// Returns a listener for the events the topic pattern matches, or an error when the pattern is invalid.
+func newListener(pattern string, p *plugin, fn string, handler func(Event)) (*listener, error) {
+	tp, err := compileTopicPattern(pattern)
+	if nil != err {
+		return nil, err
+	}
+
+	return &listener{pattern: tp, plugin: p, fn: fn, handler: handler}, nil
+}
+
+// addListener
+//
+// Registers the listener on the event bus, returning false when it was already registered. A plugin func that is
+// already registered for the same pattern is not added twice, since every instance of a plugin runs start and may
+// register the same listener. It must be called with the engine lock held.
+func (e *Engine) addListener(l *listener) bool {
+	if nil != l.plugin {
+		for _, existing := range e.listeners {
+			if existing.plugin == l.plugin && existing.fn == l.fn && existing.pattern.raw == l.pattern.raw {
+				return false
+			}
+		}
+	}
+
+	e.listeners = append(e.listeners, l)
+	return true
+}
+
+// removeListeners
+//
+// Removes every listener for which match returns true. It must be called with the engine lock held.
+func (e *Engine) removeListeners(match func(*listener) bool) {
+	kept := make([]*listener, 0, len(e.listeners))
+	for _, l := range e.listeners {
+		if !match(l) {
+			kept = append(kept, l)
+		}
+	}
+
+	e.listeners = kept
+}
+
+// Subscribe
+//
+// Registers a Go handler that is called for every event the topic pattern matches (see topicPattern, e.g. window.* or
+// editor.selection.#), whether sent by a plugin through the SendEvent host function or by the host through Publish.
+// The retained events the pattern matches are sent to the handler before Subscribe returns. The returned func removes
+// the handler again.
+func (e *Engine) Subscribe(pattern string, handler func(Event)) (func(), error) {
+	l, err := newListener(pattern, nil, "", handler)
+	if nil != err {
+		return nil, err
+	}
+
+	e.mu.Lock()
+	e.addListener(l)
+	e.mu.Unlock()
+
+	e.deliverRetained(e.context, []*listener{l})
+
+	return func() {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+
+		e.removeListeners(func(other *listener) bool {
+			return other == l
+		})
+	}, nil
+}
+
+// GetSubscriptions
+//
+// Returns the listeners registered on the event bus, keyed on the plugin (id@version) they belong to, in the order
+// they were registered. The host's own listeners, registered with Subscribe, are under the empty key.
+func (e *Engine) GetSubscriptions() map[string][]Subscription {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	subscriptions := make(map[string][]Subscription)
+	for _, l := range e.listeners {
+		s := Subscription{Pattern: l.pattern.raw, Func: l.fn}
+		if nil != l.plugin {
+			s.Plugin = pluginKey(l.plugin.Id, l.plugin.Version)
+		}
+
+		subscriptions[s.Plugin] = append(subscriptions[s.Plugin], s)
+	}
+
+	return subscriptions
+}
+
+// Publish
+//
+// Sends the event from the host to every listener of the name, the same way a plugin does with SendEvent. How the
+// listeners are called depends on the topic's delivery mode (see ConfigureTopic). For a DeliverySync topic they are
+// called one after the other in the order they were registered, Publish returns once all of them have been called and
+// the errors of plugin listeners that failed are joined in the returned error. For an async or ordered topic Publish
+// returns once the event is queued.
+func (e *Engine) Publish(ctx context.Context, name string, data []byte) error {
+	// a native plugin publishing with the context of its call is the sender, so it does not receive the event itself
+	return e.sendEvent(ctx, callingPlugin(ctx), name, data)
+}
+
+// sendEvent
+//
+// Sends the event to the listeners of its name through the event bus. source is the sending plugin, nil for the host.
+func (e *Engine) sendEvent(ctx context.Context, source *plugin, name string, data []byte) error {
+	event := Event{Name: name, Data: data}
+	if nil != source {
+		event.Source = pluginKey(source.Id, source.Version)
+	}
+
+	return e.events.publish(ctx, source, event)
+}
+
+// deliverEvent
+//
+// Calls the listeners of the event one after the other and returns how many calls succeeded and failed. A plugin does
+// not receive its own events, and listeners of plugins that are not resolved are skipped.
+func (e *Engine) deliverEvent(ctx context.Context, source *plugin, event Event) (int, int, error) {
+	name := event.Name
+
+	e.mu.RLock()
+	ls := make([]*listener, 0)
+	for _, l := range e.listeners {
+		if !l.pattern.match(name) || (nil != l.plugin && (l.plugin == source || !l.plugin.Resolved)) {
+			continue
+		}
+
+		// a listener allowed a wildcard pattern is still not sent the events the policy denies it
+		if nil != l.plugin && e.denies(l.plugin.Id, CapabilityEventsSubscribe, name) {
+			continue
+		}
+
+		ls = append(ls, l)
+	}
+	e.mu.RUnlock()
+
+	if len(ls) == 0 {
+		return 0, 0, nil
+	}
+
+	var errs []error
+	delivered := 0
+	for _, l := range ls {
+		if err := e.callListener(ctx, l, event); nil != err {
+			errs = append(errs, err)
+			continue
+		}
+
+		delivered++
+	}
+
+	return delivered, len(errs), errors.Join(errs...)
+}
+
+// callListener
+//
+// Calls the listener with the event, the Go handler of a host listener or the exported func of a plugin listener.
+func (e *Engine) callListener(ctx context.Context, l *listener, event Event) error {
+	if nil != l.handler {
+		l.handler(event)
+		return nil
+	}
+
+	payload, err := json.Marshal(event)
+	if nil != err {
+		return err
+	}
+
+	target := "listener " + l.fn + " of plugin " + pluginKey(l.plugin.Id, l.plugin.Version)
+	if _, err := e.callPlugin(ctx, l.plugin, l.fn, payload, target); nil != err {
+		e.pluginLogger(l.plugin).Error("error delivering event", "event", event.Name, "func", l.fn, "error", err)
+		return fmt.Errorf("event %s to %s: %w", event.Name, target, err)
+	}
+
+	return nil
+}
+
+// pluginListeners
+//
+// Returns the listeners registered for the plugin.
+func (e *Engine) pluginListeners(p *plugin) []*listener {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	ls := make([]*listener, 0)
+	for _, l := range e.listeners {
+		if l.plugin == p {
+			ls = append(ls, l)
+		}
+	}
+
+	return ls
+}
+
+// deliverRetained
+//
+// Sends the listeners the retained events their patterns match, oldest first, so a listener that subscribes, or whose
+// plugin starts, after an event of a retained topic was sent still learns the current state. As with any event, a
+// plugin is not sent the events it sent itself. The retained event being delivered in ctx is left to that delivery.
+func (e *Engine) deliverRetained(ctx context.Context, ls []*listener) {
+	if len(ls) == 0 {
+		return
+	}
+
+	delivering, _ := ctx.Value(retainedSeqKey{}).(uint64)
+
+	retained := e.events.retainedEvents(func(name string) bool {
+		for _, l := range ls {
+			if l.pattern.match(name) {
+				return true
+			}
+		}
+		return false
+	})
+
+	for _, r := range retained {
+		if r.seq == delivering {
+			continue
+		}
+
+		delivered, failed := 0, 0
+		for _, l := range ls {
+			if !l.pattern.match(r.event.Name) || (nil != l.plugin && l.plugin == r.source) {
+				continue
+			}
+
+			if nil != l.plugin && e.denies(l.plugin.Id, CapabilityEventsSubscribe, r.event.Name) {
+				continue
+			}
+
+			if err := e.callListener(ctx, l, r.event); nil != err {
+				failed++
+			} else {
+				delivered++
+			}
+		}
+
+		e.events.record(r.event.Name, delivered, failed)
+	}
+}
+
+// ClearRetained
+//
+// Drops the retained events of every topic the pattern matches (see topicPattern, # clears them all), so listeners
+// that come later are not sent them, and returns how many were dropped.
+func (e *Engine) ClearRetained(pattern string) (int, error) {
+	tp, err := compileTopicPattern(pattern)
+	if nil != err {
+		return 0, err
+	}
+
+	return e.events.clearRetained(tp.match), nil
+}
@@ -0,0 +1,189 @@
+package pluginengine
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+
+	gopdk "github.com/spirefy/go-pdk"
+)
+
+func TestPublish_GoListeners(t *testing.T) {
+	engine := newTestEngine(t)
+
+	received := make([]string, 0)
+	unsubscribe, err := engine.Subscribe("editor.selection", func(event Event) {
+		received = append(received, "first:"+string(event.Data))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = engine.Subscribe("editor.*", func(event Event) {
+		received = append(received, "second:"+string(event.Data))
+	})
+	_, _ = engine.Subscribe("editor.closed", func(event Event) {
+		received = append(received, "closed")
+	})
+
+	if err := engine.Publish(context.Background(), "editor.selection", []byte("a")); err != nil {
+		t.Fatalf("Expected no error publishing, but got %v", err)
+	}
+
+	unsubscribe()
+
+	if err := engine.Publish(context.Background(), "editor.selection", []byte("b")); err != nil {
+		t.Fatalf("Expected no error publishing, but got %v", err)
+	}
+
+	expected := []string{"first:a", "second:a", "second:b"}
+	if len(received) != len(expected) {
+		t.Fatalf("Expected %v, but got %v", expected, received)
+	}
+
+	for i := range expected {
+		if received[i] != expected[i] {
+			t.Errorf("Expected %v, but got %v", expected, received)
+		}
+	}
+}
+
+func TestEventListeners_PluginLifecycle(t *testing.T) {
+	engine := newTestEngine(t)
+
+	editor := &plugin{Listeners: []eventListener{{Event: "window.focused", Func: "onFocus"}}}
+	engine.addPlugin(editor, gopdk.Plugin{Id: "com.acme.editor", Version: "1.0.0"})
+
+	if ls := engine.listeners; len(ls) != 1 || ls[0].plugin != editor || ls[0].fn != "onFocus" {
+		t.Fatalf("Expected the manifest listener to be registered")
+	}
+
+	// registering the same func again at runtime, e.g. from start of a second instance, does not deliver twice
+	for _, pattern := range []string{"window.focused", "window.#"} {
+		l, _ := newListener(pattern, editor, "onFocus", nil)
+		engine.addListener(l)
+	}
+
+	subscriptions := engine.GetSubscriptions()["com.acme.editor@1.0.0"]
+	if len(subscriptions) != 2 || subscriptions[1].Pattern != "window.#" || subscriptions[1].Func != "onFocus" {
+		t.Errorf("Expected duplicate plugin listeners to be ignored, but got %v", subscriptions)
+	}
+
+	// a listener of an unresolved plugin is skipped, so publishing does not try to instantiate it
+	blocked := &plugin{
+		Dependencies: []dependency{{Plugin: "com.acme.missing"}},
+		Listeners:    []eventListener{{Event: "window.focused", Func: "onFocus"}},
+	}
+	engine.addPlugin(blocked, gopdk.Plugin{Id: "com.acme.blocked", Version: "1.0.0"})
+
+	if err := engine.sendEvent(context.Background(), editor, "window.focused", nil); err != nil {
+		t.Errorf("Expected the sender and unresolved listeners to be skipped, but got %v", err)
+	}
+
+	if err := engine.UnloadPlugin("com.acme.editor", "1.0.0"); err != nil {
+		t.Fatal(err)
+	}
+
+	subscriptions = engine.GetSubscriptions()["com.acme.editor@1.0.0"]
+	if len(subscriptions) != 0 {
+		t.Errorf("Expected unloaded plugin's listeners to be removed, but got %v", subscriptions)
+	}
+
+	if len(engine.GetSubscriptions()["com.acme.blocked@1.0.0"]) != 1 {
+		t.Errorf("Expected other plugins' listeners to be kept")
+	}
+}
+
+func TestRetainedEvents(t *testing.T) {
+	engine := newTestEngine(t)
+
+	for _, name := range []string{"editor.selection", "window.focused"} {
+		if err := engine.ConfigureTopic(name, TopicConfig{Retain: true}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_ = engine.Publish(context.Background(), "window.focused", []byte("files"))
+	_ = engine.Publish(context.Background(), "editor.selection", []byte("old"))
+	_ = engine.Publish(context.Background(), "editor.selection", []byte("line 4"))
+	_ = engine.Publish(context.Background(), "editor.closed", []byte("not retained"))
+
+	received := make([]string, 0)
+	_, err := engine.Subscribe("#", func(event Event) {
+		received = append(received, event.Name+"="+string(event.Data))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"window.focused=files", "editor.selection=line 4"}
+	if len(received) != len(expected) || received[0] != expected[0] || received[1] != expected[1] {
+		t.Errorf("Expected the last event of each retained topic, oldest first, but got %v", received)
+	}
+
+	if m := engine.GetEventMetrics()["editor.selection"]; !m.Retained || m.Delivered != 1 {
+		t.Errorf("Expected the retained delivery to be counted, but got %+v", m)
+	}
+
+	cleared, err := engine.ClearRetained("editor.#")
+	if err != nil || cleared != 1 {
+		t.Errorf("Expected 1 retained event to be cleared, but got %d %v", cleared, err)
+	}
+
+	received = received[:0]
+	_, _ = engine.Subscribe("*.*", func(event Event) {
+		received = append(received, event.Name)
+	})
+
+	if len(received) != 1 || received[0] != "window.focused" {
+		t.Errorf("Expected only the retained events that were not cleared, but got %v", received)
+	}
+}
+
+func TestRetainedEvents_StartedByDelivery(t *testing.T) {
+	engine := newTestEngine(t)
+
+	for _, name := range []string{"editor.opened", "editor.selection"} {
+		if err := engine.ConfigureTopic(name, TopicConfig{Retain: true}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_ = engine.Publish(context.Background(), "editor.opened", []byte("main.go"))
+
+	var mu sync.Mutex
+	received := make([]string, 0)
+	native := &testNativePlugin{manifest: NativeManifest{
+		Plugin:    gopdk.Plugin{Id: "com.acme.status", Version: "1.0.0"},
+		Listeners: []NativeListener{{Event: "editor.#", Func: "onEditor"}},
+	}}
+	native.call = func(ctx context.Context, fn string, data []byte) ([]byte, error) {
+		event := Event{}
+		if err := json.Unmarshal(data, &event); err != nil {
+			return nil, err
+		}
+
+		mu.Lock()
+		received = append(received, event.Name+"="+string(event.Data))
+		mu.Unlock()
+		return nil, nil
+	}
+
+	if err := engine.RegisterNativePlugin(native); err != nil {
+		t.Fatal(err)
+	}
+
+	// the event starts the plugin, which is sent the retained events it missed but not this one a second time
+	if err := engine.Publish(context.Background(), "editor.selection", []byte("line 4")); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"editor.opened=main.go", "editor.selection=line 4"}
+	if len(received) != len(expected) || received[0] != expected[0] || received[1] != expected[1] {
+		t.Errorf("Expected %v, but got %v", expected, received)
+	}
+
+	if m := engine.GetEventMetrics()["editor.selection"]; m.Delivered != 1 {
+		t.Errorf("Expected the event to be delivered once, but got %+v", m)
+	}
+}
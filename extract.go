@@ -0,0 +1,257 @@
+package pluginengine
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+type (
+	// ExtractLimits bounds what extracting a plugin archive may write, so a small compressed archive can not fill the
+	// disk. A limit of 0 is no limit.
+	ExtractLimits struct {
+		// the size of the archive itself, in bytes, checked before it is extracted and while it is downloaded
+		MaxArchiveSize int64
+		// the uncompressed size of all of the archive's files together, in bytes
+		MaxTotalSize int64
+		// the uncompressed size of a single file, in bytes
+		MaxFileSize int64
+		// the number of entries (files, directories and links)
+		MaxEntries int
+		// the uncompressed size of the files over the size of the archive, checked once more than ratioCheckThreshold
+		// bytes are extracted so small archives of very compressible text are not refused
+		MaxRatio float64
+	}
+
+	// extraction tracks the extraction of one archive: the limits, what has been written so far, and what was created
+	// so it can be cleaned up when the extraction fails.
+	extraction struct {
+		root        string
+		outputPath  string
+		limits      ExtractLimits
+		archiveSize int64
+		entries     int
+		total       int64
+		// set when the output path did not exist before the extraction
+		fresh   bool
+		created []string
+	}
+
+	// limitedWriter counts what is written to a file of an extraction, refusing to write past the limits.
+	limitedWriter struct {
+		x       *extraction
+		w       io.Writer
+		name    string
+		written int64
+	}
+)
+
+const (
+	defaultMaxArchiveSize = 1 << 30
+	defaultMaxTotalSize   = 1 << 30
+	defaultMaxFileSize    = 512 << 20
+	defaultMaxEntries     = 10000
+	defaultMaxRatio       = 200
+	ratioCheckThreshold   = 1 << 20
+)
+
+// ErrArchiveLimit is wrapped by the error Untar and Unzip return when an archive exceeds its ExtractLimits.
+var ErrArchiveLimit = errors.New("plugin archive exceeds extraction limits")
+
+// DefaultExtractLimits
+//
+// Returns the limits Untar, Unzip and the engine use unless told otherwise: a 1 GiB archive, 1 GiB in all, 512 MiB per
+// file, 10000 entries and a compression ratio of 200.
+func DefaultExtractLimits() ExtractLimits {
+	return ExtractLimits{
+		MaxArchiveSize: defaultMaxArchiveSize,
+		MaxTotalSize:   defaultMaxTotalSize,
+		MaxFileSize:    defaultMaxFileSize,
+		MaxEntries:     defaultMaxEntries,
+		MaxRatio:       defaultMaxRatio,
+	}
+}
+
+// checkArchiveSize
+//
+// Returns an error wrapping ErrArchiveLimit when an archive of the size is over MaxArchiveSize.
+func (limits ExtractLimits) checkArchiveSize(archive string, size int64) error {
+	if limits.MaxArchiveSize > 0 && size > limits.MaxArchiveSize {
+		return fmt.Errorf("%w: %s is larger than %d bytes", ErrArchiveLimit, archive, limits.MaxArchiveSize)
+	}
+
+	return nil
+}
+
+// newExtraction
+//
+// Starts the extraction of the archive, of the size, to outputPath, creating outputPath if need be.
+func newExtraction(archive string, size int64, outputPath string, limits ExtractLimits) (*extraction, error) {
+	if err := limits.checkArchiveSize(archive, size); nil != err {
+		return nil, err
+	}
+
+	x := &extraction{outputPath: outputPath, limits: limits, archiveSize: size}
+	if _, err := os.Lstat(outputPath); errors.Is(err, os.ErrNotExist) {
+		x.fresh = true
+	}
+
+	root, err := extractRoot(outputPath)
+	if nil != err {
+		return nil, err
+	}
+	x.root = root
+
+	return x, nil
+}
+
+// entry
+//
+// Counts an entry of the archive against MaxEntries and returns the path it extracts to, see entryPath. A symlink
+// already at the path is removed so the entry replaces it.
+func (x *extraction) entry(name string) (string, error) {
+	x.entries++
+	if x.limits.MaxEntries > 0 && x.entries > x.limits.MaxEntries {
+		return "", fmt.Errorf("%w: more than %d entries", ErrArchiveLimit, x.limits.MaxEntries)
+	}
+
+	path, err := entryPath(x.root, name)
+	if nil != err {
+		return "", err
+	}
+
+	if err := removeSymlink(path); nil != err {
+		return "", err
+	}
+
+	return path, nil
+}
+
+// mkdirAll
+//
+// Creates the directory and its missing parents, remembering the ones it created.
+func (x *extraction) mkdirAll(dir string) error {
+	missing := make([]string, 0)
+	for d := dir; ; d = filepath.Dir(d) {
+		if _, err := os.Lstat(d); nil == err || filepath.Dir(d) == d {
+			break
+		}
+		missing = append(missing, d)
+	}
+
+	if err := os.MkdirAll(dir, 0755); nil != err {
+		return err
+	}
+
+	for i := len(missing) - 1; i >= 0; i-- {
+		x.created = append(x.created, missing[i])
+	}
+
+	return nil
+}
+
+// writeFile
+//
+// Writes the contents of a file entry to path, creating its directory if need be, and stops with an error wrapping
+// ErrArchiveLimit as soon as the file or the archive exceeds the limits. A file that was already there is overwritten
+// but not recorded, so cleanup does not remove it.
+func (x *extraction) writeFile(path string, r io.Reader, mode os.FileMode) (err error) {
+	if err := x.mkdirAll(filepath.Dir(path)); nil != err {
+		return err
+	}
+
+	_, statErr := os.Lstat(path)
+	existed := nil == statErr
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if nil != err {
+		return err
+	}
+	if !existed {
+		x.created = append(x.created, path)
+	}
+
+	defer func(f *os.File) {
+		if closeErr := f.Close(); nil == err {
+			err = closeErr
+		}
+	}(f)
+
+	_, err = io.Copy(&limitedWriter{x: x, w: f, name: filepath.Base(path)}, r)
+	return err
+}
+
+// link
+//
+// Records a symlink or hardlink created at path, for cleanup.
+func (x *extraction) link(path string) {
+	x.created = append(x.created, path)
+}
+
+// cleanup
+//
+// Removes what a failed extraction wrote: all of the output path when the extraction created it, otherwise the files
+// and links it wrote and the directories it created, leaving the rest of what was there before alone.
+func (x *extraction) cleanup() {
+	if x.fresh {
+		_ = os.RemoveAll(x.outputPath)
+		return
+	}
+
+	for i := len(x.created) - 1; i >= 0; i-- {
+		// directories are only removed when empty, they may hold files that were there before
+		_ = os.Remove(x.created[i])
+	}
+}
+
+// extractStaged
+//
+// Runs extract against a temporary directory next to outputPath and, when it succeeds, moves the result into place
+// in place of whatever was at outputPath. When it fails, outputPath is left exactly as it was.
+func extractStaged(outputPath string, extract func(dir string) error) error {
+	staging, err := os.MkdirTemp(filepath.Dir(outputPath), "."+filepath.Base(outputPath)+"-")
+	if nil != err {
+		return err
+	}
+
+	if err := extract(staging); nil != err {
+		_ = os.RemoveAll(staging)
+		return err
+	}
+
+	// set aside what was there, so it can be put back if the new directory cannot be moved in to place
+	previous := staging + ".previous"
+	if err := os.Rename(outputPath, previous); nil != err && !errors.Is(err, os.ErrNotExist) {
+		_ = os.RemoveAll(staging)
+		return err
+	}
+
+	if err := os.Rename(staging, outputPath); nil != err {
+		_ = os.Rename(previous, outputPath)
+		_ = os.RemoveAll(staging)
+		return err
+	}
+
+	_ = os.RemoveAll(previous)
+	return nil
+}
+
+func (l *limitedWriter) Write(p []byte) (int, error) {
+	l.written += int64(len(p))
+	l.x.total += int64(len(p))
+
+	limits := l.x.limits
+	switch {
+	case limits.MaxFileSize > 0 && l.written > limits.MaxFileSize:
+		return 0, fmt.Errorf("%w: %s is larger than %d bytes", ErrArchiveLimit, l.name, limits.MaxFileSize)
+	case limits.MaxTotalSize > 0 && l.x.total > limits.MaxTotalSize:
+		return 0, fmt.Errorf("%w: larger than %d bytes uncompressed", ErrArchiveLimit, limits.MaxTotalSize)
+	case limits.MaxRatio > 0 && l.x.total > ratioCheckThreshold && float64(l.x.total) > limits.MaxRatio*float64(max(l.x.archiveSize, 1)):
+		return 0, fmt.Errorf("%w: compression ratio above %s", ErrArchiveLimit, strconv.FormatFloat(limits.MaxRatio, 'f', -1, 64))
+	}
+
+	return l.w.Write(p)
+}
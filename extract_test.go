@@ -0,0 +1,152 @@
+package pluginengine
+
+import (
+	"archive/tar"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExtract_Limits(t *testing.T) {
+	tests := []struct {
+		name    string
+		limits  ExtractLimits
+		entries []archiveEntry
+	}{
+		{name: "file size", limits: ExtractLimits{MaxFileSize: 10}, entries: []archiveEntry{
+			{name: "small.txt", typeflag: tar.TypeReg, contents: "small"},
+			{name: "large.txt", typeflag: tar.TypeReg, contents: strings.Repeat("x", 11)},
+		}},
+		{name: "total size", limits: ExtractLimits{MaxTotalSize: 15}, entries: []archiveEntry{
+			{name: "a.txt", typeflag: tar.TypeReg, contents: strings.Repeat("a", 10)},
+			{name: "b.txt", typeflag: tar.TypeReg, contents: strings.Repeat("b", 10)},
+		}},
+		{name: "entries", limits: ExtractLimits{MaxEntries: 2}, entries: []archiveEntry{
+			{name: "lib/", typeflag: tar.TypeDir},
+			{name: "lib/a.txt", typeflag: tar.TypeReg, contents: "a"},
+			{name: "lib/b.txt", typeflag: tar.TypeReg, contents: "b"},
+		}},
+		{name: "ratio", limits: ExtractLimits{MaxRatio: 100}, entries: []archiveEntry{
+			{name: "zeros.bin", typeflag: tar.TypeReg, contents: strings.Repeat("\x00", 2<<20)},
+		}},
+	}
+
+	for _, test := range tests {
+		for _, ext := range []string{".tar.gz", ".zip"} {
+			dir := t.TempDir()
+			archive := filepath.Join(dir, "plugin"+ext)
+			outputPath := filepath.Join(dir, "out")
+
+			var err error
+			if ext == ".zip" {
+				writeTestZip(t, archive, test.entries)
+				err = UnzipWithLimits(archive, outputPath, test.limits)
+			} else {
+				writeTestTar(t, archive, test.entries)
+				err = UntarWithLimits(archive, outputPath, test.limits)
+			}
+
+			if !errors.Is(err, ErrArchiveLimit) {
+				t.Errorf("Expected ErrArchiveLimit for %s %s, but got %v", test.name, ext, err)
+			}
+
+			if _, err := os.Stat(outputPath); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("Expected the output path of %s %s to be removed, but got %v", test.name, ext, err)
+			}
+		}
+	}
+
+	// the same archive extracts within the defaults
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "plugin.tar.gz")
+	writeTestTar(t, archive, []archiveEntry{{name: "zeros.bin", typeflag: tar.TypeReg, contents: strings.Repeat("\x00", 1<<10)}})
+	if err := Untar(archive, filepath.Join(dir, "out")); err != nil {
+		t.Errorf("Expected a small archive to extract within the default limits, but got %v", err)
+	}
+}
+
+func TestExtract_CleanupKeepsExisting(t *testing.T) {
+	dir := t.TempDir()
+	outputPath := filepath.Join(dir, "out")
+	if err := os.MkdirAll(filepath.Join(outputPath, "lib"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(outputPath, "lib", "keep.txt"), []byte("keep"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(outputPath, "plugin.wasm"), []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	archive := filepath.Join(dir, "plugin.tar.gz")
+	writeTestTar(t, archive, []archiveEntry{
+		{name: "plugin.wasm", typeflag: tar.TypeReg, contents: "new"},
+		{name: "lib/new.txt", typeflag: tar.TypeReg, contents: "new"},
+		{name: "docs/readme.txt", typeflag: tar.TypeReg, contents: "readme"},
+		{name: "large.txt", typeflag: tar.TypeReg, contents: strings.Repeat("x", 100)},
+	})
+
+	if err := UntarWithLimits(archive, outputPath, ExtractLimits{MaxFileSize: 10}); !errors.Is(err, ErrArchiveLimit) {
+		t.Fatalf("Expected ErrArchiveLimit, but got %v", err)
+	}
+
+	if data, err := os.ReadFile(filepath.Join(outputPath, "lib", "keep.txt")); err != nil || string(data) != "keep" {
+		t.Errorf("Expected the existing file to be kept, but got %q %v", data, err)
+	}
+
+	// overwritten in place, but it was there before so it is not removed
+	if _, err := os.Lstat(filepath.Join(outputPath, "plugin.wasm")); err != nil {
+		t.Errorf("Expected the overwritten file to be kept, but got %v", err)
+	}
+
+	for _, name := range []string{"lib/new.txt", "docs", "large.txt"} {
+		if _, err := os.Lstat(filepath.Join(outputPath, name)); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("Expected %s to be removed, but got %v", name, err)
+		}
+	}
+}
+
+func TestExtract_FailedReextraction(t *testing.T) {
+	engine := newTestEngine(t)
+	archive := filepath.Join(t.TempDir(), "acme.tar.gz")
+	writeTestTar(t, archive, []archiveEntry{
+		{name: "plugin.yaml", typeflag: tar.TypeReg, contents: "id: com.acme\nversion: 1.0.0\n"},
+		{name: "plugin.wasm", typeflag: tar.TypeReg, contents: "\x00asm"},
+	})
+
+	if err := engine.loadPluginArchive(archive, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	// an update that fails part way through, after writing the manifest and module
+	writeTestTar(t, archive, []archiveEntry{
+		{name: "plugin.yaml", typeflag: tar.TypeReg, contents: "id: com.acme\nversion: 2.0.0\n"},
+		{name: "plugin.wasm", typeflag: tar.TypeReg, contents: "\x00asm"},
+		{name: "large.txt", typeflag: tar.TypeReg, contents: strings.Repeat("x", 100)},
+	})
+
+	engine.extractLimits = ExtractLimits{MaxFileSize: 50}
+	if err := engine.loadPluginArchive(archive, ""); !errors.Is(err, ErrArchiveLimit) {
+		t.Fatalf("Expected ErrArchiveLimit, but got %v", err)
+	}
+
+	outputPath := filepath.Join(engine.pluginPath, "acme")
+	for name, contents := range map[string]string{
+		"plugin.yaml": "id: com.acme\nversion: 1.0.0\n",
+		"plugin.wasm": "\x00asm",
+	} {
+		if data, err := os.ReadFile(filepath.Join(outputPath, name)); err != nil || string(data) != contents {
+			t.Errorf("Expected %s of the running plugin to be left alone, but got %q %v", name, data, err)
+		}
+	}
+
+	entries, err := os.ReadDir(engine.pluginPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("Expected only the plugin's directory to be left, but got %d entries", len(entries))
+	}
+}
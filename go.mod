@@ -4,6 +4,8 @@ go 1.22.0
 
 require (
 	github.com/extism/go-sdk v1.5.0
+	github.com/gobwas/glob v0.2.3
+	github.com/santhosh-tekuri/jsonschema/v5 v5.3.1
 	github.com/spirefy/go-pdk v0.0.3
 	github.com/tetratelabs/wazero v1.8.1
 	gopkg.in/yaml.v3 v3.0.1
@@ -12,7 +14,6 @@ require (
 require (
 	github.com/dylibso/observe-sdk/go v0.0.0-20240828172851-9145d8ad07e1 // indirect
 	github.com/extism/go-pdk v1.0.6 // indirect
-	github.com/gobwas/glob v0.2.3 // indirect
 	github.com/ianlancetaylor/demangle v0.0.0-20240912202439-0a2b6291aafd // indirect
 	github.com/tetratelabs/wabin v0.0.0-20230304001439-f6f874872834 // indirect
 	go.opentelemetry.io/proto/otlp v1.3.1 // indirect
@@ -3,25 +3,130 @@ package pluginengine
 import (
 	"context"
 	"encoding/json"
-	"fmt"
-	extism "github.com/extism/go-sdk"
 	"io/fs"
 	"os"
 	"path/filepath"
+	"sync"
+
+	extism "github.com/extism/go-sdk"
+)
+
+type (
+	// callingPluginKey is the context key under which the call in to a plugin is stored, see withCallingPlugin.
+	callingPluginKey struct{}
+
+	// pluginCall is a call in to a plugin, which the host functions it calls back in to the engine find in their
+	// context.
+	pluginCall struct {
+		plugin *plugin
+		// the call the plugin was called from, through a host function, nil for a call made by the host
+		parent *pluginCall
+		mu     sync.Mutex
+		// the permission error of the last host function call that needed a capability, see LastError
+		err error
+	}
 )
 
+// withCallingPlugin
+//
+// Returns a context carrying the plugin a call is made in to. Extism passes the call context on to the host functions
+// the plugin calls, so the host functions can tell which plugin is calling them.
+func withCallingPlugin(ctx context.Context, p *plugin) context.Context {
+	return context.WithValue(ctx, callingPluginKey{}, &pluginCall{plugin: p, parent: currentCall(ctx)})
+}
+
+// currentCall
+//
+// Returns the call in to a plugin a host function is called from, nil if it is not known.
+func currentCall(ctx context.Context) *pluginCall {
+	call, _ := ctx.Value(callingPluginKey{}).(*pluginCall)
+	return call
+}
+
+// detachCall
+//
+// Returns a context for work a plugin call hands off, such as a queued event, that keeps ctx's values but is neither
+// cancelled with the call nor part of it, so the plugin calls the work makes do not count as calls back in to it.
+func detachCall(ctx context.Context) context.Context {
+	return context.WithValue(context.WithoutCancel(ctx), callingPluginKey{}, (*pluginCall)(nil))
+}
+
+// calls
+//
+// Returns true when the call, or one of the calls it was made from through the host functions, is in to the plugin.
+func (c *pluginCall) calls(p *plugin) bool {
+	for ; nil != c; c = c.parent {
+		if c.plugin == p {
+			return true
+		}
+	}
+
+	return false
+}
+
+// callingPlugin
+//
+// Returns the plugin calling a host function, nil if it is not known.
+func callingPlugin(ctx context.Context) *plugin {
+	if call := currentCall(ctx); nil != call {
+		return call.plugin
+	}
+
+	return nil
+}
+
+func (c *pluginCall) setError(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.err = err
+}
+
+func (c *pluginCall) lastError() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.err
+}
+
+// readablePath
+//
+// Returns the absolute path LoadFile reads for the path a plugin asks for, with its symlinks resolved so a capability
+// for a directory does not reach outside it through a symlink, in the / separated form fs.read patterns match.
+func readablePath(filePath string) string {
+	abs, err := filepath.Abs(filePath)
+	if nil != err {
+		return filePath
+	}
+
+	if resolved, err := filepath.EvalSymlinks(abs); nil == err {
+		abs = resolved
+	}
+
+	return filepath.ToSlash(abs)
+}
+
 func (e *Engine) LoadFile() extism.HostFunction {
 	ret := extism.NewHostFunctionWithStack(
 		"LoadFile",
 		func(ctx context.Context, p *extism.CurrentPlugin, stack []uint64) {
+			logger := e.hostFuncLogger(ctx, "LoadFile")
 			filePath, err2 := p.ReadString(stack[0])
 
 			if nil != err2 {
 				// TODO: Figure out how to handle this correctly
-				fmt.Println("ERROR CALLING FROM PLUGIN TO HOST getExtensionsForExtensionPoint FUNCTION: ", err2)
+				logger.Error("error reading file path", "error", err2)
 			}
 
-			fmt.Println("Plugin is calling LoadFile with path: ", filePath)
+			// the path is checked and read with its symlinks resolved, so a symlink can not lead outside the capability
+			filePath = readablePath(filePath)
+			if err := e.checkCapability(currentCall(ctx), CapabilityFSRead, filePath); nil != err {
+				logger.Warn("file read denied", "path", filePath, "error", err)
+				stack[0] = 0
+				return
+			}
+
+			logger.Debug("loading file", "path", filePath)
 			dir := filepath.Dir(filePath)
 			filename := filepath.Base(filePath)
 			fsys := os.DirFS(dir)
@@ -29,8 +134,7 @@ func (e *Engine) LoadFile() extism.HostFunction {
 			// Read file contents using fs.ReadFile
 			fileData, err := fs.ReadFile(fsys, filename)
 			if err != nil {
-				// TODO: LOG THIS ERROR SOMEHOW
-				fmt.Println("Problem reading file: ", err)
+				logger.Error("error reading file", "path", filePath, "error", err)
 			}
 
 			// write it back out to the calling plugin, so it can get it as a response to the host func call
@@ -38,7 +142,7 @@ func (e *Engine) LoadFile() extism.HostFunction {
 			stack[0] = ff
 
 			if err != nil {
-				fmt.Println("Error writing bytes: ", err)
+				logger.Error("error writing bytes", "error", err)
 			}
 		},
 		[]extism.ValueType{extism.ValueTypeI64}, []extism.ValueType{extism.ValueTypeI64},
@@ -52,34 +156,38 @@ func (e *Engine) CallExtension() extism.HostFunction {
 	ret := extism.NewHostFunctionWithStack(
 		"CallExtension",
 		func(ctx context.Context, p *extism.CurrentPlugin, stack []uint64) {
+			logger := e.hostFuncLogger(ctx, "CallExtension")
 			extId, err := p.ReadString(stack[0])
 
 			if nil != err {
-				fmt.Println("ERROR CALLING FROM PLUGIN TO HOST getExtensionsForExtensionPoint FUNCTION: ", err)
+				logger.Error("error reading extension id", "error", err)
 			}
 
-			fmt.Println("Calling CallExtension from plugin for extension id: ", extId)
+			if err := e.checkCapability(currentCall(ctx), CapabilityExtensionsCall, extId); nil != err {
+				logger.Warn("extension call denied", "extension", extId, "error", err)
+				stack[0] = 0
+				return
+			}
 
 			data, err := p.ReadBytes(stack[1])
 
 			if nil != err {
-				fmt.Println("ERROR READING BYTES OF INPUT DATA")
+				logger.Error("error reading input data", "extension", extId, "error", err)
 			}
 
-			if nil != data && len(data) > 0 {
-				fmt.Println("WE GOT DATA.. it should be passed on to the extension to be called")
-			}
+			logger.Debug("calling extension", "extension", extId, "size", len(data))
 
-			extResp, err := e.CallExtensionFunc(extId, data)
+			// ctx is the calling plugin's call context, so its deadline also limits the nested call
+			extResp, err := e.CallExtensionFuncWithContext(ctx, extId, data)
 			if nil != err {
-				fmt.Println("ERROR IN HOST FUNC: ", err)
+				logger.Error("error calling extension", "extension", extId, "error", err)
 			}
 
 			if nil != extResp {
 				ff, err := p.WriteBytes(extResp)
 
 				if err != nil {
-					fmt.Println("Error writing bytes: ", err)
+					logger.Error("error writing bytes", "extension", extId, "error", err)
 					return
 				} else {
 					stack[0] = ff
@@ -98,19 +206,24 @@ func (e *Engine) GetExtensions() extism.HostFunction {
 	ret := extism.NewHostFunctionWithStack(
 		"GetExtensions",
 		func(ctx context.Context, p *extism.CurrentPlugin, stack []uint64) {
+			logger := e.hostFuncLogger(ctx, "GetExtensions")
 			// Grab the extension point from memory/stack
 			extPtId, err := p.ReadString(stack[0])
 
 			if nil != err {
-				fmt.Println("ERROR CALLING FROM PLUGIN TO HOST getExtensions FUNCTION: ", err)
+				logger.Error("error reading extension point id", "error", err)
 			}
 
-			fmt.Println("Calling GetExtensions from plugin for extensionPoint id: ", extPtId)
+			if err := e.checkCapability(currentCall(ctx), CapabilityExtensionsList, extPtId); nil != err {
+				logger.Warn("listing extensions denied", "extensionPoint", extPtId, "error", err)
+				stack[0] = 0
+				return
+			}
 
 			extensions, err := e.GetExtensionsForExtensionPoint(extPtId, nil)
 
 			if nil != err {
-				fmt.Println("ERROR IN HOST FUNC: ", err)
+				logger.Warn("error getting extensions", "extensionPoint", extPtId, "error", err)
 			}
 
 			if nil != extensions && len(extensions) > 0 {
@@ -120,7 +233,7 @@ func (e *Engine) GetExtensions() extism.HostFunction {
 				ff, err := p.WriteBytes(jsonBytes)
 
 				if err != nil {
-					fmt.Println("Error writing bytes: ", err)
+					logger.Error("error writing bytes", "extensionPoint", extPtId, "error", err)
 					return
 				} else {
 					stack[0] = ff
@@ -137,6 +250,251 @@ func (e *Engine) GetExtensions() extism.HostFunction {
 	return ret
 }
 
-func (e Engine) GetHostFuncs() []extism.HostFunction {
-	return []extism.HostFunction{e.CallExtension(), e.LoadFile(), e.GetExtensions()}
+// SendEvent
+//
+// The host function plugins call to send an event (name, data) to every listener of the event, wasm and Go alike.
+func (e *Engine) SendEvent() extism.HostFunction {
+	ret := extism.NewHostFunctionWithStack(
+		"SendEvent",
+		func(ctx context.Context, p *extism.CurrentPlugin, stack []uint64) {
+			logger := e.hostFuncLogger(ctx, "SendEvent")
+			name, err := p.ReadString(stack[0])
+			if nil != err {
+				logger.Error("error reading event name", "error", err)
+				return
+			}
+
+			if err := e.checkCapability(currentCall(ctx), CapabilityEventsPublish, name); nil != err {
+				logger.Warn("sending event denied", "event", name, "error", err)
+				return
+			}
+
+			data, err := p.ReadBytes(stack[1])
+			if nil != err {
+				logger.Error("error reading event data", "event", name, "error", err)
+			}
+
+			if err := e.sendEvent(ctx, callingPlugin(ctx), name, data); nil != err {
+				logger.Error("error sending event", "event", name, "error", err)
+			}
+		},
+		[]extism.ValueType{extism.ValueTypeI64, extism.ValueTypeI64}, []extism.ValueType{},
+	)
+	ret.SetNamespace("extism:host/pluginengine")
+
+	return ret
+}
+
+// AddEventListener
+//
+// The host function plugins call to register one of their exported funcs (event, func) as a listener at runtime. The
+// listener is sent the retained events its pattern matches, and is removed when the plugin is unloaded.
+func (e *Engine) AddEventListener() extism.HostFunction {
+	ret := extism.NewHostFunctionWithStack(
+		"AddEventListener",
+		func(ctx context.Context, p *extism.CurrentPlugin, stack []uint64) {
+			logger := e.hostFuncLogger(ctx, "AddEventListener")
+			event, err := p.ReadString(stack[0])
+			if nil != err {
+				logger.Error("error reading event", "error", err)
+				return
+			}
+
+			fn, err := p.ReadString(stack[1])
+			if nil != err {
+				logger.Error("error reading listener func", "event", event, "error", err)
+				return
+			}
+
+			caller := callingPlugin(ctx)
+			if nil == caller {
+				logger.Warn("event listener added by an unknown plugin", "event", event, "func", fn)
+				return
+			}
+
+			if err := e.checkCapability(currentCall(ctx), CapabilityEventsSubscribe, event); nil != err {
+				logger.Warn("adding event listener denied", "event", event, "func", fn, "error", err)
+				return
+			}
+
+			l, err := newListener(event, caller, fn, nil)
+			if nil != err {
+				logger.Error("error adding event listener", "event", event, "func", fn, "error", err)
+				return
+			}
+
+			// a plugin that was unloaded while the call was running must not leave a listener behind
+			e.mu.Lock()
+			added := e.plugins[caller.Id][caller.Version] == caller && e.addListener(l)
+			e.mu.Unlock()
+
+			// a listener added from start gets the retained events once the plugin has started (see instantiate),
+			// one added later gets them right away, from another goroutine as the plugin is busy making this call
+			if added && caller.running() {
+				go e.deliverRetained(detachCall(ctx), []*listener{l})
+			}
+		},
+		[]extism.ValueType{extism.ValueTypeI64, extism.ValueTypeI64}, []extism.ValueType{},
+	)
+	ret.SetNamespace("extism:host/pluginengine")
+
+	return ret
+}
+
+// RemoveEventListener
+//
+// The host function plugins call to remove a listener (event, func) of theirs, whether it was registered at runtime or
+// declared in the manifest.
+func (e *Engine) RemoveEventListener() extism.HostFunction {
+	ret := extism.NewHostFunctionWithStack(
+		"RemoveEventListener",
+		func(ctx context.Context, p *extism.CurrentPlugin, stack []uint64) {
+			logger := e.hostFuncLogger(ctx, "RemoveEventListener")
+			event, err := p.ReadString(stack[0])
+			if nil != err {
+				logger.Error("error reading event", "error", err)
+				return
+			}
+
+			fn, err := p.ReadString(stack[1])
+			if nil != err {
+				logger.Error("error reading listener func", "event", event, "error", err)
+				return
+			}
+
+			caller := callingPlugin(ctx)
+			if nil == caller {
+				return
+			}
+
+			e.mu.Lock()
+			defer e.mu.Unlock()
+
+			e.removeListeners(func(l *listener) bool {
+				return l.plugin == caller && l.pattern.raw == event && l.fn == fn
+			})
+		},
+		[]extism.ValueType{extism.ValueTypeI64, extism.ValueTypeI64}, []extism.ValueType{},
+	)
+	ret.SetNamespace("extism:host/pluginengine")
+
+	return ret
+}
+
+// InvokeExtensions
+//
+// The host function plugins call to call every extension of an extension point (id, version constraint, data,
+// parallel) in one go, see Engine.InvokeExtensionPoint. A parallel value other than 0 calls the extensions
+// concurrently. It returns the json marshalled list of ExtensionResult, or 0 when the extension point is not loaded.
+func (e *Engine) InvokeExtensions() extism.HostFunction {
+	ret := extism.NewHostFunctionWithStack(
+		"InvokeExtensions",
+		func(ctx context.Context, p *extism.CurrentPlugin, stack []uint64) {
+			logger := e.hostFuncLogger(ctx, "InvokeExtensions")
+			epId, err := p.ReadString(stack[0])
+			if nil != err {
+				logger.Error("error reading extension point id", "error", err)
+				stack[0] = 0
+				return
+			}
+
+			version, err := p.ReadString(stack[1])
+			if nil != err {
+				logger.Error("error reading extension point version", "extensionPoint", epId, "error", err)
+				stack[0] = 0
+				return
+			}
+
+			if err := e.checkCapability(currentCall(ctx), CapabilityExtensionsInvoke, epId); nil != err {
+				logger.Warn("invoking extension point denied", "extensionPoint", epId, "error", err)
+				stack[0] = 0
+				return
+			}
+
+			data, err := p.ReadBytes(stack[2])
+			if nil != err {
+				logger.Error("error reading input data", "extensionPoint", epId, "error", err)
+			}
+
+			var options []InvokeOption
+			if stack[3] != 0 {
+				options = append(options, InParallel(0))
+			}
+
+			// ctx is the calling plugin's call context, so its deadline also limits the extension calls
+			results, err := e.InvokeExtensionPoint(ctx, epId, version, data, options...)
+			if nil != err {
+				logger.Warn("error invoking extension point", "extensionPoint", epId, "error", err)
+				stack[0] = 0
+				return
+			}
+
+			jsonBytes, err := json.Marshal(results)
+			if nil != err {
+				logger.Error("error marshalling extension results", "extensionPoint", epId, "error", err)
+				stack[0] = 0
+				return
+			}
+
+			ff, err := p.WriteBytes(jsonBytes)
+			if err != nil {
+				logger.Error("error writing bytes", "extensionPoint", epId, "error", err)
+				stack[0] = 0
+				return
+			}
+
+			stack[0] = ff
+		},
+		[]extism.ValueType{extism.ValueTypeI64, extism.ValueTypeI64, extism.ValueTypeI64, extism.ValueTypeI32},
+		[]extism.ValueType{extism.ValueTypeI64},
+	)
+	ret.SetNamespace("extism:host/pluginengine")
+
+	return ret
+}
+
+// LastError
+//
+// The host function plugins call after a host function call that returned nothing, to get the permission error of
+// that call (see ErrPermissionDenied). It returns the error message, or 0 when the last host function call that needs
+// a capability was allowed.
+func (e *Engine) LastError() extism.HostFunction {
+	ret := extism.NewHostFunctionWithStack(
+		"LastError",
+		func(ctx context.Context, p *extism.CurrentPlugin, stack []uint64) {
+			stack[0] = 0
+
+			call := currentCall(ctx)
+			if nil == call {
+				return
+			}
+
+			if err := call.lastError(); nil != err {
+				ff, writeErr := p.WriteString(err.Error())
+				if nil != writeErr {
+					e.hostFuncLogger(ctx, "LastError").Error("error writing bytes", "error", writeErr)
+					return
+				}
+
+				stack[0] = ff
+			}
+		},
+		[]extism.ValueType{}, []extism.ValueType{extism.ValueTypeI64},
+	)
+	ret.SetNamespace("extism:host/pluginengine")
+
+	return ret
+}
+
+func (e *Engine) GetHostFuncs() []extism.HostFunction {
+	return []extism.HostFunction{
+		e.CallExtension(),
+		e.LoadFile(),
+		e.GetExtensions(),
+		e.SendEvent(),
+		e.AddEventListener(),
+		e.RemoveEventListener(),
+		e.InvokeExtensions(),
+		e.LastError(),
+	}
 }
@@ -0,0 +1,117 @@
+package pluginengine
+
+import (
+	"sort"
+
+	gopdk "github.com/spirefy/go-pdk"
+)
+
+type (
+	// HostExtensionPointFunc is called with the current extensions of a host extension point every time extensions
+	// attach to or detach from it, see RegisterHostExtensionPoint.
+	HostExtensionPointFunc func(extensions []gopdk.Extension) error
+
+	// extensionPointNotification is a call of a host extension point func waiting to be made.
+	extensionPointNotification struct {
+		ep         *extensionPoint
+		extensions []gopdk.Extension
+	}
+)
+
+// attachedExtensions
+//
+// Returns the extensions of the extension point that calls are routed to, leaving out those shadowed by another
+// plugin's extension with the same id and those its cardinality does not accept. It must be called with the engine
+// lock held.
+func (e *Engine) attachedExtensions(ep *extensionPoint) []*extension {
+	exts := make([]*extension, 0, len(ep.Extensions))
+	for _, ext := range ep.Extensions {
+		if !e.shadowed(ext) && !ep.rejected[ext] {
+			exts = append(exts, ext)
+		}
+	}
+
+	return exts
+}
+
+// queueExtensionPointNotifications
+//
+// Queues a call of the func of every host extension point whose extensions changed since its func was last called.
+// The calls are made by notifyExtensionPoints once the engine lock is released. It must be called with the engine lock
+// held, resolve calls it last.
+func (e *Engine) queueExtensionPointNotifications() {
+	ids := make([]string, 0, len(e.extensionPoints))
+	for id := range e.extensionPoints {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		for _, ep := range e.extensionPoints[id] {
+			if nil == ep.Func {
+				continue
+			}
+
+			attached := e.attachedExtensions(ep)
+			if nil != ep.notified && sameExtensions(ep.notified, attached) {
+				continue
+			}
+
+			ep.notified = attached
+
+			extensions := make([]gopdk.Extension, 0, len(attached))
+			for _, ext := range attached {
+				extensions = append(extensions, ext.Extension)
+			}
+
+			e.notifications = append(e.notifications, extensionPointNotification{ep: ep, extensions: extensions})
+		}
+	}
+}
+
+// notifyExtensionPoints
+//
+// Calls the host extension point funcs queued by resolve, one at a time and in the order they were queued. It must be
+// called without the engine lock held, as the funcs may call back in to the engine. When a func causes further
+// notifications, or another goroutine is already making the calls, the pending calls are made by the goroutine that is
+// already at it.
+func (e *Engine) notifyExtensionPoints() {
+	e.mu.Lock()
+	if e.notifying {
+		e.mu.Unlock()
+		return
+	}
+
+	e.notifying = true
+	for len(e.notifications) > 0 {
+		n := e.notifications[0]
+		e.notifications = e.notifications[1:]
+		e.mu.Unlock()
+
+		if err := n.ep.Func(n.extensions); nil != err {
+			e.logger.Error("error from host extension point func", "extensionPoint", n.ep.Id, "extensionPointVersion", n.ep.Version, "error", err)
+		}
+
+		e.mu.Lock()
+	}
+
+	e.notifying = false
+	e.mu.Unlock()
+}
+
+// sameExtensions
+//
+// Returns true when both lists hold the same extensions in the same order.
+func sameExtensions(a, b []*extension) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,82 @@
+package pluginengine
+
+import (
+	"testing"
+
+	gopdk "github.com/spirefy/go-pdk"
+)
+
+func TestRegisterHostExtensionPoint_Func(t *testing.T) {
+	engine := newTestEngine(t)
+
+	calls := make([][]string, 0)
+	engine.RegisterHostExtensionPoint("com.acme.menu", "Menu", "1.0.0", "", func(extensions []gopdk.Extension) error {
+		ids := make([]string, 0, len(extensions))
+		for _, ext := range extensions {
+			ids = append(ids, ext.Id)
+		}
+		calls = append(calls, ids)
+		return nil
+	})
+
+	engine.addPlugin(&plugin{}, gopdk.Plugin{
+		Id:         "com.acme.editor",
+		Version:    "1.0.0",
+		Extensions: []gopdk.Extension{{Id: "com.acme.editor.open", ExtensionPoint: "com.acme.menu"}},
+	})
+
+	// a plugin that contributes nothing to the extension point does not cause a call
+	engine.addPlugin(&plugin{}, gopdk.Plugin{Id: "com.acme.other", Version: "1.0.0"})
+
+	engine.addPlugin(&plugin{}, gopdk.Plugin{
+		Id:         "com.acme.files",
+		Version:    "1.0.0",
+		Extensions: []gopdk.Extension{{Id: "com.acme.files.save", ExtensionPoint: "com.acme.menu"}},
+	})
+
+	if err := engine.UnloadPlugin("com.acme.editor", "1.0.0"); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := [][]string{{}, {"com.acme.editor.open"}, {"com.acme.editor.open", "com.acme.files.save"}, {"com.acme.files.save"}}
+	if len(calls) != len(expected) {
+		t.Fatalf("Expected %v, but got %v", expected, calls)
+	}
+
+	for i := range expected {
+		if len(calls[i]) != len(expected[i]) {
+			t.Fatalf("Expected %v, but got %v", expected, calls)
+		}
+
+		for j := range expected[i] {
+			if calls[i][j] != expected[i][j] {
+				t.Errorf("Expected %v, but got %v", expected, calls)
+			}
+		}
+	}
+}
+
+func TestRegisterHostExtensionPoint_FuncReentrant(t *testing.T) {
+	engine := newTestEngine(t)
+
+	calls := 0
+	engine.RegisterHostExtensionPoint("com.acme.menu", "Menu", "1.0.0", "", func(extensions []gopdk.Extension) error {
+		calls++
+		// calling back in to the engine from the func must not deadlock
+		_, _ = engine.GetExtensionsForExtensionPoint("com.acme.menu", nil)
+		if calls == 1 {
+			engine.RegisterHostExtensionPoint("com.acme.toolbar", "Toolbar", "1.0.0", "", nil)
+		}
+		return nil
+	})
+
+	engine.addPlugin(&plugin{}, gopdk.Plugin{
+		Id:         "com.acme.editor",
+		Version:    "1.0.0",
+		Extensions: []gopdk.Extension{{Id: "com.acme.editor.open", ExtensionPoint: "com.acme.menu"}},
+	})
+
+	if calls != 2 {
+		t.Errorf("Expected 2 calls, but got %d", calls)
+	}
+}
@@ -0,0 +1,213 @@
+package pluginengine
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	gopdk "github.com/spirefy/go-pdk"
+	"gopkg.in/yaml.v3"
+)
+
+type (
+	// Lockfile pins the exact builds of plugins by the SHA-256 digests, hex encoded, of their archives and .wasm
+	// modules. Archives are keyed on their file name and modules on the id and version of their plugin:
+	//
+	//	archives:
+	//	  editor.tar.gz: 9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08
+	//	modules:
+	//	  com.acme.editor@1.0.0: 60303ae22b998861bce3b28f33eec1be758a213c86c93c076dbe9f558c11c752
+	//
+	// What is not listed is not pinned. A plugin manifest can pin its own module with its hash field too.
+	Lockfile struct {
+		Archives map[string]string `json:"archives" yaml:"archives"`
+		Modules  map[string]string `json:"modules" yaml:"modules"`
+	}
+
+	// DigestMismatch is reported when a plugin archive or module does not have the SHA-256 digest it is pinned to. The
+	// archive is not loaded, or for a module only its plugin is not.
+	DigestMismatch struct {
+		Archive  string `json:"archive"`
+		Plugin   string `json:"plugin,omitempty"`
+		Version  string `json:"version,omitempty"`
+		Module   string `json:"module,omitempty"`
+		Expected string `json:"expected"`
+		Actual   string `json:"actual"`
+	}
+)
+
+// ErrDigestMismatch is wrapped by the error returned for a plugin archive or module whose SHA-256 digest is not the one
+// it is pinned to.
+var ErrDigestMismatch = errors.New("plugin digest does not match its pinned digest")
+
+// fileDigest
+//
+// Returns the SHA-256 digest of the file's contents.
+func fileDigest(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if nil != err {
+		return nil, err
+	}
+
+	defer func(f *os.File) {
+		_ = f.Close()
+	}(f)
+
+	return readerDigest(f)
+}
+
+// readerDigest
+//
+// Returns the SHA-256 digest of what is read from r.
+func readerDigest(r io.Reader) ([]byte, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); nil != err {
+		return nil, err
+	}
+
+	return h.Sum(nil), nil
+}
+
+// parseDigest
+//
+// Returns the hex encoded SHA-256 digest in lower case, with an optional sha256: prefix removed, or an error when it
+// is not one.
+func parseDigest(digest string) (string, error) {
+	d := strings.TrimPrefix(strings.ToLower(strings.TrimSpace(digest)), "sha256:")
+	if b, err := hex.DecodeString(d); nil != err || len(b) != sha256.Size {
+		return "", errors.New("invalid SHA-256 digest: " + digest)
+	}
+
+	return d, nil
+}
+
+// ReadLockfile
+//
+// Reads a yaml (or json) Lockfile, for WithLockfile.
+func ReadLockfile(path string) (Lockfile, error) {
+	lock := Lockfile{}
+
+	data, err := os.ReadFile(path)
+	if nil != err {
+		return lock, err
+	}
+
+	if err := yaml.Unmarshal(data, &lock); nil != err {
+		return lock, err
+	}
+
+	for _, pins := range []map[string]string{lock.Archives, lock.Modules} {
+		for name, digest := range pins {
+			if pins[name], err = parseDigest(digest); nil != err {
+				return lock, fmt.Errorf("lockfile %s pins %s: %w", path, name, err)
+			}
+		}
+	}
+
+	return lock, nil
+}
+
+// recordDigestMismatch
+//
+// Adds the mismatch to the ones GetResolutionReport returns.
+func (e *Engine) recordDigestMismatch(mismatch DigestMismatch) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.digestMismatches = append(e.digestMismatches, mismatch)
+}
+
+// clearDigestMismatches
+//
+// Forgets the mismatches of the archive, when it is loaded again.
+func (e *Engine) clearDigestMismatches(archive string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	kept := make([]DigestMismatch, 0, len(e.digestMismatches))
+	for _, mismatch := range e.digestMismatches {
+		if mismatch.Archive != archive {
+			kept = append(kept, mismatch)
+		}
+	}
+	e.digestMismatches = kept
+}
+
+// verifiesArchive
+//
+// Returns true when the archive's digest is needed before it is extracted, to verify its signature or to check it
+// against the lockfile.
+func (e *Engine) verifiesArchive(archive string) bool {
+	_, pinned := e.lockfile.Archives[filepath.Base(archive)]
+	return pinned || (e.signaturePolicy != SignatureOff && len(e.signaturePolicy) > 0)
+}
+
+// checkArchiveDigest
+//
+// Returns an error wrapping ErrDigestMismatch when the lockfile pins the archive to a different digest than the one
+// of its contents.
+func (e *Engine) checkArchiveDigest(archive string, digest []byte) error {
+	pin, ok := e.lockfile.Archives[filepath.Base(archive)]
+	if !ok {
+		return nil
+	}
+
+	expected, err := parseDigest(pin)
+	if nil != err {
+		return err
+	}
+
+	if actual := hex.EncodeToString(digest); actual != expected {
+		e.recordDigestMismatch(DigestMismatch{Archive: archive, Expected: expected, Actual: actual})
+		return fmt.Errorf("%w: %s is %s, pinned to %s", ErrDigestMismatch, archive, actual, expected)
+	}
+
+	return nil
+}
+
+// checkModuleDigest
+//
+// Checks the plugin's .wasm module against the digest its manifest (hash) and the lockfile pin it to. It returns the
+// module's digest when it is pinned, which is passed on to extism to verify the module again when it is instantiated,
+// and an error wrapping ErrDigestMismatch when it does not match.
+func (e *Engine) checkModuleDigest(archive string, p gopdk.Plugin, hash, module string) (string, error) {
+	pins := make([]string, 0, 2)
+	if len(hash) > 0 {
+		pinned, err := parseDigest(hash)
+		if nil != err {
+			return "", err
+		}
+		pins = append(pins, pinned)
+	}
+	if pin, ok := e.lockfile.Modules[p.Id+"@"+p.Version]; ok {
+		pinned, err := parseDigest(pin)
+		if nil != err {
+			return "", err
+		}
+		pins = append(pins, pinned)
+	}
+
+	if len(pins) == 0 {
+		return "", nil
+	}
+
+	digest, err := fileDigest(module)
+	if nil != err {
+		return "", err
+	}
+
+	actual := hex.EncodeToString(digest)
+	for _, expected := range pins {
+		if actual != expected {
+			e.recordDigestMismatch(DigestMismatch{Archive: archive, Plugin: p.Id, Version: p.Version, Module: module, Expected: expected, Actual: actual})
+			return "", fmt.Errorf("%w: module %s is %s, pinned to %s", ErrDigestMismatch, module, actual, expected)
+		}
+	}
+
+	return actual, nil
+}
@@ -0,0 +1,117 @@
+package pluginengine
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	extism "github.com/extism/go-sdk"
+)
+
+func sha256Hex(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestDigestPinning_Modules(t *testing.T) {
+	archive := filepath.Join(t.TempDir(), "plugins.tar.gz")
+	writeTestTar(t, archive, []archiveEntry{
+		{name: "a/plugin.yaml", typeflag: tar.TypeReg, contents: "id: com.acme.a\nversion: 1.0.0\nhash: " + sha256Hex("\x00asm-a") + "\n"},
+		{name: "a/plugin.wasm", typeflag: tar.TypeReg, contents: "\x00asm-a"},
+		{name: "b/plugin.yaml", typeflag: tar.TypeReg, contents: "id: com.acme.b\nversion: 1.0.0\n"},
+		{name: "b/plugin.wasm", typeflag: tar.TypeReg, contents: "\x00asm-b"},
+		{name: "c/plugin.yaml", typeflag: tar.TypeReg, contents: "id: com.acme.c\nversion: 1.0.0\n"},
+		{name: "c/plugin.wasm", typeflag: tar.TypeReg, contents: "\x00asm-c"},
+	})
+
+	lock := Lockfile{Modules: map[string]string{"com.acme.b@1.0.0": sha256Hex("something else")}}
+	engine, err := NewPluginEngine(nil, extism.LogLevelOff, t.TempDir(), WithLockfile(lock))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := engine.loadPluginArchive(archive, ""); err != nil {
+		t.Fatalf("Expected a module mismatch not to fail the archive, but got %v", err)
+	}
+
+	plugins := engine.GetPlugins()
+	if p := plugins["com.acme.a"]["1.0.0"]; nil == p || p.Hash != sha256Hex("\x00asm-a") {
+		t.Errorf("Expected the pinned plugin to be loaded with its hash, but got %+v", p)
+	}
+
+	if p := plugins["com.acme.c"]["1.0.0"]; nil == p || len(p.Hash) > 0 {
+		t.Errorf("Expected the plugin that is not pinned to be loaded without a hash, but got %+v", p)
+	}
+
+	if nil != plugins["com.acme.b"] {
+		t.Errorf("Expected the plugin whose module does not match to be left out")
+	}
+
+	mismatches := engine.GetResolutionReport().DigestMismatches
+	if len(mismatches) != 1 || mismatches[0].Plugin != "com.acme.b" || mismatches[0].Actual != sha256Hex("\x00asm-b") {
+		t.Errorf("Expected the mismatch of com.acme.b to be reported, but got %+v", mismatches)
+	}
+}
+
+func TestDigestPinning_Archive(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "plugin.tar.gz")
+	contents := createPluginArchive(t, "com.acme.pinned", "1.0.0")
+	if err := os.WriteFile(archive, contents, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, pin := range []string{sha256Hex("something else"), "SHA256:" + strings.ToUpper(sha256Hex(string(contents)))} {
+		engine, err := NewPluginEngine(nil, extism.LogLevelOff, t.TempDir(), WithLockfile(Lockfile{Archives: map[string]string{"plugin.tar.gz": pin}}))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = engine.loadPluginArchive(archive, "")
+		mismatches := engine.GetResolutionReport().DigestMismatches
+		loaded := nil != engine.GetPlugins()["com.acme.pinned"]
+
+		if pin == sha256Hex("something else") {
+			if !errors.Is(err, ErrDigestMismatch) || loaded || len(mismatches) != 1 || mismatches[0].Archive != archive {
+				t.Errorf("Expected the archive to be refused and reported, but got %v %v %+v", err, loaded, mismatches)
+			}
+			if _, err := os.Stat(filepath.Join(engine.pluginPath, "plugin")); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("Expected the archive not to be extracted, but got %v", err)
+			}
+		} else if err != nil || !loaded || len(mismatches) != 0 {
+			t.Errorf("Expected the archive matching its pin to load, but got %v %v %+v", err, loaded, mismatches)
+		}
+	}
+}
+
+func TestReadLockfile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "plugins.lock")
+	digest := sha256Hex("\x00asm")
+
+	if err := os.WriteFile(path, []byte("archives:\n  editor.tar.gz: sha256:"+strings.ToUpper(digest)+"\nmodules:\n  com.acme.editor@1.0.0: "+digest+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	lock, err := ReadLockfile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if lock.Archives["editor.tar.gz"] != digest || lock.Modules["com.acme.editor@1.0.0"] != digest {
+		t.Errorf("Expected the lockfile's digests in lower case hex, but got %+v", lock)
+	}
+
+	if err := os.WriteFile(path, []byte("modules:\n  com.acme.editor@1.0.0: abc\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ReadLockfile(path); err == nil {
+		t.Errorf("Expected an invalid digest to be refused")
+	}
+}
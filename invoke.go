@@ -0,0 +1,101 @@
+package pluginengine
+
+import (
+	"context"
+	"sync"
+)
+
+type (
+	// ExtensionResult is the outcome of calling one extension of an extension point, see InvokeExtensionPoint.
+	ExtensionResult struct {
+		ExtensionId string `json:"extensionId"`
+		Output      []byte `json:"output,omitempty"`
+		Err         error  `json:"-"`
+		// the text of Err, so the result can be marshalled for a plugin
+		Error string `json:"error,omitempty"`
+	}
+
+	// InvokeOption adjusts how InvokeExtensionPoint calls the extensions.
+	InvokeOption func(*invokeOptions)
+
+	invokeOptions struct {
+		parallel bool
+		limit    int
+	}
+)
+
+// InParallel
+//
+// Calls the extensions concurrently, at most limit at a time, or all at once when limit is below 1. The results are
+// still returned in the order of the extensions.
+func InParallel(limit int) InvokeOption {
+	return func(o *invokeOptions) {
+		o.parallel = true
+		o.limit = limit
+	}
+}
+
+// InvokeExtensionPoint
+//
+// Calls every extension of the extension point with the data and returns a result per extension, in the order
+// GetExtensionsForExtensionPoint returns them. versionConstraint selects the extension point versions the same way as
+// GetExtensionsForExtensionPoint (e.g. ^1.2), empty takes the first loaded version. The extensions are called one
+// after the other unless InParallel is given. An extension that fails does not stop the others, its error is in its
+// result. The returned error is only set when the extension point, or a version matching the constraint, is not
+// loaded or the constraint is invalid.
+func (e *Engine) InvokeExtensionPoint(ctx context.Context, epId, versionConstraint string, data []byte, options ...InvokeOption) ([]ExtensionResult, error) {
+	o := invokeOptions{}
+	for _, option := range options {
+		option(&o)
+	}
+
+	var versions []string
+	if len(versionConstraint) > 0 {
+		versions = []string{versionConstraint}
+	}
+
+	extensions, err := e.GetExtensionsForExtensionPoint(epId, versions)
+	if nil != err {
+		return nil, err
+	}
+
+	results := make([]ExtensionResult, len(extensions))
+	invoke := func(i int) {
+		out, err := e.CallExtensionFuncWithContext(ctx, extensions[i].Id, data)
+
+		results[i] = ExtensionResult{ExtensionId: extensions[i].Id, Output: out, Err: err}
+		if nil != err {
+			results[i].Error = err.Error()
+		}
+	}
+
+	if !o.parallel {
+		for i := range extensions {
+			invoke(i)
+		}
+
+		return results, nil
+	}
+
+	limit := o.limit
+	if limit < 1 || limit > len(extensions) {
+		limit = len(extensions)
+	}
+
+	slots := make(chan struct{}, max(limit, 1))
+	var wg sync.WaitGroup
+	for i := range extensions {
+		slots <- struct{}{}
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			invoke(i)
+		}(i)
+	}
+	wg.Wait()
+
+	return results, nil
+}
@@ -0,0 +1,83 @@
+package pluginengine
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	gopdk "github.com/spirefy/go-pdk"
+)
+
+func TestInvokeExtensionPoint(t *testing.T) {
+	engine := newTestEngine(t)
+	engine.RegisterHostExtensionPoint("com.acme.menu", "Menu", "1.0.0", "", nil)
+
+	var running, most int32
+	for _, id := range []string{"a", "b", "c"} {
+		id := id
+		native := &testNativePlugin{manifest: NativeManifest{
+			Plugin: gopdk.Plugin{
+				Id:         "com.acme." + id,
+				Version:    "1.0.0",
+				Extensions: []gopdk.Extension{{Id: "com.acme." + id + ".item", ExtensionPoint: "com.acme.menu", Func: "item"}},
+			},
+		}}
+		native.call = func(ctx context.Context, fn string, data []byte) ([]byte, error) {
+			n := atomic.AddInt32(&running, 1)
+			defer atomic.AddInt32(&running, -1)
+			for {
+				m := atomic.LoadInt32(&most)
+				if n <= m || atomic.CompareAndSwapInt32(&most, m, n) {
+					break
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+
+			if id == "b" {
+				return nil, errors.New("no menu item today")
+			}
+			return append([]byte(id+":"), data...), nil
+		}
+
+		if err := engine.RegisterNativePlugin(native); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, options := range [][]InvokeOption{nil, {InParallel(0)}} {
+		atomic.StoreInt32(&most, 0)
+
+		results, err := engine.InvokeExtensionPoint(context.Background(), "com.acme.menu", "^1.0", []byte("x"), options...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(results) != 3 {
+			t.Fatalf("Expected a result per extension, but got %d", len(results))
+		}
+
+		for _, r := range results {
+			if r.ExtensionId == "com.acme.b.item" {
+				if nil == r.Err || r.Error != "no menu item today" {
+					t.Errorf("Expected the failing extension's error in its result, but got %+v", r)
+				}
+			} else if nil != r.Err || string(r.Output) != r.ExtensionId[len("com.acme."):len("com.acme.")+1]+":x" {
+				t.Errorf("Expected the extension's output in its result, but got %+v", r)
+			}
+		}
+
+		if parallel := len(options) > 0; parallel != (atomic.LoadInt32(&most) > 1) {
+			t.Errorf("Expected parallel %v, but %d extensions ran at once", parallel, most)
+		}
+	}
+
+	if _, err := engine.InvokeExtensionPoint(context.Background(), "com.acme.missing", "", nil); err == nil {
+		t.Errorf("Expected an error invoking an extension point that is not loaded")
+	}
+
+	if _, err := engine.InvokeExtensionPoint(context.Background(), "com.acme.menu", "^2.0", nil); err == nil {
+		t.Errorf("Expected an error when no extension point version matches")
+	}
+}
@@ -0,0 +1,118 @@
+package pluginengine
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"sync"
+
+	extism "github.com/extism/go-sdk"
+)
+
+var (
+	// extismLogLevelMu guards extismLogLevel.
+	extismLogLevelMu sync.Mutex
+	// extismLogLevel is the level extism's process wide log level was last lowered to, see lowerExtismLogLevel.
+	extismLogLevel = extism.LogLevelOff
+)
+
+// LevelTrace is the slog level the engine logs extism's trace level at, below slog.LevelDebug.
+const LevelTrace = slog.LevelDebug - 4
+
+// levelHandler drops the records below the engine's log level before they reach the handler of the engine's logger.
+type levelHandler struct {
+	handler slog.Handler
+	level   slog.Level
+}
+
+func (h levelHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.level && h.handler.Enabled(ctx, level)
+}
+
+func (h levelHandler) Handle(ctx context.Context, record slog.Record) error {
+	return h.handler.Handle(ctx, record)
+}
+
+func (h levelHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return levelHandler{handler: h.handler.WithAttrs(attrs), level: h.level}
+}
+
+func (h levelHandler) WithGroup(name string) slog.Handler {
+	return levelHandler{handler: h.handler.WithGroup(name), level: h.level}
+}
+
+// slogLevel
+//
+// Returns the slog level of an extism log level. LogLevelOff is above every level, so nothing is logged.
+func slogLevel(level extism.LogLevel) slog.Level {
+	switch level {
+	case extism.LogLevelTrace:
+		return LevelTrace
+	case extism.LogLevelDebug:
+		return slog.LevelDebug
+	case extism.LogLevelWarn:
+		return slog.LevelWarn
+	case extism.LogLevelError:
+		return slog.LevelError
+	case extism.LogLevelOff:
+		return slog.Level(math.MaxInt32)
+	default:
+		return slog.LevelInfo
+	}
+}
+
+// newEngineLogger
+//
+// Returns the logger the engine logs to: logger, or slog's default logger when it is nil, with the records below the
+// engine's log level dropped.
+func newEngineLogger(logger *slog.Logger, level extism.LogLevel) *slog.Logger {
+	if nil == logger {
+		logger = slog.Default()
+	}
+
+	return slog.New(levelHandler{handler: logger.Handler(), level: slogLevel(level)})
+}
+
+// lowerExtismLogLevel
+//
+// extism drops what plugins log below its process wide level, which is off until it is set. Each engine filters what
+// its plugins log by its own level on the way to its logger, so the process wide level is only ever lowered to the
+// most verbose level an engine asks for, and an engine created with a higher level does not silence the others.
+func lowerExtismLogLevel(level extism.LogLevel) {
+	extismLogLevelMu.Lock()
+	defer extismLogLevelMu.Unlock()
+
+	if level < extismLogLevel {
+		extismLogLevel = level
+		extism.SetLogLevel(level)
+	}
+}
+
+// pluginLogger
+//
+// Returns the engine's logger with the plugin's id and version added to every record.
+func (e *Engine) pluginLogger(p *plugin) *slog.Logger {
+	return e.logger.With("plugin", p.Id, "version", p.Version)
+}
+
+// pluginLogFunc
+//
+// Returns the func extism calls with the log output of an instance of the plugin, which passes it on to the engine's
+// logger tagged with the plugin.
+func (e *Engine) pluginLogFunc(p *plugin) func(extism.LogLevel, string) {
+	logger := e.pluginLogger(p).With("source", "plugin")
+	return func(level extism.LogLevel, message string) {
+		logger.Log(e.context, slogLevel(level), message)
+	}
+}
+
+// hostFuncLogger
+//
+// Returns the engine's logger with the host function, and the plugin calling it if known, added to every record.
+func (e *Engine) hostFuncLogger(ctx context.Context, name string) *slog.Logger {
+	if caller := callingPlugin(ctx); nil != caller {
+		return e.pluginLogger(caller).With("hostFunc", name)
+	}
+
+	return e.logger.With("hostFunc", name)
+}
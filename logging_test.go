@@ -0,0 +1,166 @@
+package pluginengine
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	extism "github.com/extism/go-sdk"
+	gopdk "github.com/spirefy/go-pdk"
+)
+
+func newLoggingEngine(t *testing.T, level extism.LogLevel) (*Engine, *bytes.Buffer) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: LevelTrace}))
+
+	engine, err := NewPluginEngine(nil, level, t.TempDir(), WithLogger(logger))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return engine, &buf
+}
+
+func logRecords(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	records := make([]map[string]interface{}, 0)
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if len(line) == 0 {
+			continue
+		}
+
+		record := make(map[string]interface{})
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, record)
+	}
+
+	return records
+}
+
+func TestLogger_Structured(t *testing.T) {
+	engine, buf := newLoggingEngine(t, extism.LogLevelWarn)
+
+	engine.addPlugin(&plugin{Listeners: []eventListener{{Event: "window..*", Func: "onWindow"}}}, gopdk.Plugin{Id: "com.acme.editor", Version: "1.0.0"})
+
+	// debug records are below the engine's log level
+	_, _ = engine.GetExtensionsForExtensionPoint("com.acme.menu", nil)
+
+	records := logRecords(t, buf)
+	if len(records) != 1 {
+		t.Fatalf("Expected 1 log record, but got %v", records)
+	}
+
+	r := records[0]
+	if r["level"] != "WARN" || r["plugin"] != "com.acme.editor" || r["version"] != "1.0.0" || r["event"] != "window..*" || nil == r["error"] {
+		t.Errorf("Expected a warning with the plugin, event and error, but got %v", r)
+	}
+}
+
+func TestLogger_PluginOutput(t *testing.T) {
+	engine, buf := newLoggingEngine(t, extism.LogLevelInfo)
+
+	log := engine.pluginLogFunc(&plugin{Id: "com.acme.editor", Version: "1.0.0"})
+	log(extism.LogLevelDebug, "hidden")
+	log(extism.LogLevelError, "saving failed")
+
+	records := logRecords(t, buf)
+	if len(records) != 1 {
+		t.Fatalf("Expected 1 log record, but got %v", records)
+	}
+
+	r := records[0]
+	if r["level"] != "ERROR" || r["msg"] != "saving failed" || r["plugin"] != "com.acme.editor" || r["source"] != "plugin" {
+		t.Errorf("Expected the plugin's log output tagged with the plugin, but got %v", r)
+	}
+}
+
+// testLogWasmModule returns a minimal wasm module whose run export logs "saved" at the error level through the extism
+// kernel, the way the PDK logs, and whose start returns right away.
+func testLogWasmModule() []byte {
+	return []byte{
+		0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00, // magic and version
+		0x01, 0x13, 0x04, // type section
+		0x60, 0x00, 0x01, 0x7f, // () -> i32
+		0x60, 0x01, 0x7e, 0x01, 0x7e, // (i64) -> i64
+		0x60, 0x02, 0x7e, 0x7f, 0x00, // (i64, i32) -> ()
+		0x60, 0x01, 0x7e, 0x00, // (i64) -> ()
+		0x02, 0x50, 0x03, // import section: alloc, store_u8 and log_error of extism:host/env
+		0x0f, 'e', 'x', 't', 'i', 's', 'm', ':', 'h', 'o', 's', 't', '/', 'e', 'n', 'v',
+		0x05, 'a', 'l', 'l', 'o', 'c', 0x00, 0x01,
+		0x0f, 'e', 'x', 't', 'i', 's', 'm', ':', 'h', 'o', 's', 't', '/', 'e', 'n', 'v',
+		0x08, 's', 't', 'o', 'r', 'e', '_', 'u', '8', 0x00, 0x02,
+		0x0f, 'e', 'x', 't', 'i', 's', 'm', ':', 'h', 'o', 's', 't', '/', 'e', 'n', 'v',
+		0x09, 'l', 'o', 'g', '_', 'e', 'r', 'r', 'o', 'r', 0x00, 0x03,
+		0x03, 0x03, 0x02, 0x00, 0x00, // function section: start and run, () -> i32
+		0x07, 0x0f, 0x02, // export section: start and run
+		0x05, 's', 't', 'a', 'r', 't', 0x00, 0x03,
+		0x03, 'r', 'u', 'n', 0x00, 0x04,
+		0x0a, 0x49, 0x02, // code section
+		0x04, 0x00, 0x41, 0x00, 0x0b, // start: i32.const 0
+		0x42, 0x01, 0x01, 0x7e, // run, with one i64 local
+		0x42, 0x05, 0x10, 0x00, 0x21, 0x00, // local 0 = alloc(5)
+		0x20, 0x00, 0x42, 0x00, 0x7c, 0x41, 0xf3, 0x00, 0x10, 0x01, // store_u8(local 0 + 0, 's')
+		0x20, 0x00, 0x42, 0x01, 0x7c, 0x41, 0xe1, 0x00, 0x10, 0x01, // store_u8(local 0 + 1, 'a')
+		0x20, 0x00, 0x42, 0x02, 0x7c, 0x41, 0xf6, 0x00, 0x10, 0x01, // store_u8(local 0 + 2, 'v')
+		0x20, 0x00, 0x42, 0x03, 0x7c, 0x41, 0xe5, 0x00, 0x10, 0x01, // store_u8(local 0 + 3, 'e')
+		0x20, 0x00, 0x42, 0x04, 0x7c, 0x41, 0xe4, 0x00, 0x10, 0x01, // store_u8(local 0 + 4, 'd')
+		0x20, 0x00, 0x10, 0x02, 0x41, 0x00, 0x0b, // log_error(local 0), i32.const 0
+	}
+}
+
+func TestLogger_WasmPluginOutput(t *testing.T) {
+	engine, buf := newLoggingEngine(t, extism.LogLevelInfo)
+	// an engine created later with logging off does not silence the plugins of this one
+	_, quiet := newLoggingEngine(t, extism.LogLevelOff)
+
+	module := filepath.Join(t.TempDir(), "plugin.wasm")
+	if err := os.WriteFile(module, testLogWasmModule(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	engine.RegisterHostExtensionPoint("com.acme.menu", "Menu", "1.0.0", "", nil)
+	engine.addPlugin(&plugin{PathToModule: module}, gopdk.Plugin{
+		Id:         "com.acme.editor",
+		Version:    "1.0.0",
+		Extensions: []gopdk.Extension{{Id: "com.acme.editor.save", ExtensionPoint: "com.acme.menu", Func: "run"}},
+	})
+
+	// what the plugin logs goes through extism's own log level before it reaches the engine's logger
+	if _, err := engine.CallExtensionFunc("com.acme.editor.save", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	_ = engine.Shutdown(context.Background())
+
+	for _, r := range logRecords(t, buf) {
+		if r["source"] == "plugin" {
+			if r["level"] != "ERROR" || r["msg"] != "saved" || r["plugin"] != "com.acme.editor" {
+				t.Errorf("Expected the plugin's log output tagged with the plugin, but got %v", r)
+			}
+
+			if quiet.Len() != 0 {
+				t.Errorf("Expected nothing to be logged by the engine with LogLevelOff, but got %s", quiet.String())
+			}
+			return
+		}
+	}
+
+	t.Errorf("Expected the plugin's log output to reach the engine's logger, but got %s", buf.String())
+}
+
+func TestLogger_Off(t *testing.T) {
+	engine, buf := newLoggingEngine(t, extism.LogLevelOff)
+
+	engine.addPlugin(&plugin{Listeners: []eventListener{{Event: "window..*", Func: "onWindow"}}}, gopdk.Plugin{Id: "com.acme.editor", Version: "1.0.0"})
+	engine.pluginLogFunc(&plugin{Id: "com.acme.editor"})(extism.LogLevelError, "saving failed")
+
+	if buf.Len() != 0 {
+		t.Errorf("Expected nothing to be logged with LogLevelOff, but got %s", buf.String())
+	}
+}
@@ -0,0 +1,74 @@
+package pluginengine
+
+import (
+	"errors"
+	"time"
+)
+
+// pluginManifest holds the parts of a plugin's yaml manifest that are specific to this engine and not part of
+// gopdk.Plugin. It is unmarshalled from the same manifest file as the gopdk.Plugin.
+//
+//	dependencies:
+//	  - plugin: com.acme.core
+//	    version: ^1.0
+//	timeout: 5s
+//	poolSize: 8
+//	listeners:
+//	  - event: editor.selection.#
+//	    func: onSelection
+//	extensions:
+//	  - id: com.acme.editor.save
+//	    priority: 10
+//	    after: [com.acme.editor.open]
+//	extensionPoints:
+//	  - id: com.acme.editor.formatter
+//	    cardinality: single
+//	hash: 60303ae22b998861bce3b28f33eec1be758a213c86c93c076dbe9f558c11c752
+//	capabilities:
+//	  - fs.read:/data/**
+//	  - extensions.call:com.acme.*
+type pluginManifest struct {
+	Dependencies []dependency `yaml:"dependencies"`
+	// default time limit for calls in to the plugin, as a Go duration such as 500ms or 5s
+	Timeout string `yaml:"timeout"`
+	// how many instances of the plugin may run calls at once, each started with its own start call
+	PoolSize int `yaml:"poolSize"`
+	// the events the plugin listens to and the exported funcs they are delivered to
+	Listeners []eventListener `yaml:"listeners"`
+	// the same list of extensions as gopdk.Plugin, for their priority and before/after constraints
+	Extensions []extensionManifest `yaml:"extensions"`
+	// the same list of extension points as gopdk.Plugin, for their contracts
+	ExtensionPoints []extensionPointManifest `yaml:"extensionPoints"`
+	// the SHA-256 of the plugin's .wasm module, hex encoded, see checkModuleDigest
+	Hash string `yaml:"hash"`
+	// the host function capabilities the plugin requests, kind:pattern, see CapabilityKind
+	Capabilities []string `yaml:"capabilities"`
+}
+
+// ordering
+//
+// Returns the ordering of the manifest's extensions keyed on extension id.
+func (m pluginManifest) ordering() map[string]ExtensionOrder {
+	ordering := make(map[string]ExtensionOrder, len(m.Extensions))
+	for _, ext := range m.Extensions {
+		ordering[ext.Id] = ext.ExtensionOrder
+	}
+
+	return ordering
+}
+
+// timeout
+//
+// Returns the manifest's call timeout, 0 when none is set.
+func (m pluginManifest) timeout() (time.Duration, error) {
+	if len(m.Timeout) == 0 {
+		return 0, nil
+	}
+
+	d, err := time.ParseDuration(m.Timeout)
+	if nil != err || d < 0 {
+		return 0, errors.New("invalid plugin timeout: " + m.Timeout)
+	}
+
+	return d, nil
+}
@@ -0,0 +1,277 @@
+package pluginengine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	gopdk "github.com/spirefy/go-pdk"
+)
+
+type (
+	// NativePlugin is a plugin written in Go and compiled in to the host, for trusted contributions that do not need
+	// to be sandboxed in wasm. Registered with RegisterNativePlugin, it takes part in extension point resolution,
+	// extension call routing and the event bus exactly like a wasm plugin: Call is made with the Func of its extensions
+	// and of its event listeners (with the json marshalled Event), in place of the wasm exports of the same name.
+	//
+	// Start is called before the first call in to the plugin, or by Engine.Start when the manifest sets LoadOnStart,
+	// and Stop when the plugin is unloaded or the engine is shut down. Start is given the context of the call that
+	// needs the plugin, with its deadline, so work that outlives Start must not be tied to it. Unlike a wasm plugin,
+	// which gets an instance per concurrent call, a native plugin has a single instance, so Call must be safe for
+	// concurrent use. The ctx passed to Call carries the deadline of the call, and can be passed on to
+	// Engine.CallExtensionFuncWithContext and Engine.Publish so those calls and events are made as this plugin.
+	NativePlugin interface {
+		Manifest() NativeManifest
+		Start(ctx context.Context) error
+		Stop(ctx context.Context) error
+		Call(ctx context.Context, fn string, data []byte) ([]byte, error)
+	}
+
+	// NativeManifest is what a native plugin declares in place of a wasm plugin's plugin.yaml.
+	NativeManifest struct {
+		gopdk.Plugin
+		Dependencies []NativeDependency
+		Listeners    []NativeListener
+		// the priority and before/after constraints of the plugin's extensions, keyed on extension id
+		Ordering map[string]ExtensionOrder
+		// the contracts of the plugin's extension points, keyed on extension point id
+		Contracts map[string]ExtensionPointContract
+		// default time limit for calls in to the plugin when the caller's context has no deadline, 0 is no limit
+		Timeout time.Duration
+	}
+
+	// NativeDependency is a dependency of a native plugin on a plugin or an extension point, the same as the
+	// dependencies of a plugin.yaml.
+	NativeDependency struct {
+		Plugin         string
+		ExtensionPoint string
+		Version        string
+	}
+
+	// NativeListener is an event listener of a native plugin: Call is made with Func for each event the topic pattern
+	// Event matches.
+	NativeListener struct {
+		Event string
+		Func  string
+	}
+
+	// nativeState is where a native plugin is in its lifecycle.
+	nativeState int
+
+	// nativeInstance tracks whether a native plugin has been started. mu is never held while the plugin's Start or
+	// Stop runs, so the plugin can be asked whether it is running, e.g. by a call it makes from Start.
+	nativeInstance struct {
+		plugin NativePlugin
+		mu     sync.Mutex
+		state  nativeState
+		// closed when the Start or Stop in progress returns
+		changed chan struct{}
+	}
+)
+
+const (
+	nativeStopped nativeState = iota
+	nativeStarting
+	nativeStarted
+	nativeStopping
+	// nativeClosed is set by the stop of a plugin that was unloaded or shut down, it is never started again
+	nativeClosed
+)
+
+// settle
+//
+// Waits, with mu held, for a Start or Stop in progress to return. It returns with mu held, unless ctx is done first.
+func (n *nativeInstance) settle(ctx context.Context) error {
+	for n.state == nativeStarting || n.state == nativeStopping {
+		changed := n.changed
+		n.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		n.mu.Lock()
+	}
+
+	return nil
+}
+
+// transition
+//
+// Moves the plugin to the state a Start or Stop leads to, with mu held, returning a func that ends it in the final
+// state and wakes whoever waits on it.
+func (n *nativeInstance) transition(state nativeState) func(final nativeState) {
+	n.state = state
+	changed := make(chan struct{})
+	n.changed = changed
+
+	return func(final nativeState) {
+		n.mu.Lock()
+		n.state = final
+		n.mu.Unlock()
+		close(changed)
+	}
+}
+
+// start
+//
+// Calls the plugin's Start unless it is already started, returning true when it did so successfully.
+func (n *nativeInstance) start(ctx context.Context) (bool, error) {
+	n.mu.Lock()
+	if err := n.settle(ctx); nil != err {
+		return false, err
+	}
+
+	if n.state == nativeStarted {
+		n.mu.Unlock()
+		return false, nil
+	}
+
+	if n.state == nativeClosed {
+		n.mu.Unlock()
+		return false, errPluginUnloaded
+	}
+
+	done := n.transition(nativeStarting)
+	n.mu.Unlock()
+
+	if err := n.plugin.Start(ctx); nil != err {
+		done(nativeStopped)
+		return false, err
+	}
+
+	done(nativeStarted)
+	return true, nil
+}
+
+// stop
+//
+// Calls the plugin's Stop if it is started. When closed is true, which is used when the plugin is unloaded or the
+// engine shut down, the plugin ends up closed so a call that was routed to it before then can not start it again.
+func (n *nativeInstance) stop(ctx context.Context, closed bool) error {
+	n.mu.Lock()
+	if err := n.settle(ctx); nil != err {
+		return err
+	}
+
+	final := nativeStopped
+	if closed || n.state == nativeClosed {
+		final = nativeClosed
+	}
+
+	if n.state != nativeStarted {
+		n.state = final
+		n.mu.Unlock()
+		return nil
+	}
+
+	done := n.transition(nativeStopping)
+	n.mu.Unlock()
+
+	defer done(final)
+	return n.plugin.Stop(ctx)
+}
+
+// running
+//
+// Returns true when the plugin is started, not while its Start or Stop is running.
+func (n *nativeInstance) running() bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return n.state == nativeStarted
+}
+
+// running
+//
+// Returns true when the plugin is instantiated: a wasm plugin with at least one instance, or a started native plugin.
+func (p *plugin) running() bool {
+	if nil != p.native {
+		return p.native.running()
+	}
+
+	return p.instances.running()
+}
+
+// callNative
+//
+// Makes a call in to a native plugin, the native counterpart of the wasm call made by callPlugin.
+func (e *Engine) callNative(ctx context.Context, p *plugin, fn string, data []byte, target string) ([]byte, error) {
+	d, err := p.native.plugin.Call(withCallingPlugin(ctx, p), fn, data)
+	if nil != err && nil != ctx.Err() {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, fmt.Errorf("%w: %s", ErrCallTimeout, target)
+		}
+
+		return nil, fmt.Errorf("call to %s aborted: %w", target, ctx.Err())
+	}
+
+	return d, err
+}
+
+// RegisterNativePlugin
+//
+// This method registers a Go plugin with the engine. Its manifest's extensions and extension points are resolved with
+// those of the wasm plugins, its extensions are called through CallExtensionFunc and its listeners receive events,
+// the same as a wasm plugin's. A plugin with the same id and version replaces the one already loaded. If the engine
+// has been started and the manifest sets LoadOnStart, the plugin is started right away.
+func (e *Engine) RegisterNativePlugin(np NativePlugin) error {
+	if nil == np {
+		return errors.New("native plugin is nil")
+	}
+
+	manifest := np.Manifest()
+	if len(manifest.Id) == 0 || len(manifest.Version) == 0 {
+		return errors.New("native plugin manifest needs an id and a version")
+	}
+
+	if manifest.Timeout < 0 {
+		return errors.New("invalid plugin timeout: " + manifest.Timeout.String())
+	}
+
+	for id, contract := range manifest.Contracts {
+		if _, err := contract.compile(id); nil != err {
+			return err
+		}
+	}
+
+	p := &plugin{
+		Timeout:   manifest.Timeout,
+		Ordering:  manifest.Ordering,
+		Contracts: manifest.Contracts,
+		native:    &nativeInstance{plugin: np},
+	}
+
+	for _, dep := range manifest.Dependencies {
+		d := dependency{Plugin: dep.Plugin, ExtensionPoint: dep.ExtensionPoint, Version: dep.Version}
+		if err := d.validate(); nil != err {
+			return err
+		}
+
+		p.Dependencies = append(p.Dependencies, d)
+	}
+
+	for _, l := range manifest.Listeners {
+		if _, err := compileTopicPattern(l.Event); nil != err {
+			return err
+		}
+
+		p.Listeners = append(p.Listeners, eventListener{Event: l.Event, Func: l.Func})
+	}
+
+	e.addPlugin(p, manifest.Plugin)
+
+	e.mu.RLock()
+	started := e.started
+	e.mu.RUnlock()
+
+	if started {
+		e.startPlugins()
+	}
+
+	return nil
+}
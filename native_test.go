@@ -0,0 +1,336 @@
+package pluginengine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	gopdk "github.com/spirefy/go-pdk"
+)
+
+// testNativePlugin records the calls made in to it.
+type testNativePlugin struct {
+	manifest NativeManifest
+	mu       sync.Mutex
+	starts   int
+	stops    int
+	calls    []string
+	call     func(ctx context.Context, fn string, data []byte) ([]byte, error)
+	start    func(ctx context.Context) error
+	stop     func(ctx context.Context) error
+}
+
+func (n *testNativePlugin) Manifest() NativeManifest { return n.manifest }
+
+func (n *testNativePlugin) Start(ctx context.Context) error {
+	n.mu.Lock()
+	n.starts++
+	n.mu.Unlock()
+
+	if nil != n.start {
+		return n.start(ctx)
+	}
+
+	return nil
+}
+
+func (n *testNativePlugin) Stop(ctx context.Context) error {
+	n.mu.Lock()
+	n.stops++
+	n.mu.Unlock()
+
+	if nil != n.stop {
+		return n.stop(ctx)
+	}
+
+	return nil
+}
+
+func (n *testNativePlugin) Call(ctx context.Context, fn string, data []byte) ([]byte, error) {
+	n.mu.Lock()
+	n.calls = append(n.calls, fn)
+	n.mu.Unlock()
+
+	if nil != n.call {
+		return n.call(ctx, fn, data)
+	}
+
+	return append([]byte(fn+":"), data...), nil
+}
+
+func TestNativePlugin_Extensions(t *testing.T) {
+	engine := newTestEngine(t)
+	engine.RegisterHostExtensionPoint("com.acme.menu", "Menu", "1.0.0", "", nil)
+
+	native := &testNativePlugin{manifest: NativeManifest{
+		Plugin: gopdk.Plugin{
+			Id:         "com.acme.native",
+			Version:    "1.0.0",
+			Extensions: []gopdk.Extension{{Id: "com.acme.native.open", ExtensionPoint: "com.acme.menu", Func: "open"}},
+		},
+	}}
+
+	if err := engine.RegisterNativePlugin(native); err != nil {
+		t.Fatal(err)
+	}
+
+	if exts, _ := engine.GetExtensionsForExtensionPoint("com.acme.menu", nil); len(exts) != 1 || exts[0].Id != "com.acme.native.open" {
+		t.Fatalf("Expected the native plugin's extension to be attached to the extension point")
+	}
+
+	for i := 0; i < 2; i++ {
+		out, err := engine.CallExtensionFunc("com.acme.native.open", []byte("file.txt"))
+		if err != nil || string(out) != "open:file.txt" {
+			t.Errorf("Expected the native plugin to be called, but got %q %v", out, err)
+		}
+	}
+
+	if native.starts != 1 {
+		t.Errorf("Expected the native plugin to be started once, but got %d", native.starts)
+	}
+
+	if err := engine.UnloadPlugin("com.acme.native", "1.0.0"); err != nil {
+		t.Fatal(err)
+	}
+
+	if native.stops != 1 {
+		t.Errorf("Expected the native plugin to be stopped when unloaded, but got %d", native.stops)
+	}
+}
+
+func TestNativePlugin_DependenciesAndEvents(t *testing.T) {
+	engine := newTestEngine(t)
+	engine.RegisterHostExtensionPoint("com.acme.actions", "Actions", "1.0.0", "", nil)
+
+	received := make(chan Event, 1)
+	listener := &testNativePlugin{manifest: NativeManifest{
+		Plugin:       gopdk.Plugin{Id: "com.acme.status", Version: "1.0.0"},
+		Dependencies: []NativeDependency{{Plugin: "com.acme.editor", Version: "^1.0"}},
+		Listeners:    []NativeListener{{Event: "editor.selection.#", Func: "onSelection"}},
+	}}
+	listener.call = func(ctx context.Context, fn string, data []byte) ([]byte, error) {
+		event := Event{}
+		if err := json.Unmarshal(data, &event); err != nil {
+			return nil, err
+		}
+		received <- event
+		return nil, nil
+	}
+
+	if err := engine.RegisterNativePlugin(listener); err != nil {
+		t.Fatal(err)
+	}
+
+	if engine.GetPlugins()["com.acme.status"]["1.0.0"].Resolved {
+		t.Fatalf("Expected the native plugin to wait for its dependency")
+	}
+
+	editor := &testNativePlugin{manifest: NativeManifest{
+		Plugin: gopdk.Plugin{
+			Id:         "com.acme.editor",
+			Version:    "1.2.0",
+			Extensions: []gopdk.Extension{{Id: "com.acme.editor.select", ExtensionPoint: "com.acme.actions", Func: "select"}},
+		},
+	}}
+	// a native plugin publishing with the context of its call is the sender of the event
+	editor.call = func(ctx context.Context, fn string, data []byte) ([]byte, error) {
+		return nil, engine.Publish(ctx, "editor.selection.changed", data)
+	}
+
+	if err := engine.RegisterNativePlugin(editor); err != nil {
+		t.Fatal(err)
+	}
+
+	if !engine.GetPlugins()["com.acme.status"]["1.0.0"].Resolved {
+		t.Fatalf("Expected the native plugin to resolve once its dependency is loaded")
+	}
+
+	if _, err := engine.CallExtensionFunc("com.acme.editor.select", []byte(`"line 4"`)); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case event := <-received:
+		if event.Name != "editor.selection.changed" || event.Source != "com.acme.editor@1.2.0" || string(event.Data) != `"line 4"` {
+			t.Errorf("Expected the event from the editor, but got %+v", event)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Expected the native listener to receive the event")
+	}
+
+	// the status plugin is started for the event and its dependency before it
+	if err := engine.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if listener.stops != 1 || editor.stops != 1 {
+		t.Errorf("Expected both native plugins to be stopped by Shutdown")
+	}
+}
+
+func TestNativePlugin_EmptyDependency(t *testing.T) {
+	engine := newTestEngine(t)
+
+	np := &testNativePlugin{manifest: NativeManifest{
+		Plugin:       gopdk.Plugin{Id: "com.acme.status", Version: "1.0.0"},
+		Dependencies: []NativeDependency{{Version: "^1.0"}},
+	}}
+
+	if err := engine.RegisterNativePlugin(np); err == nil {
+		t.Errorf("Expected a dependency without a plugin or an extension point to be refused")
+	}
+
+	if nil != engine.GetPlugins()["com.acme.status"] {
+		t.Errorf("Expected the refused native plugin not to be registered")
+	}
+}
+
+func TestNativePlugin_Timeout(t *testing.T) {
+	engine := newTestEngine(t)
+	engine.RegisterHostExtensionPoint("com.acme.actions", "Actions", "1.0.0", "", nil)
+
+	slow := &testNativePlugin{manifest: NativeManifest{
+		Plugin: gopdk.Plugin{
+			Id:         "com.acme.slow",
+			Version:    "1.0.0",
+			Extensions: []gopdk.Extension{{Id: "com.acme.slow.run", ExtensionPoint: "com.acme.actions", Func: "run"}},
+		},
+		Timeout: 10 * time.Millisecond,
+	}}
+	slow.call = func(ctx context.Context, fn string, data []byte) ([]byte, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+
+	if err := engine.RegisterNativePlugin(slow); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := engine.CallExtensionFunc("com.acme.slow.run", nil); !errors.Is(err, ErrCallTimeout) {
+		t.Errorf("Expected the manifest timeout to apply to native calls, but got %v", err)
+	}
+
+	if err := engine.RegisterNativePlugin(&testNativePlugin{}); err == nil {
+		t.Errorf("Expected a native plugin without an id to be refused")
+	}
+}
+
+func TestNativePlugin_Reentrant(t *testing.T) {
+	engine := newTestEngine(t)
+	engine.RegisterHostExtensionPoint("com.acme.menu", "Menu", "1.0.0", "", nil)
+
+	// com.acme.a calls back in to itself from Start, directly and through com.acme.b, and from Stop
+	errs := make(chan error, 2)
+	stopErr := make(chan error, 1)
+	a := newExtensionPlugin("com.acme.a", "com.acme.a.run", nil, nil)
+	a.start = func(ctx context.Context) error {
+		_, err := engine.CallExtensionFuncWithContext(ctx, "com.acme.a.run", nil)
+		errs <- err
+		_, err = engine.CallExtensionFuncWithContext(ctx, "com.acme.b.run", nil)
+		errs <- err
+		return nil
+	}
+	a.stop = func(ctx context.Context) error {
+		_, err := engine.CallExtensionFuncWithContext(ctx, "com.acme.a.run", nil)
+		stopErr <- err
+		return nil
+	}
+
+	b := newExtensionPlugin("com.acme.b", "com.acme.b.run", nil, func(ctx context.Context, fn string, data []byte) ([]byte, error) {
+		return engine.CallExtensionFuncWithContext(ctx, "com.acme.a.run", nil)
+	})
+
+	for _, p := range []*testNativePlugin{a, b} {
+		if err := engine.RegisterNativePlugin(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := engine.CallExtensionFunc("com.acme.a.run", nil)
+		if nil == err {
+			err = engine.Shutdown(context.Background())
+		}
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected a native plugin calling back in to itself from Start and Stop not to deadlock")
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := <-errs; !errors.Is(err, ErrReentrantCall) {
+			t.Errorf("Expected ErrReentrantCall for a call back in to a starting plugin, but got %v", err)
+		}
+	}
+
+	if err := <-stopErr; err == nil {
+		t.Errorf("Expected an error for a call back in to a stopping plugin")
+	}
+}
+
+func TestNativePlugin_CallRacingUnload(t *testing.T) {
+	engine := newTestEngine(t)
+	engine.RegisterHostExtensionPoint("com.acme.menu", "Menu", "1.0.0", "", nil)
+
+	stopping := make(chan struct{})
+	release := make(chan struct{})
+	np := newExtensionPlugin("com.acme.racing", "com.acme.racing.run", nil, nil)
+	np.stop = func(ctx context.Context) error {
+		close(stopping)
+		<-release
+		return nil
+	}
+
+	if err := engine.RegisterNativePlugin(np); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := engine.CallExtensionFunc("com.acme.racing.run", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	p := engine.GetPlugins()["com.acme.racing"]["1.0.0"]
+
+	unloaded := make(chan error, 1)
+	go func() {
+		unloaded <- engine.UnloadPlugin("com.acme.racing", "1.0.0")
+	}()
+
+	// a call routed to the plugin before it was unloaded gets to start it while its Stop is still running
+	<-stopping
+	started := make(chan error, 1)
+	go func() {
+		started <- engine.instantiate(context.Background(), p)
+	}()
+
+	close(release)
+
+	if err := <-unloaded; err != nil {
+		t.Fatal(err)
+	}
+
+	if err := <-started; !errors.Is(err, errPluginUnloaded) {
+		t.Errorf("Expected a call racing the unload not to start the plugin again, but got %v", err)
+	}
+
+	if err := engine.instantiate(context.Background(), p); !errors.Is(err, errPluginUnloaded) {
+		t.Errorf("Expected an unloaded native plugin not to be started again, but got %v", err)
+	}
+
+	np.mu.Lock()
+	defer np.mu.Unlock()
+
+	if np.starts != 1 {
+		t.Errorf("Expected the native plugin to be started once, but it was started %d times", np.starts)
+	}
+}
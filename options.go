@@ -0,0 +1,129 @@
+package pluginengine
+
+import (
+	"crypto/ed25519"
+	"log/slog"
+	"time"
+)
+
+// EngineOption adjusts the defaults of an Engine created by NewPluginEngine.
+type EngineOption func(*Engine)
+
+// WithInstancePoolSize
+//
+// Sets how many instances of each plugin may run calls concurrently. A plugin manifest's poolSize overrides it for
+// that plugin. Sizes below 1 are treated as 1, which serializes all calls in to a plugin. Every instance is started
+// with its own call to the plugin's start export.
+func WithInstancePoolSize(size int) EngineOption {
+	return func(e *Engine) {
+		e.poolSize = size
+	}
+}
+
+// WithWatchInterval
+//
+// Sets how often Watch polls the watched directory, defaultWatchInterval unless set. An archive must also be unchanged
+// for one full interval before it is acted upon. An interval that is not positive is ignored.
+func WithWatchInterval(interval time.Duration) EngineOption {
+	return func(e *Engine) {
+		if interval > 0 {
+			e.watchInterval = interval
+		}
+	}
+}
+
+// WithEventWorkers
+//
+// Sets how many events of async and ordered topics are delivered at the same time. Sizes below 1 are treated as 1.
+func WithEventWorkers(workers int) EngineOption {
+	return func(e *Engine) {
+		e.events.workers = workers
+	}
+}
+
+// WithEventDelivery
+//
+// Sets the delivery config of topics that are not configured with ConfigureTopic. An invalid config is ignored and the
+// default, DeliverySync, is kept.
+func WithEventDelivery(config TopicConfig) EngineOption {
+	return func(e *Engine) {
+		if valid, err := config.validate(); nil == err {
+			e.events.defaults = valid
+		}
+	}
+}
+
+// WithPayloadValidation
+//
+// Checks the data of every extension call, and what the extension returns, against the input and output schemas of
+// its extension point's contract, see ExtensionPointContract. Calls to extension points without schemas are not
+// affected.
+func WithPayloadValidation() EngineOption {
+	return func(e *Engine) {
+		e.validatePayloads = true
+	}
+}
+
+// WithLogger
+//
+// Sets the logger the engine, and the plugins' own log output, log to. Records below the engine's log level are
+// dropped. The default is slog's default logger.
+func WithLogger(logger *slog.Logger) EngineOption {
+	return func(e *Engine) {
+		e.logger = logger
+	}
+}
+
+// WithExtractLimits
+//
+// Sets the limits on what extracting a plugin archive may write, see ExtractLimits. An archive that exceeds them is
+// not loaded and what was extracted of it is removed. The default is DefaultExtractLimits.
+func WithExtractLimits(limits ExtractLimits) EngineOption {
+	return func(e *Engine) {
+		e.extractLimits = limits
+	}
+}
+
+// WithSignaturePolicy
+//
+// Sets whether plugin archives must be signed by one of the trusted keys (see WithTrustedKeys) before they are
+// extracted. An archive's signature is the detached .sig file next to it, see SignArchive. The default is SignatureOff,
+// and an unknown policy is treated as SignatureRequire.
+func WithSignaturePolicy(policy SignaturePolicy) EngineOption {
+	return func(e *Engine) {
+		e.signaturePolicy = policy
+	}
+}
+
+// WithTrustedKeys
+//
+// Sets the ed25519 public keys plugin archive signatures are verified against, keyed on the name of the signer that is
+// recorded on the plugins loaded from an archive signed with the key.
+func WithTrustedKeys(keys map[string]ed25519.PublicKey) EngineOption {
+	return func(e *Engine) {
+		e.trustedKeys = keys
+	}
+}
+
+// WithLockfile
+//
+// Pins plugin archives and modules to the SHA-256 digests of the lockfile, see ReadLockfile. An archive that does not
+// match is not loaded, nor is a plugin whose module does not, and the mismatch is reported by GetResolutionReport.
+func WithLockfile(lock Lockfile) EngineOption {
+	return func(e *Engine) {
+		e.lockfile = lock
+	}
+}
+
+// WithCapabilityPolicy
+//
+// Sets the rules that grant, or deny, the host function capabilities plugins request in their manifest, see
+// CapabilityRule, and turns their enforcement on: a plugin can then only do through the host functions what the
+// capabilities it was granted allow, and nothing a deny rule matches. A requested capability is granted when a rule
+// grants it and none denies it, so with an empty policy every request is denied. Without this option host function
+// calls are not restricted, as before plugins requested capabilities.
+func WithCapabilityPolicy(rules ...CapabilityRule) EngineOption {
+	return func(e *Engine) {
+		e.capabilityPolicy = append([]CapabilityRule{}, rules...)
+	}
+}
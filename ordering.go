@@ -0,0 +1,193 @@
+package pluginengine
+
+import (
+	"sort"
+)
+
+type (
+	// ExtensionOrder places an extension among the other extensions of its extension point. It is declared next to the
+	// extension in the plugin manifest:
+	//
+	//	extensions:
+	//	  - id: com.acme.editor.save
+	//	    extensionPoint: com.acme.menu
+	//	    func: save
+	//	    priority: 10
+	//	    after: [com.acme.editor.open]
+	//	    before: [com.acme.editor.close]
+	ExtensionOrder struct {
+		// extensions with a higher priority come first, the default is 0
+		Priority int `json:"priority,omitempty" yaml:"priority"`
+		// ids of extensions this extension comes before, and after, when they are attached to the same extension point
+		Before []string `json:"before,omitempty" yaml:"before"`
+		After  []string `json:"after,omitempty" yaml:"after"`
+	}
+
+	// extensionManifest holds the parts of an extension in a plugin manifest that are specific to this engine and not
+	// part of gopdk.Extension.
+	extensionManifest struct {
+		Id             string `yaml:"id"`
+		ExtensionOrder `yaml:",inline"`
+	}
+
+	// OrderingCycle is reported when the before and after constraints of the extensions of an extension point
+	// contradict each other. The extensions are then ordered by priority and id only where the constraints conflict.
+	OrderingCycle struct {
+		ExtensionPoint string   `json:"extensionPoint"`
+		Version        string   `json:"version"`
+		Extensions     []string `json:"extensions"`
+	}
+)
+
+// sortExtensions
+//
+// Sorts extensions by id, then by plugin, so their order never depends on the order plugins were loaded in.
+func sortExtensions(exts []*extension) {
+	sort.SliceStable(exts, func(i, j int) bool {
+		if exts[i].Id != exts[j].Id {
+			return exts[i].Id < exts[j].Id
+		}
+
+		return pluginKey(exts[i].Plugin.Id, exts[i].Plugin.Version) < pluginKey(exts[j].Plugin.Id, exts[j].Plugin.Version)
+	})
+}
+
+// orderExtensions
+//
+// Returns the extensions in an order that respects their before and after constraints, taking the highest priority
+// extension whose constraints are met at each step, and the first in the given order of those with the same priority.
+// Constraints naming an extension that is not in the list are ignored. When the constraints form a cycle, the ids of
+// the extensions in the cycle are returned too, and the cycle is broken by taking the first extension that is left.
+func orderExtensions(exts []*extension) ([]*extension, []string) {
+	byId := make(map[string][]*extension)
+	for _, ext := range exts {
+		byId[ext.Id] = append(byId[ext.Id], ext)
+	}
+
+	// an edge a -> b means a comes before b
+	edges := make(map[*extension][]*extension)
+	indegree := make(map[*extension]int)
+	addEdge := func(a, b *extension) {
+		if a == b {
+			return
+		}
+
+		edges[a] = append(edges[a], b)
+		indegree[b]++
+	}
+
+	for _, ext := range exts {
+		for _, id := range ext.Order.Before {
+			for _, other := range byId[id] {
+				addEdge(ext, other)
+			}
+		}
+
+		for _, id := range ext.Order.After {
+			for _, other := range byId[id] {
+				addEdge(other, ext)
+			}
+		}
+	}
+
+	remaining := make([]*extension, len(exts))
+	copy(remaining, exts)
+	sort.SliceStable(remaining, func(i, j int) bool {
+		return remaining[i].Order.Priority > remaining[j].Order.Priority
+	})
+
+	ordered := make([]*extension, 0, len(exts))
+	var cycle []string
+	for len(remaining) > 0 {
+		next := -1
+		for i, ext := range remaining {
+			if indegree[ext] == 0 {
+				next = i
+				break
+			}
+		}
+
+		if next < 0 {
+			// every extension left waits on another, so they include a cycle
+			if nil == cycle {
+				cycle = cycleMembers(remaining, edges)
+			}
+			next = 0
+		}
+
+		ext := remaining[next]
+		remaining = append(remaining[:next], remaining[next+1:]...)
+		ordered = append(ordered, ext)
+
+		for _, other := range edges[ext] {
+			indegree[other]--
+		}
+	}
+
+	return ordered, cycle
+}
+
+// cycleMembers
+//
+// Returns the sorted ids of the extensions that can reach themselves through the edges, following only extensions in
+// the list.
+func cycleMembers(exts []*extension, edges map[*extension][]*extension) []string {
+	in := make(map[*extension]bool, len(exts))
+	for _, ext := range exts {
+		in[ext] = true
+	}
+
+	ids := make([]string, 0)
+	for _, start := range exts {
+		seen := make(map[*extension]bool)
+		stack := append([]*extension{}, edges[start]...)
+		for len(stack) > 0 {
+			ext := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+
+			if ext == start {
+				ids = append(ids, start.Id)
+				break
+			}
+
+			if seen[ext] || !in[ext] {
+				continue
+			}
+
+			seen[ext] = true
+			stack = append(stack, edges[ext]...)
+		}
+	}
+
+	sort.Strings(ids)
+	return ids
+}
+
+// orderExtensionPoints
+//
+// Orders the extensions attached to every extension point by id (see sortExtensions), then by priority and constraints
+// (see orderExtensions), and records the ordering cycles. It must be called with the engine lock held, resolve calls
+// it once the extensions are attached.
+func (e *Engine) orderExtensionPoints() {
+	cycles := make([]OrderingCycle, 0)
+	for _, eps := range e.extensionPoints {
+		for _, ep := range eps {
+			sortExtensions(ep.Extensions)
+			ordered, cycle := orderExtensions(ep.Extensions)
+			ep.Extensions = ordered
+
+			if len(cycle) > 0 {
+				cycles = append(cycles, OrderingCycle{ExtensionPoint: ep.Id, Version: ep.Version, Extensions: cycle})
+			}
+		}
+	}
+
+	sort.Slice(cycles, func(i, j int) bool {
+		if cycles[i].ExtensionPoint != cycles[j].ExtensionPoint {
+			return cycles[i].ExtensionPoint < cycles[j].ExtensionPoint
+		}
+		return cycles[i].Version < cycles[j].Version
+	})
+
+	e.orderingCycles = cycles
+}
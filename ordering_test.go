@@ -0,0 +1,168 @@
+package pluginengine
+
+import (
+	"slices"
+	"testing"
+
+	gopdk "github.com/spirefy/go-pdk"
+	"gopkg.in/yaml.v3"
+)
+
+func TestOrderExtensions(t *testing.T) {
+	type ext struct {
+		id    string
+		order ExtensionOrder
+	}
+
+	tests := []struct {
+		name     string
+		exts     []ext
+		expected []string
+		cycle    []string
+	}{
+		{
+			name:     "priority",
+			exts:     []ext{{"c", ExtensionOrder{}}, {"b", ExtensionOrder{Priority: 10}}, {"a", ExtensionOrder{Priority: -1}}},
+			expected: []string{"b", "c", "a"},
+		},
+		{
+			name:     "before and after",
+			exts:     []ext{{"a", ExtensionOrder{After: []string{"c"}}}, {"b", ExtensionOrder{Before: []string{"c"}}}, {"c", ExtensionOrder{Priority: 10}}},
+			expected: []string{"b", "c", "a"},
+		},
+		{
+			name:     "absent ids are ignored",
+			exts:     []ext{{"a", ExtensionOrder{After: []string{"missing"}}}, {"b", ExtensionOrder{Before: []string{"missing"}}}},
+			expected: []string{"a", "b"},
+		},
+		{
+			name:     "cycle",
+			exts:     []ext{{"a", ExtensionOrder{After: []string{"c"}}}, {"b", ExtensionOrder{After: []string{"a"}}}, {"c", ExtensionOrder{After: []string{"b"}}}, {"d", ExtensionOrder{}}},
+			expected: []string{"d", "a", "b", "c"},
+			cycle:    []string{"a", "b", "c"},
+		},
+	}
+
+	for _, test := range tests {
+		exts := make([]*extension, 0, len(test.exts))
+		for _, e := range test.exts {
+			exts = append(exts, &extension{Extension: gopdk.Extension{Id: e.id}, Order: e.order})
+		}
+
+		// the input order must not matter
+		for _, reverse := range []bool{false, true} {
+			input := make([]*extension, len(exts))
+			for i := range exts {
+				if reverse {
+					input[i] = exts[len(exts)-1-i]
+				} else {
+					input[i] = exts[i]
+				}
+			}
+
+			sortExtensions(input)
+			ordered, cycle := orderExtensions(input)
+
+			ids := make([]string, 0, len(ordered))
+			for _, o := range ordered {
+				ids = append(ids, o.Id)
+			}
+
+			if !slices.Equal(ids, test.expected) {
+				t.Errorf("Expected %v for %s, but got %v", test.expected, test.name, ids)
+			}
+
+			if !slices.Equal(cycle, test.cycle) {
+				t.Errorf("Expected cycle %v for %s, but got %v", test.cycle, test.name, cycle)
+			}
+		}
+	}
+}
+
+func TestGetExtensionsForExtensionPoint_Ordering(t *testing.T) {
+	engine := newTestEngine(t)
+	engine.RegisterHostExtensionPoint("com.acme.menu", "Menu", "1.0.0", "", nil)
+
+	engine.addPlugin(&plugin{Ordering: map[string]ExtensionOrder{
+		"com.acme.menu.close": {Priority: -10},
+		"com.acme.menu.save":  {After: []string{"com.acme.menu.open"}},
+	}}, gopdk.Plugin{
+		Id:      "com.acme.editor",
+		Version: "1.0.0",
+		Extensions: []gopdk.Extension{
+			{Id: "com.acme.menu.save", ExtensionPoint: "com.acme.menu"},
+			{Id: "com.acme.menu.close", ExtensionPoint: "com.acme.menu"},
+		},
+	})
+
+	engine.addPlugin(&plugin{Ordering: map[string]ExtensionOrder{
+		"com.acme.menu.open": {Priority: 5},
+		"com.acme.menu.new":  {Before: []string{"com.acme.menu.open"}},
+	}}, gopdk.Plugin{
+		Id:      "com.acme.files",
+		Version: "1.0.0",
+		Extensions: []gopdk.Extension{
+			{Id: "com.acme.menu.open", ExtensionPoint: "com.acme.menu"},
+			{Id: "com.acme.menu.new", ExtensionPoint: "com.acme.menu"},
+		},
+	})
+
+	exts, err := engine.GetExtensionsForExtensionPoint("com.acme.menu", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"com.acme.menu.new", "com.acme.menu.open", "com.acme.menu.save", "com.acme.menu.close"}
+	ids := make([]string, 0, len(exts))
+	for _, ext := range exts {
+		ids = append(ids, ext.Id)
+	}
+
+	if !slices.Equal(ids, expected) {
+		t.Errorf("Expected extensions in the order %v, but got %v", expected, ids)
+	}
+
+	if cycles := engine.GetResolutionReport().OrderingCycles; len(cycles) != 0 {
+		t.Errorf("Expected no ordering cycles, but got %+v", cycles)
+	}
+
+	engine.addPlugin(&plugin{Ordering: map[string]ExtensionOrder{
+		"com.acme.menu.print": {Before: []string{"com.acme.menu.new"}, After: []string{"com.acme.menu.save"}},
+	}}, gopdk.Plugin{
+		Id:         "com.acme.print",
+		Version:    "1.0.0",
+		Extensions: []gopdk.Extension{{Id: "com.acme.menu.print", ExtensionPoint: "com.acme.menu"}},
+	})
+
+	cycles := engine.GetResolutionReport().OrderingCycles
+	if len(cycles) != 1 || cycles[0].ExtensionPoint != "com.acme.menu" || len(cycles[0].Extensions) != 4 {
+		t.Fatalf("Expected an ordering cycle for com.acme.menu, but got %+v", cycles)
+	}
+
+	if exts, _ := engine.GetExtensionsForExtensionPoint("com.acme.menu", nil); len(exts) != 5 {
+		t.Errorf("Expected every extension to be returned despite the cycle, but got %d", len(exts))
+	}
+}
+
+func TestPluginManifest_Ordering(t *testing.T) {
+	manifest := `
+id: com.acme.editor
+version: 1.0.0
+extensions:
+  - id: com.acme.menu.save
+    extensionPoint: com.acme.menu
+    func: save
+    priority: 10
+    after: [com.acme.menu.open]
+`
+
+	m := pluginManifest{}
+	if err := yaml.Unmarshal([]byte(manifest), &m); err != nil {
+		t.Fatal(err)
+	}
+
+	order := m.ordering()["com.acme.menu.save"]
+	if order.Priority != 10 || len(order.After) != 1 || order.After[0] != "com.acme.menu.open" {
+		t.Errorf("Expected the extension's priority and constraints from the manifest, but got %+v", order)
+	}
+}
@@ -0,0 +1,229 @@
+package pluginengine
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	extism "github.com/extism/go-sdk"
+)
+
+// defaultInstancePoolSize is how many instances of a plugin may run calls at the same time, unless the engine is
+// created WithInstancePoolSize or the plugin manifest sets poolSize.
+const defaultInstancePoolSize = 4
+
+var (
+	// errPluginUnloaded is returned to calls that were routed to a plugin just before it was unloaded.
+	errPluginUnloaded = errors.New("plugin has been unloaded")
+	// errPoolFull is returned by tryAcquire when every instance of the plugin is in use.
+	errPoolFull = errors.New("all plugin instances are in use")
+)
+
+// instancePool
+//
+// A single extism.Plugin is not safe for concurrent calls, so each plugin keeps a pool of instances and every call
+// borrows one for its own use. Instances are created on demand, up to the pool size. Each instance has its own wasm
+// memory, so each one is started with its own start call and a plugin's start runs once per instance, not once per
+// plugin. Calls beyond the pool size wait for an instance to be returned, or for their context to be done.
+type instancePool struct {
+	mu       sync.Mutex
+	createMu sync.Mutex    // serializes creating the first instance, so start is only called once for it
+	slots    chan struct{} // one token per instance that is in use
+	all      []*extism.Plugin
+	idle     []*extism.Plugin
+	closed   bool
+}
+
+func newInstancePool(size int) *instancePool {
+	if size < 1 {
+		size = 1
+	}
+
+	return &instancePool{slots: make(chan struct{}, size)}
+}
+
+// running
+//
+// Returns true when the plugin has at least one instance.
+func (pool *instancePool) running() bool {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	return len(pool.all) > 0
+}
+
+// start
+//
+// Creates the first instance of the plugin if it has none, returning true when it did. Concurrent callers wait for
+// the first one to finish.
+func (pool *instancePool) start(create func() (*extism.Plugin, error)) (bool, error) {
+	pool.createMu.Lock()
+	defer pool.createMu.Unlock()
+
+	if pool.running() {
+		return false, nil
+	}
+
+	inst, err := create()
+	if nil != err {
+		return false, err
+	}
+
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	if pool.closed {
+		_ = inst.CloseWithContext(context.Background())
+		return false, errPluginUnloaded
+	}
+
+	pool.all = append(pool.all, inst)
+	pool.idle = append(pool.idle, inst)
+
+	return true, nil
+}
+
+// acquire
+//
+// Borrows an idle instance, creating a new one if none is idle and the pool is not full. It must be given back with
+// release, or discard if the instance can no longer be used.
+func (pool *instancePool) acquire(ctx context.Context, create func() (*extism.Plugin, error)) (*extism.Plugin, error) {
+	select {
+	case pool.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	return pool.take(create)
+}
+
+// tryAcquire
+//
+// Borrows an instance like acquire, but returns errPoolFull rather than wait when every instance is in use.
+func (pool *instancePool) tryAcquire(create func() (*extism.Plugin, error)) (*extism.Plugin, error) {
+	select {
+	case pool.slots <- struct{}{}:
+	default:
+		return nil, errPoolFull
+	}
+
+	return pool.take(create)
+}
+
+// take must be called holding one of the pool's slots, which is given back if no instance can be taken.
+func (pool *instancePool) take(create func() (*extism.Plugin, error)) (*extism.Plugin, error) {
+	pool.mu.Lock()
+	if pool.closed {
+		pool.mu.Unlock()
+		<-pool.slots
+		return nil, errPluginUnloaded
+	}
+
+	if n := len(pool.idle); n > 0 {
+		inst := pool.idle[n-1]
+		pool.idle = pool.idle[:n-1]
+		pool.mu.Unlock()
+		return inst, nil
+	}
+	pool.mu.Unlock()
+
+	inst, err := create()
+	if nil != err {
+		<-pool.slots
+		return nil, err
+	}
+
+	pool.mu.Lock()
+	pool.all = append(pool.all, inst)
+	pool.mu.Unlock()
+
+	return inst, nil
+}
+
+// contains must be called with mu held.
+func (pool *instancePool) contains(inst *extism.Plugin) bool {
+	for _, i := range pool.all {
+		if i == inst {
+			return true
+		}
+	}
+
+	return false
+}
+
+// release
+//
+// Gives a borrowed instance back to the pool. An instance that was drained while it was borrowed is not reused.
+func (pool *instancePool) release(inst *extism.Plugin) {
+	pool.mu.Lock()
+	if pool.contains(inst) {
+		pool.idle = append(pool.idle, inst)
+	}
+	pool.mu.Unlock()
+
+	<-pool.slots
+}
+
+// discard
+//
+// Removes a borrowed instance from the pool and closes it, e.g. after a call was aborted and its module closed.
+func (pool *instancePool) discard(ctx context.Context, inst *extism.Plugin) error {
+	pool.mu.Lock()
+	all := make([]*extism.Plugin, 0, len(pool.all))
+	for _, i := range pool.all {
+		if i != inst {
+			all = append(all, i)
+		}
+	}
+	pool.all = all
+	pool.mu.Unlock()
+
+	<-pool.slots
+
+	return inst.CloseWithContext(ctx)
+}
+
+// drain
+//
+// Takes every instance out of the pool so they can be stopped and closed. It waits for borrowed instances to be given
+// back until ctx is done, after which the remaining ones are taken anyway and returned as busy, apart from the idle
+// ones, as a call may still be running in them. A drained pool creates new instances on next use unless closed is
+// true, which is used when the plugin is unloaded or the engine shut down.
+func (pool *instancePool) drain(ctx context.Context, closed bool) (idle, busy []*extism.Plugin) {
+	acquired := 0
+
+wait:
+	for ; acquired < cap(pool.slots); acquired++ {
+		select {
+		case pool.slots <- struct{}{}:
+		case <-ctx.Done():
+			break wait
+		}
+	}
+
+	pool.mu.Lock()
+	idle = pool.idle
+	for _, inst := range pool.all {
+		borrowed := true
+		for _, i := range idle {
+			if i == inst {
+				borrowed = false
+				break
+			}
+		}
+
+		if borrowed {
+			busy = append(busy, inst)
+		}
+	}
+	pool.all = nil
+	pool.idle = nil
+	pool.closed = pool.closed || closed
+	pool.mu.Unlock()
+
+	for ; acquired > 0; acquired-- {
+		<-pool.slots
+	}
+
+	return idle, busy
+}
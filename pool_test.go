@@ -0,0 +1,320 @@
+package pluginengine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	extism "github.com/extism/go-sdk"
+	gopdk "github.com/spirefy/go-pdk"
+)
+
+func TestInstancePool_Concurrent(t *testing.T) {
+	pool := newInstancePool(3)
+
+	var mu sync.Mutex
+	created := 0
+	inUse := make(map[*extism.Plugin]bool)
+	maxInUse := 0
+
+	create := func() (*extism.Plugin, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		created++
+		return &extism.Plugin{}, nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < 10; j++ {
+				inst, err := pool.acquire(context.Background(), create)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+
+				mu.Lock()
+				if inUse[inst] {
+					t.Errorf("Expected an instance to only be used by one call at a time")
+				}
+				inUse[inst] = true
+				if len(inUse) > maxInUse {
+					maxInUse = len(inUse)
+				}
+				mu.Unlock()
+
+				time.Sleep(time.Millisecond)
+
+				mu.Lock()
+				delete(inUse, inst)
+				mu.Unlock()
+
+				pool.release(inst)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if created > 3 || maxInUse > 3 {
+		t.Errorf("Expected at most 3 instances, but created %d with %d in use at once", created, maxInUse)
+	}
+}
+
+func TestInstancePool_Wait(t *testing.T) {
+	pool := newInstancePool(1)
+	create := func() (*extism.Plugin, error) { return &extism.Plugin{}, nil }
+
+	inst, err := pool.acquire(context.Background(), create)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if _, err := pool.acquire(ctx, create); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected deadline exceeded waiting for a busy pool, but got %v", err)
+	}
+
+	pool.release(inst)
+
+	if again, err := pool.acquire(context.Background(), create); err != nil || again != inst {
+		t.Errorf("Expected the released instance to be reused")
+	}
+}
+
+func TestInstancePool_TryAcquire(t *testing.T) {
+	pool := newInstancePool(1)
+	create := func() (*extism.Plugin, error) { return &extism.Plugin{}, nil }
+
+	inst, err := pool.tryAcquire(create)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := pool.tryAcquire(create); !errors.Is(err, errPoolFull) {
+		t.Errorf("Expected errPoolFull rather than waiting for a busy pool, but got %v", err)
+	}
+
+	pool.release(inst)
+
+	if again, err := pool.tryAcquire(create); err != nil || again != inst {
+		t.Errorf("Expected the released instance to be reused")
+	}
+}
+
+func TestInstancePool_Reentrant(t *testing.T) {
+	engine, err := NewPluginEngine(nil, extism.LogLevelOff, t.TempDir(), WithInstancePoolSize(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine.RegisterHostExtensionPoint("com.acme.menu", "Menu", "1.0.0", "", nil)
+
+	p := &plugin{}
+	engine.addPlugin(p, gopdk.Plugin{
+		Id:         "com.acme.self",
+		Version:    "1.0.0",
+		Extensions: []gopdk.Extension{{Id: "com.acme.self.run", ExtensionPoint: "com.acme.menu", Func: "run"}},
+	})
+
+	create := func() (*extism.Plugin, error) { return &extism.Plugin{}, nil }
+	if _, err := p.instances.start(create); err != nil {
+		t.Fatal(err)
+	}
+
+	// the plugin's only instance is busy with a call that calls back in to the plugin, with no deadline
+	busy, err := p.instances.acquire(context.Background(), create)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.instances.release(busy)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := engine.CallExtensionFuncWithContext(withCallingPlugin(context.Background(), p), "com.acme.self.run", nil)
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrReentrantCall) {
+			t.Errorf("Expected ErrReentrantCall for a plugin calling back in to itself, but got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Expected a reentrant call to fail rather than wait for the busy instance")
+	}
+
+	// a call from the plugin's own call, handed off to run later, waits for an instance like any other call
+	ctx, cancel := context.WithTimeout(detachCall(withCallingPlugin(context.Background(), p)), 10*time.Millisecond)
+	defer cancel()
+
+	if _, err := engine.CallExtensionFuncWithContext(ctx, "com.acme.self.run", nil); !errors.Is(err, ErrCallTimeout) {
+		t.Errorf("Expected a detached call to wait for an instance, but got %v", err)
+	}
+}
+
+func TestInstancePool_Drain(t *testing.T) {
+	pool := newInstancePool(2)
+	create := func() (*extism.Plugin, error) { return &extism.Plugin{}, nil }
+
+	if _, err := pool.start(create); err != nil {
+		t.Fatal(err)
+	}
+
+	busy, _ := pool.acquire(context.Background(), create)
+	other, _ := pool.acquire(context.Background(), create)
+	pool.release(other)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	idle, borrowed := pool.drain(ctx, true)
+	if len(borrowed) != 1 || borrowed[0] != busy {
+		t.Errorf("Expected the busy instance to be drained as busy once the deadline passed, but got %d", len(borrowed))
+	}
+
+	if len(idle) != 1 || idle[0] != other {
+		t.Errorf("Expected the instance given back to be drained as idle, but got %d", len(idle))
+	}
+
+	// an instance given back after the pool was drained is not reused
+	pool.release(busy)
+
+	if pool.running() {
+		t.Errorf("Expected drained pool to have no instances")
+	}
+
+	if _, err := pool.acquire(context.Background(), create); !errors.Is(err, errPluginUnloaded) {
+		t.Errorf("Expected closed pool to refuse new calls, but got %v", err)
+	}
+}
+
+func TestEngine_ConcurrentRegistry(t *testing.T) {
+	engine := newTestEngine(t)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			ep := fmt.Sprintf("com.acme.concurrent.ep%d", i)
+			ext := fmt.Sprintf("com.acme.concurrent.ext%d", i)
+			id := fmt.Sprintf("com.acme.concurrent%d", i)
+
+			engine.RegisterHostExtensionPoint(ep, "Concurrent", "1.0.0", "", nil)
+			engine.addPlugin(&plugin{}, gopdk.Plugin{
+				Id:         id,
+				Version:    "1.0.0",
+				Extensions: []gopdk.Extension{{Id: ext, ExtensionPoint: ep}},
+			})
+
+			_, _ = engine.GetExtensionsForExtensionPoint(ep, []string{"^1.0"})
+			_ = engine.GetExtensionForId(ext)
+			_ = engine.GetResolutionReport()
+			_ = engine.GetPlugins()
+
+			if err := engine.UnloadPlugin(id, "1.0.0"); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if len(engine.GetPlugins()) != 0 {
+		t.Errorf("Expected all plugins to be unloaded")
+	}
+}
+
+func TestInstancePool_Wasm(t *testing.T) {
+	engine, err := NewPluginEngine(nil, extism.LogLevelOff, t.TempDir(), WithInstancePoolSize(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := addTestWasmPlugin(t, engine, "com.acme.wasm", 0)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			if _, err := engine.CallExtensionFunc("com.acme.wasm.run", []byte("data")); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	p.instances.mu.Lock()
+	created := len(p.instances.all)
+	p.instances.mu.Unlock()
+
+	if created < 1 || created > 2 {
+		t.Errorf("Expected concurrent calls to share at most 2 instances, but created %d", created)
+	}
+
+	// the stuck call is aborted at its deadline, and its closed instance replaced on the next call
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if _, err := engine.CallExtensionFuncWithContext(ctx, "com.acme.wasm.spin", nil); !errors.Is(err, ErrCallTimeout) {
+		t.Errorf("Expected ErrCallTimeout for a call that does not return, but got %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected the call to be aborted at its deadline, but it took %v", elapsed)
+	}
+
+	if _, err := engine.CallExtensionFunc("com.acme.wasm.run", nil); err != nil {
+		t.Errorf("Expected the plugin to be callable after an aborted call, but got %v", err)
+	}
+
+	if err := engine.Shutdown(context.Background()); err != nil {
+		t.Errorf("Expected the instances to be stopped and closed, but got %v", err)
+	}
+
+	if p.running() {
+		t.Errorf("Expected no instances to be left after Shutdown")
+	}
+}
+
+func TestInstancePool_ReentrantStart(t *testing.T) {
+	engine := newTestEngine(t)
+	p := addTestWasmPlugin(t, engine, "com.acme.wasm", 0)
+
+	// as if the plugin's start were running, which holds createMu until it returns
+	p.instances.createMu.Lock()
+	defer p.instances.createMu.Unlock()
+
+	// start calling back in to the plugin, directly and through another plugin
+	other := &plugin{}
+	for _, ctx := range []context.Context{
+		withCallingPlugin(context.Background(), p),
+		withCallingPlugin(withCallingPlugin(context.Background(), p), other),
+	} {
+		done := make(chan error, 1)
+		go func() {
+			_, err := engine.CallExtensionFuncWithContext(ctx, "com.acme.wasm.run", nil)
+			done <- err
+		}()
+
+		select {
+		case err := <-done:
+			if !errors.Is(err, ErrReentrantCall) {
+				t.Errorf("Expected ErrReentrantCall for a plugin calling back in to itself while starting, but got %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Expected a call back in to a starting plugin to fail rather than wait for its start")
+		}
+	}
+}
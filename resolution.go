@@ -0,0 +1,335 @@
+package pluginengine
+
+import (
+	"sort"
+	"strings"
+)
+
+type (
+	// ResolutionStatus is the overall state of a plugin in a ResolutionReport.
+	ResolutionStatus string
+
+	// RequirementProblem describes why a single requirement of a plugin is not satisfied.
+	RequirementProblem string
+
+	// UnmetRequirement is a dependency declared in a plugin manifest that could not be satisfied.
+	UnmetRequirement struct {
+		Plugin         string             `json:"plugin,omitempty"`
+		ExtensionPoint string             `json:"extensionPoint,omitempty"`
+		Version        string             `json:"version,omitempty"`
+		Problem        RequirementProblem `json:"problem"`
+		Reason         string             `json:"reason"`
+	}
+
+	// UnresolvedExtension is an extension contributed by a plugin whose extension point has not been loaded.
+	UnresolvedExtension struct {
+		Id             string `json:"id"`
+		ExtensionPoint string `json:"extensionPoint"`
+	}
+
+	// PluginResolution explains the resolution state of one plugin version.
+	PluginResolution struct {
+		Id                   string                `json:"id"`
+		Version              string                `json:"version"`
+		Status               ResolutionStatus      `json:"status"`
+		Reason               string                `json:"reason,omitempty"`
+		Missing              []UnmetRequirement    `json:"missing,omitempty"`
+		UnresolvedExtensions []UnresolvedExtension `json:"unresolvedExtensions,omitempty"`
+		// the plugins (id@version) of the dependency cycle this plugin is part of, if any
+		Cycle []string `json:"cycle,omitempty"`
+		// the capabilities the plugin requests that the engine's capability policy denies
+		DeniedCapabilities []string `json:"deniedCapabilities,omitempty"`
+	}
+
+	// ResolutionReport describes the resolution state of every plugin loaded by the engine, so a host application can
+	// explain to an operator why a plugin is not working.
+	ResolutionReport struct {
+		Plugins []PluginResolution `json:"plugins"`
+		// the extension ids contributed by more than one plugin, and which plugin calls are routed to
+		ExtensionConflicts []ExtensionConflict `json:"extensionConflicts,omitempty"`
+		// the extension points whose extensions' before/after constraints contradict each other
+		OrderingCycles []OrderingCycle `json:"orderingCycles,omitempty"`
+		// the extension points with more, or fewer, extensions than their cardinality accepts
+		CardinalityViolations []CardinalityViolation `json:"cardinalityViolations,omitempty"`
+		// the archives and modules whose digest is not the one they are pinned to, which are not loaded
+		DigestMismatches []DigestMismatch `json:"digestMismatches,omitempty"`
+	}
+)
+
+const (
+	// ResolutionResolved means all of the plugin's dependencies are satisfied and it can be instantiated.
+	ResolutionResolved ResolutionStatus = "resolved"
+	// ResolutionMissing means at least one dependency is not loaded, or is provided by an unresolved plugin.
+	ResolutionMissing ResolutionStatus = "missing"
+	// ResolutionConflict means a dependency is loaded, but not at a version the plugin's constraint accepts.
+	ResolutionConflict ResolutionStatus = "conflict"
+	// ResolutionCycle means the plugin is part of a dependency cycle and can never be resolved.
+	ResolutionCycle ResolutionStatus = "cycle"
+
+	// RequirementMissing means nothing with the required id is loaded.
+	RequirementMissing RequirementProblem = "missing"
+	// RequirementVersionConflict means the required id is loaded but no loaded version satisfies the constraint.
+	RequirementVersionConflict RequirementProblem = "versionConflict"
+	// RequirementUnresolvedProvider means matching versions are loaded but none of them are resolved.
+	RequirementUnresolvedProvider RequirementProblem = "unresolvedProvider"
+	// RequirementInvalidConstraint means the version constraint in the manifest could not be parsed.
+	RequirementInvalidConstraint RequirementProblem = "invalidConstraint"
+)
+
+// pluginKey
+//
+// Returns the id@version string used to identify a plugin version in reports and messages.
+func pluginKey(id, version string) string {
+	return id + "@" + version
+}
+
+// candidateProviders
+//
+// Returns every loaded plugin that could provide the dependency if it were resolved, ignoring resolution state. Used
+// to build the full dependency graph for cycle detection and to explain unmet requirements.
+func (e *Engine) candidateProviders(p *plugin, dep dependency) []*plugin {
+	candidates := make([]*plugin, 0)
+
+	if len(dep.ExtensionPoint) > 0 {
+		for _, ep := range e.extensionPoints[dep.ExtensionPoint] {
+			if len(ep.Plugin.Id) == 0 || (ep.Plugin.Id == p.Id && ep.Plugin.Version == p.Version) {
+				continue
+			}
+
+			if owner := e.plugins[ep.Plugin.Id][ep.Plugin.Version]; nil != owner && versionMatches(ep.Version, dep.Version) {
+				candidates = append(candidates, owner)
+			}
+		}
+
+		return candidates
+	}
+
+	for version, candidate := range e.plugins[dep.Plugin] {
+		if versionMatches(version, dep.Version) {
+			candidates = append(candidates, candidate)
+		}
+	}
+
+	return candidates
+}
+
+// dependencyCycles
+//
+// Finds the strongly connected components of the dependency graph of the unresolved plugins (Tarjan's algorithm) and
+// returns, for each plugin in a cycle, the sorted id@version list of the plugins in its cycle.
+func (e *Engine) dependencyCycles(all []*plugin) map[*plugin][]string {
+	cycles := make(map[*plugin][]string)
+	index := make(map[*plugin]int)
+	lowlink := make(map[*plugin]int)
+	onStack := make(map[*plugin]bool)
+	stack := make([]*plugin, 0)
+	next := 0
+
+	edges := func(p *plugin) []*plugin {
+		out := make([]*plugin, 0)
+		for _, dep := range p.Dependencies {
+			for _, c := range e.candidateProviders(p, dep) {
+				if !c.Resolved {
+					out = append(out, c)
+				}
+			}
+		}
+		return out
+	}
+
+	var connect func(p *plugin)
+	connect = func(p *plugin) {
+		index[p] = next
+		lowlink[p] = next
+		next++
+		stack = append(stack, p)
+		onStack[p] = true
+
+		selfLoop := false
+		for _, c := range edges(p) {
+			if c == p {
+				selfLoop = true
+			}
+
+			if _, seen := index[c]; !seen {
+				connect(c)
+				lowlink[p] = min(lowlink[p], lowlink[c])
+			} else if onStack[c] {
+				lowlink[p] = min(lowlink[p], index[c])
+			}
+		}
+
+		if lowlink[p] != index[p] {
+			return
+		}
+
+		component := make([]*plugin, 0)
+		for {
+			top := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			onStack[top] = false
+			component = append(component, top)
+
+			if top == p {
+				break
+			}
+		}
+
+		if len(component) > 1 || selfLoop {
+			keys := make([]string, 0, len(component))
+			for _, c := range component {
+				keys = append(keys, pluginKey(c.Id, c.Version))
+			}
+			sort.Strings(keys)
+
+			for _, c := range component {
+				cycles[c] = keys
+			}
+		}
+	}
+
+	for _, p := range all {
+		if _, seen := index[p]; !seen && !p.Resolved {
+			connect(p)
+		}
+	}
+
+	return cycles
+}
+
+// explainRequirement
+//
+// Works out why a dependency of plugin p is not satisfied.
+func (e *Engine) explainRequirement(p *plugin, dep dependency) UnmetRequirement {
+	req := UnmetRequirement{
+		Plugin:         dep.Plugin,
+		ExtensionPoint: dep.ExtensionPoint,
+		Version:        dep.Version,
+	}
+
+	if len(dep.Version) > 0 {
+		if _, err := constraintFromVersions([]string{dep.Version}); nil != err {
+			req.Problem = RequirementInvalidConstraint
+			req.Reason = err.Error()
+			return req
+		}
+	}
+
+	loaded := make([]string, 0)
+	if len(dep.ExtensionPoint) > 0 {
+		for _, ep := range e.extensionPoints[dep.ExtensionPoint] {
+			loaded = append(loaded, ep.Version)
+		}
+	} else {
+		for version := range e.plugins[dep.Plugin] {
+			loaded = append(loaded, version)
+		}
+	}
+	sort.Strings(loaded)
+
+	candidates := e.candidateProviders(p, dep)
+
+	switch {
+	case len(loaded) == 0:
+		req.Problem = RequirementMissing
+		req.Reason = dep.String() + " is not loaded"
+	case len(candidates) == 0:
+		req.Problem = RequirementVersionConflict
+		req.Reason = dep.String() + " is required but only " + strings.Join(loaded, ", ") + " is loaded"
+
+		// name the other plugins whose requirements the loaded versions do satisfy, that is the conflict
+		others := make([]string, 0)
+		for _, other := range e.sortedPlugins() {
+			if other == p {
+				continue
+			}
+
+			for _, od := range other.Dependencies {
+				if od.Plugin == dep.Plugin && od.ExtensionPoint == dep.ExtensionPoint && len(e.candidateProviders(other, od)) > 0 {
+					others = append(others, pluginKey(other.Id, other.Version)+" requires "+od.Version)
+				}
+			}
+		}
+
+		if len(others) > 0 {
+			req.Reason += " (" + strings.Join(others, "; ") + ")"
+		}
+	default:
+		keys := make([]string, 0)
+		for _, c := range candidates {
+			keys = append(keys, pluginKey(c.Id, c.Version))
+		}
+		sort.Strings(keys)
+
+		req.Problem = RequirementUnresolvedProvider
+		req.Reason = dep.String() + " is provided by unresolved " + strings.Join(keys, ", ")
+	}
+
+	return req
+}
+
+// GetResolutionReport
+//
+// Returns the resolution state of every loaded plugin: whether it is resolved, which of its requirements are unmet and
+// why (not loaded, version conflict, provided by an unresolved plugin, or part of a dependency cycle), and which of its
+// extensions are waiting on an extension point that has not been loaded.
+func (e *Engine) GetResolutionReport() ResolutionReport {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	all := e.sortedPlugins()
+	cycles := e.dependencyCycles(all)
+	report := ResolutionReport{Plugins: make([]PluginResolution, 0, len(all))}
+
+	for _, p := range all {
+		r := PluginResolution{
+			Id:                 p.Id,
+			Version:            p.Version,
+			Status:             ResolutionResolved,
+			DeniedCapabilities: p.deniedCapabilities,
+		}
+
+		for _, ext := range e.unresolved {
+			if ext.Plugin.Id == p.Id && ext.Plugin.Version == p.Version {
+				r.UnresolvedExtensions = append(r.UnresolvedExtensions, UnresolvedExtension{
+					Id:             ext.Id,
+					ExtensionPoint: ext.ExtensionPoint,
+				})
+			}
+		}
+
+		if !p.Resolved {
+			r.Status = ResolutionMissing
+
+			for _, dep := range p.Dependencies {
+				if _, ok := e.dependencyProviders(p, dep); ok {
+					continue
+				}
+
+				req := e.explainRequirement(p, dep)
+				if req.Problem == RequirementVersionConflict || req.Problem == RequirementInvalidConstraint {
+					r.Status = ResolutionConflict
+				}
+
+				r.Missing = append(r.Missing, req)
+			}
+
+			if cycle, ok := cycles[p]; ok {
+				r.Status = ResolutionCycle
+				r.Cycle = cycle
+				r.Reason = "dependency cycle between " + strings.Join(cycle, ", ")
+			} else if len(r.Missing) > 0 {
+				r.Reason = r.Missing[0].Reason
+			}
+		}
+
+		report.Plugins = append(report.Plugins, r)
+	}
+
+	report.ExtensionConflicts = append(report.ExtensionConflicts, e.extensionConflicts...)
+	report.OrderingCycles = append(report.OrderingCycles, e.orderingCycles...)
+	report.CardinalityViolations = append(report.CardinalityViolations, e.cardinalityViolations...)
+	report.DigestMismatches = append(report.DigestMismatches, e.digestMismatches...)
+
+	return report
+}
@@ -0,0 +1,75 @@
+package pluginengine
+
+import (
+	"testing"
+
+	gopdk "github.com/spirefy/go-pdk"
+)
+
+func findResolution(t *testing.T, report ResolutionReport, id string) PluginResolution {
+	for _, r := range report.Plugins {
+		if r.Id == id {
+			return r
+		}
+	}
+
+	t.Fatalf("Expected %s in resolution report", id)
+	return PluginResolution{}
+}
+
+func TestGetResolutionReport(t *testing.T) {
+	engine := newTestEngine(t)
+
+	addTestPlugin(engine, "com.acme.core", "1.3.0", nil)
+	addTestPlugin(engine, "com.acme.old", "1.0.0", []dependency{{Plugin: "com.acme.core", Version: "^1.0"}})
+	addTestPlugin(engine, "com.acme.new", "1.0.0", []dependency{{Plugin: "com.acme.core", Version: "^2.0"}})
+	addTestPlugin(engine, "com.acme.lonely", "1.0.0", []dependency{{ExtensionPoint: "com.acme.nowhere"}})
+	addTestPlugin(engine, "com.acme.a", "1.0.0", []dependency{{Plugin: "com.acme.b"}})
+	addTestPlugin(engine, "com.acme.b", "1.0.0", []dependency{{Plugin: "com.acme.a"}})
+	addTestPlugin(engine, "com.acme.c", "1.0.0", []dependency{{Plugin: "com.acme.a"}})
+	addTestPlugin(engine, "com.acme.bad", "1.0.0", []dependency{{Plugin: "com.acme.core", Version: "^^1"}})
+
+	engine.addPlugin(&plugin{}, gopdk.Plugin{
+		Id:         "com.acme.menuitems",
+		Version:    "1.0.0",
+		Extensions: []gopdk.Extension{{Id: "com.acme.menuitems.report", ExtensionPoint: "com.acme.report.menu"}},
+	})
+
+	report := engine.GetResolutionReport()
+
+	if r := findResolution(t, report, "com.acme.old"); r.Status != ResolutionResolved || len(r.Missing) != 0 {
+		t.Errorf("Expected com.acme.old to be resolved, but got %v", r)
+	}
+
+	r := findResolution(t, report, "com.acme.new")
+	if r.Status != ResolutionConflict || len(r.Missing) != 1 || r.Missing[0].Problem != RequirementVersionConflict {
+		t.Errorf("Expected com.acme.new to have a version conflict, but got %v", r)
+	}
+
+	r = findResolution(t, report, "com.acme.lonely")
+	if r.Status != ResolutionMissing || len(r.Missing) != 1 || r.Missing[0].Problem != RequirementMissing {
+		t.Errorf("Expected com.acme.lonely to have a missing extension point, but got %v", r)
+	}
+
+	for _, id := range []string{"com.acme.a", "com.acme.b"} {
+		r = findResolution(t, report, id)
+		if r.Status != ResolutionCycle || len(r.Cycle) != 2 {
+			t.Errorf("Expected %s to be in a dependency cycle, but got %v", id, r)
+		}
+	}
+
+	r = findResolution(t, report, "com.acme.c")
+	if r.Status != ResolutionMissing || len(r.Missing) != 1 || r.Missing[0].Problem != RequirementUnresolvedProvider {
+		t.Errorf("Expected com.acme.c to depend on an unresolved provider, but got %v", r)
+	}
+
+	r = findResolution(t, report, "com.acme.bad")
+	if r.Status != ResolutionConflict || len(r.Missing) != 1 || r.Missing[0].Problem != RequirementInvalidConstraint {
+		t.Errorf("Expected com.acme.bad to have an invalid constraint, but got %v", r)
+	}
+
+	r = findResolution(t, report, "com.acme.menuitems")
+	if len(r.UnresolvedExtensions) != 1 || r.UnresolvedExtensions[0].ExtensionPoint != "com.acme.report.menu" {
+		t.Errorf("Expected com.acme.menuitems to report its unresolved extension, but got %v", r)
+	}
+}
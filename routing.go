@@ -0,0 +1,114 @@
+package pluginengine
+
+import (
+	"sort"
+)
+
+// ExtensionConflict is reported when more than one loaded plugin contributes an extension with the same id. Calls to
+// the extension id are routed to Winner only, the others are shadowed until Winner is unloaded.
+type ExtensionConflict struct {
+	ExtensionId string `json:"extensionId"`
+	// the plugin (id@version) the extension id is routed to
+	Winner string `json:"winner"`
+	// the other plugins (id@version) contributing the same extension id
+	Shadowed []string `json:"shadowed"`
+}
+
+// extensionCandidate is one plugin's contribution of an extension id.
+type extensionCandidate struct {
+	ext    *extension
+	plugin *plugin
+}
+
+// takesPrecedence
+//
+// The deterministic precedence policy for an extension id contributed by more than one plugin: a resolved extension
+// of a resolved plugin wins over one that can not be called, then the highest plugin version wins, and finally the
+// lowest plugin id, so the outcome never depends on load order.
+func (c extensionCandidate) takesPrecedence(o extensionCandidate) bool {
+	cCallable := c.ext.Resolved && c.plugin.Resolved
+	oCallable := o.ext.Resolved && o.plugin.Resolved
+	if cCallable != oCallable {
+		return cCallable
+	}
+
+	if c.plugin.Version != o.plugin.Version {
+		cv, cErr := parseSemver(c.plugin.Version)
+		ov, oErr := parseSemver(o.plugin.Version)
+
+		switch {
+		case nil == cErr && nil == oErr:
+			if cmp := cv.compare(ov); cmp != 0 {
+				return cmp > 0
+			}
+		case nil == cErr:
+			return true
+		case nil == oErr:
+			return false
+		default:
+			return c.plugin.Version > o.plugin.Version
+		}
+	}
+
+	return c.plugin.Id < o.plugin.Id
+}
+
+// shadowed
+//
+// Returns true when calls to the extension's id are routed to another plugin's extension. It must be called with the
+// engine lock held.
+func (e *Engine) shadowed(ext *extension) bool {
+	winner, ok := e.extensions[ext.Id]
+	return ok && winner != ext
+}
+
+// routeExtensions
+//
+// Rebuilds the engine's extension routing: for every extension id, which plugin callableExtensions points to and which
+// extension GetExtensionForId returns. An id contributed by more than one plugin is routed by the takesPrecedence
+// policy and recorded as an ExtensionConflict. It must be called with the engine lock held, after resolvePlugins.
+func (e *Engine) routeExtensions() {
+	candidates := make(map[string][]extensionCandidate)
+	for _, p := range e.sortedPlugins() {
+		for _, ext := range p.extensions {
+			candidates[ext.Id] = append(candidates[ext.Id], extensionCandidate{ext: ext, plugin: p})
+		}
+	}
+
+	callable := make(map[string]*plugin, len(candidates))
+	extensions := make(map[string]*extension, len(candidates))
+	conflicts := make([]ExtensionConflict, 0)
+
+	for id, cs := range candidates {
+		sort.SliceStable(cs, func(i, j int) bool {
+			return cs[i].takesPrecedence(cs[j])
+		})
+
+		winner := cs[0]
+		callable[id] = winner.plugin
+		if winner.ext.Resolved {
+			extensions[id] = winner.ext
+		}
+
+		if len(cs) > 1 {
+			conflict := ExtensionConflict{
+				ExtensionId: id,
+				Winner:      pluginKey(winner.plugin.Id, winner.plugin.Version),
+			}
+
+			for _, shadowed := range cs[1:] {
+				conflict.Shadowed = append(conflict.Shadowed, pluginKey(shadowed.plugin.Id, shadowed.plugin.Version))
+			}
+
+			conflicts = append(conflicts, conflict)
+		}
+	}
+
+	sort.Slice(conflicts, func(i, j int) bool {
+		return conflicts[i].ExtensionId < conflicts[j].ExtensionId
+	})
+
+	e.callableExtensions = callable
+	e.extensions = extensions
+	e.extensionConflicts = conflicts
+}
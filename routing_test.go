@@ -0,0 +1,102 @@
+package pluginengine
+
+import (
+	"testing"
+
+	gopdk "github.com/spirefy/go-pdk"
+)
+
+func TestRouteExtensions_EngineIsolation(t *testing.T) {
+	first := newTestEngine(t)
+	second := newTestEngine(t)
+
+	for _, engine := range []*Engine{first, second} {
+		engine.RegisterHostExtensionPoint("com.acme.menu", "Menu", "1.0.0", "", nil)
+	}
+
+	a := &plugin{}
+	first.addPlugin(a, gopdk.Plugin{
+		Id:         "com.acme.a",
+		Version:    "1.0.0",
+		Extensions: []gopdk.Extension{{Id: "com.acme.menu.open", ExtensionPoint: "com.acme.menu"}},
+	})
+
+	b := &plugin{}
+	second.addPlugin(b, gopdk.Plugin{
+		Id:         "com.acme.b",
+		Version:    "1.0.0",
+		Extensions: []gopdk.Extension{{Id: "com.acme.menu.open", ExtensionPoint: "com.acme.menu"}},
+	})
+
+	if first.callableExtensions["com.acme.menu.open"] != a || second.callableExtensions["com.acme.menu.open"] != b {
+		t.Errorf("Expected each engine to route the extension id to its own plugin")
+	}
+
+	if err := second.UnloadPlugin("com.acme.b", "1.0.0"); err != nil {
+		t.Fatal(err)
+	}
+
+	if first.callableExtensions["com.acme.menu.open"] != a || nil == first.GetExtensionForId("com.acme.menu.open") {
+		t.Errorf("Expected unloading a plugin from one engine to leave the other engine's routing alone")
+	}
+
+	if len(first.GetResolutionReport().ExtensionConflicts) != 0 {
+		t.Errorf("Expected no extension conflicts across engines")
+	}
+}
+
+func TestRouteExtensions_Precedence(t *testing.T) {
+	engine := newTestEngine(t)
+	engine.RegisterHostExtensionPoint("com.acme.menu", "Menu", "1.0.0", "", nil)
+
+	add := func(id, version string) *plugin {
+		p := &plugin{}
+		engine.addPlugin(p, gopdk.Plugin{
+			Id:         id,
+			Version:    version,
+			Extensions: []gopdk.Extension{{Id: "com.acme.menu.open", ExtensionPoint: "com.acme.menu"}},
+		})
+		return p
+	}
+
+	newer := add("com.acme.b", "2.0.0")
+	add("com.acme.a", "1.0.0")
+	add("com.acme.c", "1.10.0")
+
+	if engine.callableExtensions["com.acme.menu.open"] != newer {
+		t.Errorf("Expected the highest plugin version to win regardless of load order")
+	}
+
+	conflicts := engine.GetResolutionReport().ExtensionConflicts
+	if len(conflicts) != 1 {
+		t.Fatalf("Expected 1 extension conflict, but got %d", len(conflicts))
+	}
+
+	if conflicts[0].Winner != "com.acme.b@2.0.0" || len(conflicts[0].Shadowed) != 2 || conflicts[0].Shadowed[0] != "com.acme.c@1.10.0" {
+		t.Errorf("Expected com.acme.b@2.0.0 to shadow the others, but got %+v", conflicts[0])
+	}
+
+	if exts, _ := engine.GetExtensionsForExtensionPoint("com.acme.menu", []string{"^1.0"}); len(exts) != 1 {
+		t.Errorf("Expected shadowed extensions to be left out of the extension point, but got %d", len(exts))
+	}
+
+	if err := engine.UnloadPlugin("com.acme.b", "2.0.0"); err != nil {
+		t.Fatal(err)
+	}
+
+	if winner := engine.callableExtensions["com.acme.menu.open"]; nil == winner || winner.Id != "com.acme.c" {
+		t.Errorf("Expected the next highest version to take over once the winner is unloaded")
+	}
+
+	// a resolved plugin wins over a higher version that is not resolved
+	blocked := &plugin{Dependencies: []dependency{{Plugin: "com.acme.missing"}}}
+	engine.addPlugin(blocked, gopdk.Plugin{
+		Id:         "com.acme.d",
+		Version:    "9.0.0",
+		Extensions: []gopdk.Extension{{Id: "com.acme.menu.open", ExtensionPoint: "com.acme.menu"}},
+	})
+
+	if winner := engine.callableExtensions["com.acme.menu.open"]; nil == winner || winner.Id != "com.acme.c" {
+		t.Errorf("Expected an unresolved plugin not to shadow a resolved one")
+	}
+}
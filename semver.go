@@ -0,0 +1,456 @@
+package pluginengine
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+type (
+	// semver is a parsed Semantic Versioning 2.0.0 version. Build metadata is kept for display only, it takes no part
+	// in precedence.
+	semver struct {
+		Major      uint64
+		Minor      uint64
+		Patch      uint64
+		Prerelease []string
+		Build      string
+	}
+
+	versionComparator struct {
+		op      string
+		version *semver
+	}
+
+	// versionConstraint is a set of comparator groups. A version satisfies the constraint when it satisfies every
+	// comparator of at least one group (groups are separated by || in the expression).
+	versionConstraint [][]versionComparator
+)
+
+// parseSemver
+//
+// Parses a full MAJOR.MINOR.PATCH version with optional -prerelease and +build parts, e.g. 1.4.0-rc.1+20240101.
+func parseSemver(version string) (*semver, error) {
+	v := &semver{}
+	rest := version
+
+	if i := strings.IndexByte(rest, '+'); i >= 0 {
+		v.Build = rest[i+1:]
+		rest = rest[:i]
+
+		if !validIdentifiers(v.Build, false) {
+			return nil, errors.New("invalid build metadata in version: " + version)
+		}
+	}
+
+	if i := strings.IndexByte(rest, '-'); i >= 0 {
+		pre := rest[i+1:]
+		rest = rest[:i]
+
+		if !validIdentifiers(pre, true) {
+			return nil, errors.New("invalid pre-release in version: " + version)
+		}
+
+		v.Prerelease = strings.Split(pre, ".")
+	}
+
+	parts := strings.Split(rest, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("version must have major, minor and patch numbers: " + version)
+	}
+
+	nums := []*uint64{&v.Major, &v.Minor, &v.Patch}
+	for i, part := range parts {
+		n, err := parseVersionNumber(part)
+		if nil != err {
+			return nil, errors.New("invalid number in version: " + version)
+		}
+
+		*nums[i] = n
+	}
+
+	return v, nil
+}
+
+// parseVersionNumber
+//
+// Parses a numeric version component. Leading zeros are not allowed by the semver spec.
+func parseVersionNumber(str string) (uint64, error) {
+	if !isValidNumber(str) || (len(str) > 1 && str[0] == '0') {
+		return 0, errors.New("invalid version number: " + str)
+	}
+
+	return strconv.ParseUint(str, 10, 64)
+}
+
+// isValidNumber
+// helper func used by parseVersionNumber, validIdentifiers and compare
+func isValidNumber(str string) bool {
+	if len(str) == 0 || str[0] == '-' {
+		return false
+	}
+
+	for _, c := range str {
+		if !unicode.IsDigit(c) {
+			return false
+		}
+	}
+	return true
+}
+
+// validIdentifiers
+//
+// Checks the dot separated pre-release or build metadata identifiers are non-empty and only [0-9A-Za-z-]. Numeric
+// pre-release identifiers may not have leading zeros.
+func validIdentifiers(str string, prerelease bool) bool {
+	for _, id := range strings.Split(str, ".") {
+		if len(id) == 0 {
+			return false
+		}
+
+		for _, c := range id {
+			if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '-') {
+				return false
+			}
+		}
+
+		if prerelease && isValidNumber(id) && len(id) > 1 && id[0] == '0' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// String
+//
+// Returns the version in its canonical MAJOR.MINOR.PATCH[-prerelease][+build] form.
+func (v *semver) String() string {
+	s := strconv.FormatUint(v.Major, 10) + "." + strconv.FormatUint(v.Minor, 10) + "." + strconv.FormatUint(v.Patch, 10)
+	if len(v.Prerelease) > 0 {
+		s += "-" + strings.Join(v.Prerelease, ".")
+	}
+	if len(v.Build) > 0 {
+		s += "+" + v.Build
+	}
+
+	return s
+}
+
+// compare
+//
+// Returns -1, 0 or 1 when v has lower, equal or higher precedence than o, following section 11 of the semver spec.
+func (v *semver) compare(o *semver) int {
+	if c := compareUint(v.Major, o.Major); c != 0 {
+		return c
+	}
+	if c := compareUint(v.Minor, o.Minor); c != 0 {
+		return c
+	}
+	if c := compareUint(v.Patch, o.Patch); c != 0 {
+		return c
+	}
+
+	// a version without a pre-release has higher precedence than one with
+	switch {
+	case len(v.Prerelease) == 0 && len(o.Prerelease) == 0:
+		return 0
+	case len(v.Prerelease) == 0:
+		return 1
+	case len(o.Prerelease) == 0:
+		return -1
+	}
+
+	for i := 0; i < len(v.Prerelease) && i < len(o.Prerelease); i++ {
+		a, b := v.Prerelease[i], o.Prerelease[i]
+		aNum, bNum := isValidNumber(a), isValidNumber(b)
+
+		switch {
+		case aNum && bNum:
+			an, _ := strconv.ParseUint(a, 10, 64)
+			bn, _ := strconv.ParseUint(b, 10, 64)
+			if c := compareUint(an, bn); c != 0 {
+				return c
+			}
+		case aNum:
+			// numeric identifiers always have lower precedence than alphanumeric
+			return -1
+		case bNum:
+			return 1
+		default:
+			if c := strings.Compare(a, b); c != 0 {
+				return c
+			}
+		}
+	}
+
+	return compareUint(uint64(len(v.Prerelease)), uint64(len(o.Prerelease)))
+}
+
+func compareUint(a, b uint64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+
+	return 0
+}
+
+// parseVersionConstraint
+//
+// Parses a version constraint expression. Supported forms are:
+//
+//	1.2.3          exact version (a partial version like 1.2 or 1.x is a range, 1.2 means >=1.2.0 <1.3.0)
+//	>=1.0 <2.0     comparators (=, >, >=, <, <=) separated by spaces or commas must all match
+//	^1.2           compatible with: >=1.2.0 <2.0.0 (^0.2.3 is >=0.2.3 <0.3.0)
+//	~1.4.0         patch level changes: >=1.4.0 <1.5.0 (~1 is >=1.0.0 <2.0.0)
+//	^1.0 || ^2.0   either group may match
+//	*              any version
+//
+// Missing minor and patch numbers in comparators are treated as 0. As with npm, a pre-release version only matches
+// when one of the comparators in the group carries a pre-release for the same major.minor.patch.
+func parseVersionConstraint(expr string) (versionConstraint, error) {
+	var constraint versionConstraint
+
+	for _, group := range strings.Split(expr, "||") {
+		fields := strings.Fields(strings.ReplaceAll(group, ",", " "))
+		if len(fields) == 0 {
+			return nil, errors.New("empty version constraint: " + expr)
+		}
+
+		comparators := make([]versionComparator, 0)
+
+		for i := 0; i < len(fields); i++ {
+			field := fields[i]
+
+			// allow a space between the operator and the version, e.g. ">= 1.0"
+			if isConstraintOperator(field) && i+1 < len(fields) {
+				i++
+				field += fields[i]
+			}
+
+			cs, err := parseComparator(field)
+			if nil != err {
+				return nil, err
+			}
+
+			comparators = append(comparators, cs...)
+		}
+
+		constraint = append(constraint, comparators)
+	}
+
+	return constraint, nil
+}
+
+func isConstraintOperator(str string) bool {
+	switch str {
+	case "=", ">", ">=", "<", "<=", "^", "~":
+		return true
+	}
+
+	return false
+}
+
+// parseComparator
+//
+// Turns one constraint term in to the primitive comparators it stands for, e.g. ^1.2 becomes >=1.2.0 and <2.0.0.
+func parseComparator(term string) ([]versionComparator, error) {
+	op := ""
+	for _, candidate := range []string{">=", "<=", ">", "<", "=", "^", "~"} {
+		if strings.HasPrefix(term, candidate) {
+			op = candidate
+			break
+		}
+	}
+
+	version, parts, err := parsePartialVersion(strings.TrimPrefix(term[len(op):], "v"))
+	if nil != err {
+		return nil, errors.New("invalid version constraint " + term + ": " + err.Error())
+	}
+
+	// a bare * or x matches everything
+	if parts == 0 {
+		return []versionComparator{}, nil
+	}
+
+	lower := func() versionComparator { return versionComparator{op: ">=", version: version} }
+	upper := func(v *semver) versionComparator { return versionComparator{op: "<", version: v} }
+
+	switch op {
+	case "^":
+		switch {
+		case version.Major > 0 || parts == 1:
+			return []versionComparator{lower(), upper(&semver{Major: version.Major + 1, Prerelease: []string{"0"}})}, nil
+		case version.Minor > 0 || parts == 2:
+			return []versionComparator{lower(), upper(&semver{Minor: version.Minor + 1, Prerelease: []string{"0"}})}, nil
+		default:
+			return []versionComparator{lower(), upper(&semver{Patch: version.Patch + 1, Prerelease: []string{"0"}})}, nil
+		}
+	case "~":
+		if parts == 1 {
+			return []versionComparator{lower(), upper(&semver{Major: version.Major + 1, Prerelease: []string{"0"}})}, nil
+		}
+		return []versionComparator{lower(), upper(&semver{Major: version.Major, Minor: version.Minor + 1, Prerelease: []string{"0"}})}, nil
+	case "", "=":
+		if parts == 3 {
+			return []versionComparator{{op: "=", version: version}}, nil
+		}
+		return []versionComparator{lower(), upper(bumpPartial(version, parts))}, nil
+	case ">":
+		if parts == 3 {
+			return []versionComparator{{op: ">", version: version}}, nil
+		}
+		// >1.2 means anything from 1.3.0 on
+		return []versionComparator{{op: ">=", version: bumpPartial(version, parts)}}, nil
+	case "<=":
+		if parts == 3 {
+			return []versionComparator{{op: "<=", version: version}}, nil
+		}
+		// <=1.2 means anything below 1.3.0
+		return []versionComparator{upper(bumpPartial(version, parts))}, nil
+	}
+
+	// >= and < on a partial version simply fill in zeros
+	return []versionComparator{{op: op, version: version}}, nil
+}
+
+// parsePartialVersion
+//
+// Parses a version that may be missing minor/patch numbers or use x/X/* wildcards for them. The number of explicit
+// numeric parts is returned along with the version.
+func parsePartialVersion(str string) (*semver, int, error) {
+	if v, err := parseSemver(str); nil == err {
+		return v, 3, nil
+	}
+
+	if strings.ContainsAny(str, "-+") {
+		return nil, 0, errors.New("pre-release and build metadata require a full major.minor.patch version")
+	}
+
+	parts := strings.Split(str, ".")
+	if len(parts) > 3 {
+		return nil, 0, errors.New("too many version parts")
+	}
+
+	v := &semver{}
+	nums := []*uint64{&v.Major, &v.Minor, &v.Patch}
+	count := 0
+
+	for i, part := range parts {
+		if part == "x" || part == "X" || part == "*" {
+			break
+		}
+
+		n, err := parseVersionNumber(part)
+		if nil != err {
+			return nil, 0, err
+		}
+
+		*nums[i] = n
+		count++
+	}
+
+	return v, count, nil
+}
+
+// bumpPartial
+//
+// Returns the first version after the range a partial version covers, e.g. 1.2 becomes 1.3.0-0 and 1 becomes 2.0.0-0.
+func bumpPartial(v *semver, parts int) *semver {
+	if parts == 1 {
+		return &semver{Major: v.Major + 1, Prerelease: []string{"0"}}
+	}
+
+	return &semver{Major: v.Major, Minor: v.Minor + 1, Prerelease: []string{"0"}}
+}
+
+// check
+//
+// Returns true when the version satisfies the constraint.
+func (c versionConstraint) check(v *semver) bool {
+	for _, group := range c {
+		if checkComparators(group, v) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func checkComparators(group []versionComparator, v *semver) bool {
+	for _, comparator := range group {
+		cmp := v.compare(comparator.version)
+
+		var ok bool
+		switch comparator.op {
+		case "=":
+			ok = cmp == 0
+		case ">":
+			ok = cmp > 0
+		case ">=":
+			ok = cmp >= 0
+		case "<":
+			ok = cmp < 0
+		case "<=":
+			ok = cmp <= 0
+		}
+
+		if !ok {
+			return false
+		}
+	}
+
+	if len(v.Prerelease) == 0 {
+		return true
+	}
+
+	// pre-releases only match when explicitly asked for on the same major.minor.patch. The 0 pre-release used for
+	// the exclusive upper bounds above does not count, otherwise ^1.2 would match 2.0.0-beta.
+	for _, comparator := range group {
+		cv := comparator.version
+		if len(cv.Prerelease) > 0 && !(len(cv.Prerelease) == 1 && cv.Prerelease[0] == "0" && comparator.op == "<") &&
+			cv.Major == v.Major && cv.Minor == v.Minor && cv.Patch == v.Patch {
+			return true
+		}
+	}
+
+	return false
+}
+
+// constraintFromVersions
+//
+// Builds the constraint used by GetExtensionsForExtensionPoint from its versions argument. A single value is either an
+// exact version or a constraint expression. Two values are an inclusive [lower, upper] range of exact versions, and
+// an empty upper bound is the same as the single lower value, an exact match.
+func constraintFromVersions(versions []string) (versionConstraint, error) {
+	lowerVersion := versions[0]
+
+	if len(versions) == 1 || len(versions[1]) == 0 {
+		if isSemverValid(lowerVersion) {
+			return parseVersionConstraint("=" + lowerVersion)
+		}
+
+		constraint, err := parseVersionConstraint(lowerVersion)
+		if nil != err {
+			return nil, errors.New("version or lower bound version is not a valid SemVer or constraint: " + lowerVersion)
+		}
+
+		return constraint, nil
+	}
+
+	upperVersion := versions[1]
+
+	if !isSemverValid(lowerVersion) {
+		return nil, errors.New("version or lower bound version is not a valid SemVer: " + lowerVersion)
+	}
+
+	if !isSemverValid(upperVersion) {
+		return nil, errors.New("version or upper bound version is not a valid SemVer: " + upperVersion)
+	}
+
+	return parseVersionConstraint(">=" + lowerVersion + " <=" + upperVersion)
+}
@@ -0,0 +1,141 @@
+package pluginengine
+
+import (
+	"testing"
+
+	gopdk "github.com/spirefy/go-pdk"
+)
+
+func TestParseSemver(t *testing.T) {
+	valid := []string{"0.0.0", "1.2.3", "1.0.0-alpha", "1.0.0-alpha.1", "1.0.0-0.3.7", "1.0.0-x.7.z.92", "1.0.0+20130313144700", "1.0.0-beta+exp.sha.5114f85"}
+	invalid := []string{"", "1", "1.2", "1.2.3.4", "01.2.3", "1.2.-3", "1.2.3-", "1.2.3-01", "1.2.3+", "1.2.3-al..pha", "a.b.c"}
+
+	for _, v := range valid {
+		if !isSemverValid(v) {
+			t.Errorf("Expected %q to be a valid semver", v)
+		}
+	}
+
+	for _, v := range invalid {
+		if isSemverValid(v) {
+			t.Errorf("Expected %q to be an invalid semver", v)
+		}
+	}
+}
+
+func TestSemverCompare(t *testing.T) {
+	// in increasing precedence, from the semver spec
+	ordered := []string{"1.0.0-alpha", "1.0.0-alpha.1", "1.0.0-alpha.beta", "1.0.0-beta", "1.0.0-beta.2", "1.0.0-beta.11", "1.0.0-rc.1", "1.0.0", "1.0.1", "1.1.0", "2.0.0"}
+
+	for i := 0; i < len(ordered)-1; i++ {
+		a, _ := parseSemver(ordered[i])
+		b, _ := parseSemver(ordered[i+1])
+		if a.compare(b) >= 0 || b.compare(a) <= 0 {
+			t.Errorf("Expected %s < %s", ordered[i], ordered[i+1])
+		}
+	}
+
+	a, _ := parseSemver("1.0.0+build.1")
+	b, _ := parseSemver("1.0.0+build.2")
+	if a.compare(b) != 0 {
+		t.Errorf("Expected build metadata to be ignored in comparison")
+	}
+}
+
+func TestVersionConstraint(t *testing.T) {
+	tests := []struct {
+		constraint string
+		matches    []string
+		misses     []string
+	}{
+		{"1.2.3", []string{"1.2.3", "1.2.3+build"}, []string{"1.2.4", "1.2.3-rc.1"}},
+		{"1.2", []string{"1.2.0", "1.2.9"}, []string{"1.3.0", "1.1.9"}},
+		{"1.x", []string{"1.0.0", "1.9.9"}, []string{"2.0.0", "0.9.0"}},
+		{"^1.2", []string{"1.2.0", "1.9.0"}, []string{"1.1.9", "2.0.0", "2.0.0-beta"}},
+		{"^0.2.3", []string{"0.2.3", "0.2.9"}, []string{"0.3.0", "0.2.2"}},
+		{"^0.0.3", []string{"0.0.3"}, []string{"0.0.4"}},
+		{"~1.4.0", []string{"1.4.0", "1.4.7"}, []string{"1.5.0", "1.3.9"}},
+		{"~1", []string{"1.0.0", "1.9.0"}, []string{"2.0.0"}},
+		{">=1.0 <2.0", []string{"1.0.0", "1.99.0"}, []string{"0.9.9", "2.0.0"}},
+		{">= 1.0, < 2.0", []string{"1.5.0"}, []string{"2.0.0"}},
+		{">1.2", []string{"1.3.0"}, []string{"1.2.9"}},
+		{"<=1.2", []string{"1.2.9"}, []string{"1.3.0"}},
+		{"^1.0 || ^3.0", []string{"1.1.0", "3.2.0"}, []string{"2.0.0"}},
+		{">=1.0.0-beta <1.0.0", []string{"1.0.0-beta", "1.0.0-rc.1"}, []string{"1.0.0", "0.9.0-rc.1"}},
+		{"*", []string{"0.0.1", "9.9.9"}, []string{"1.0.0-alpha"}},
+	}
+
+	for _, test := range tests {
+		constraint, err := parseVersionConstraint(test.constraint)
+		if err != nil {
+			t.Fatalf("Expected %q to parse, but got %v", test.constraint, err)
+		}
+
+		for _, m := range test.matches {
+			v, _ := parseSemver(m)
+			if !constraint.check(v) {
+				t.Errorf("Expected %s to satisfy %q", m, test.constraint)
+			}
+		}
+
+		for _, m := range test.misses {
+			v, _ := parseSemver(m)
+			if constraint.check(v) {
+				t.Errorf("Expected %s not to satisfy %q", m, test.constraint)
+			}
+		}
+	}
+
+	for _, bad := range []string{"", "^", ">=a.b", "1.2.3.4", "1.2-beta", "||"} {
+		if _, err := parseVersionConstraint(bad); err == nil {
+			t.Errorf("Expected %q to fail to parse", bad)
+		}
+	}
+}
+
+func TestGetExtensionsForExtensionPoint_Versions(t *testing.T) {
+	engine := newTestEngine(t)
+
+	for _, version := range []string{"1.0.0", "1.5.0", "2.0.0"} {
+		engine.RegisterHostExtensionPoint("com.acme.menu", "Menu", version, "", nil)
+		eps := engine.extensionPoints["com.acme.menu"]
+		eps[len(eps)-1].Extensions = []*extension{{Extension: gopdk.Extension{Id: "ext-" + version}}}
+	}
+
+	tests := []struct {
+		versions []string
+		expected []string
+	}{
+		{[]string{"1.5.0"}, []string{"ext-1.5.0"}},
+		{[]string{"1.0.0", "1.5.0"}, []string{"ext-1.5.0", "ext-1.0.0"}},
+		// an empty upper bound is an exact match, as with a single version
+		{[]string{"1.0.0", ""}, []string{"ext-1.0.0"}},
+		{[]string{"^1.0"}, []string{"ext-1.5.0", "ext-1.0.0"}},
+		{[]string{">=1.0 <3.0"}, []string{"ext-2.0.0", "ext-1.5.0", "ext-1.0.0"}},
+	}
+
+	for _, test := range tests {
+		exts, err := engine.GetExtensionsForExtensionPoint("com.acme.menu", test.versions)
+		if err != nil {
+			t.Fatalf("Expected no error for %v, but got %v", test.versions, err)
+		}
+
+		if len(exts) != len(test.expected) {
+			t.Fatalf("Expected %d extensions for %v, but got %d", len(test.expected), test.versions, len(exts))
+		}
+
+		for i, ext := range exts {
+			if ext.Id != test.expected[i] {
+				t.Errorf("Expected extension %s at %d for %v, but got %s", test.expected[i], i, test.versions, ext.Id)
+			}
+		}
+	}
+
+	if _, err := engine.GetExtensionsForExtensionPoint("com.acme.menu", []string{"^3.0"}); err == nil {
+		t.Errorf("Expected error when no extension point version matches")
+	}
+
+	if _, err := engine.GetExtensionsForExtensionPoint("com.acme.menu", []string{"1.0.0", "two"}); err == nil {
+		t.Errorf("Expected error for invalid upper bound version")
+	}
+}
@@ -0,0 +1,111 @@
+package pluginengine
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// SignaturePolicy is what the engine does with the signature of a plugin archive before it extracts it, see
+// WithSignaturePolicy.
+type SignaturePolicy string
+
+const (
+	// SignatureOff loads archives without looking at their signatures. It is the default.
+	SignatureOff SignaturePolicy = "off"
+	// SignatureWarn verifies signatures, logs a warning for an archive that is unsigned or not signed by a trusted key
+	// and loads it anyway.
+	SignatureWarn SignaturePolicy = "warn"
+	// SignatureRequire refuses to load an archive that is not signed by a trusted key.
+	SignatureRequire SignaturePolicy = "require"
+)
+
+// signatureSuffix is appended to the name of an archive for the name of its detached signature file.
+const signatureSuffix = ".sig"
+
+var (
+	// ErrUnsignedArchive is wrapped by the error loading a plugin archive returns under SignatureRequire when there is
+	// no signature file next to the archive.
+	ErrUnsignedArchive = errors.New("plugin archive is not signed")
+	// ErrUntrustedSignature is wrapped by the error loading a plugin archive returns under SignatureRequire when its
+	// signature is not valid for any of the trusted keys.
+	ErrUntrustedSignature = errors.New("plugin archive is not signed by a trusted key")
+)
+
+// SignArchive
+//
+// Signs the SHA-256 digest of the archive with the ed25519 private key and writes the signature, base64 encoded, to
+// the detached signature file next to the archive (the archive's name with .sig appended). That file is published
+// alongside the archive, and Load downloads it with the archive from a URL.
+func SignArchive(archive string, key ed25519.PrivateKey) error {
+	digest, err := fileDigest(archive)
+	if nil != err {
+		return err
+	}
+
+	signature := base64.StdEncoding.EncodeToString(ed25519.Sign(key, digest))
+	return os.WriteFile(archive+signatureSuffix, []byte(signature+"\n"), 0644)
+}
+
+// verifyArchive
+//
+// Verifies the detached signature of the archive, whose contents have the digest, against the trusted keys and returns
+// the name of the key that signed it. The keys are tried in name order so the same signer is reported when more than
+// one key holds the same public key.
+func verifyArchive(archive string, digest []byte, trusted map[string]ed25519.PublicKey) (string, error) {
+	data, err := os.ReadFile(archive + signatureSuffix)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("%w: %s", ErrUnsignedArchive, archive)
+	}
+	if nil != err {
+		return "", err
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if nil != err || len(signature) != ed25519.SignatureSize {
+		return "", fmt.Errorf("%w: %s has a malformed signature", ErrUntrustedSignature, archive)
+	}
+
+	names := make([]string, 0, len(trusted))
+	for name := range trusted {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if key := trusted[name]; len(key) == ed25519.PublicKeySize && ed25519.Verify(key, digest, signature) {
+			return name, nil
+		}
+	}
+
+	return "", fmt.Errorf("%w: %s", ErrUntrustedSignature, archive)
+}
+
+// checkSignature
+//
+// Applies the engine's signature policy to the archive, whose contents have the digest, before it is extracted. It
+// returns the name of the trusted key the archive is signed with, empty when the policy is off or, under SignatureWarn,
+// when the archive is not signed by a trusted key, and an error under SignatureRequire when it is not. A policy other
+// than these is treated as SignatureRequire, so a typo does not turn verification off.
+func (e *Engine) checkSignature(archive string, digest []byte) (string, error) {
+	if e.signaturePolicy == SignatureOff || len(e.signaturePolicy) == 0 {
+		return "", nil
+	}
+
+	signer, err := verifyArchive(archive, digest, e.trustedKeys)
+	if nil == err {
+		e.logger.Debug("verified plugin archive signature", "archive", archive, "signer", signer)
+		return signer, nil
+	}
+
+	if e.signaturePolicy != SignatureWarn {
+		return "", err
+	}
+
+	e.logger.Warn("loading plugin archive without a trusted signature", "archive", archive, "error", err)
+	return "", nil
+}
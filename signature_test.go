@@ -0,0 +1,130 @@
+package pluginengine
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	extism "github.com/extism/go-sdk"
+)
+
+func newSigningKey(t *testing.T) (ed25519.PublicKey, ed25519.PrivateKey) {
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return public, private
+}
+
+func TestSignaturePolicy(t *testing.T) {
+	trusted, trustedPrivate := newSigningKey(t)
+	_, otherPrivate := newSigningKey(t)
+
+	dir := t.TempDir()
+	write := func(id string, key ed25519.PrivateKey) string {
+		archive := filepath.Join(dir, id+".tar.gz")
+		if err := os.WriteFile(archive, createPluginArchive(t, id, "1.0.0"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if nil != key {
+			if err := SignArchive(archive, key); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return archive
+	}
+
+	signed := write("com.acme.signed", trustedPrivate)
+	untrusted := write("com.acme.untrusted", otherPrivate)
+	unsigned := write("com.acme.unsigned", nil)
+	tampered := write("com.acme.tampered", trustedPrivate)
+	if err := os.WriteFile(tampered, createPluginArchive(t, "com.acme.tampered", "2.0.0"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		policy SignaturePolicy
+		errs   map[string]error
+	}{
+		{policy: SignatureRequire, errs: map[string]error{untrusted: ErrUntrustedSignature, unsigned: ErrUnsignedArchive, tampered: ErrUntrustedSignature}},
+		{policy: SignatureWarn, errs: map[string]error{}},
+		{policy: SignatureOff, errs: map[string]error{}},
+	}
+
+	for _, test := range tests {
+		engine, err := NewPluginEngine(nil, extism.LogLevelOff, t.TempDir(), WithSignaturePolicy(test.policy), WithTrustedKeys(map[string]ed25519.PublicKey{"acme": trusted}))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, archive := range []string{signed, untrusted, unsigned, tampered} {
+			err := engine.loadPluginArchive(archive, "")
+			if expected := test.errs[archive]; !errors.Is(err, expected) {
+				t.Errorf("Expected %v loading %s with policy %s, but got %v", expected, filepath.Base(archive), test.policy, err)
+			}
+		}
+
+		expected := "acme"
+		if test.policy == SignatureOff {
+			expected = ""
+		}
+
+		if p := engine.GetPlugins()["com.acme.signed"]["1.0.0"]; nil == p || p.Signer != expected {
+			t.Errorf("Expected the signed plugin to be loaded with signer %q with policy %s, but got %+v", expected, test.policy, p)
+		}
+
+		if p := engine.GetPlugins()["com.acme.untrusted"]["1.0.0"]; nil != p && len(p.Signer) > 0 {
+			t.Errorf("Expected no signer for the untrusted plugin with policy %s, but got %q", test.policy, p.Signer)
+		}
+
+		if _, err := os.Stat(filepath.Join(engine.pluginPath, "com.acme.unsigned")); test.policy == SignatureRequire && !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("Expected an unsigned archive not to be extracted, but got %v", err)
+		}
+	}
+}
+
+func TestLoad_URLSignature(t *testing.T) {
+	trusted, private := newSigningKey(t)
+
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "plugin.tar.gz")
+	if err := os.WriteFile(archive, createPluginArchive(t, "com.acme.download", "1.0.0"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := SignArchive(archive, private); err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/signed/", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, filepath.Join(dir, filepath.Base(r.URL.Path)))
+	})
+	mux.HandleFunc("/unsigned/plugin.tar.gz", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, archive)
+	})
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	engine, err := NewPluginEngine(nil, extism.LogLevelOff, t.TempDir(), WithSignaturePolicy(SignatureRequire), WithTrustedKeys(map[string]ed25519.PublicKey{"acme": trusted}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := engine.Load(server.URL + "/unsigned/plugin.tar.gz"); !errors.Is(err, ErrUnsignedArchive) {
+		t.Errorf("Expected ErrUnsignedArchive for an archive published without a signature, but got %v", err)
+	}
+
+	if err := engine.Load(server.URL + "/signed/plugin.tar.gz"); err != nil {
+		t.Fatalf("Expected no error loading a signed plugin URL, but got %v", err)
+	}
+
+	if p := engine.GetPlugins()["com.acme.download"]["1.0.0"]; nil == p || p.Signer != "acme" {
+		t.Errorf("Expected the downloaded plugin to be signed by acme, but got %+v", p)
+	}
+}
@@ -8,7 +8,19 @@ import (
 	"path/filepath"
 )
 
+// Untar
+//
+// Extracts the .tar.gz archive to outputPath within DefaultExtractLimits, see UntarWithLimits.
 func Untar(sourceFile, outputPath string) error {
+	return UntarWithLimits(sourceFile, outputPath, DefaultExtractLimits())
+}
+
+// UntarWithLimits
+//
+// Extracts the .tar.gz archive to outputPath. Entries that would be written outside outputPath are refused with an
+// error wrapping ErrUnsafeArchivePath, and an archive that exceeds the limits with one wrapping ErrArchiveLimit. What
+// was extracted before an error is removed again.
+func UntarWithLimits(sourceFile, outputPath string, limits ExtractLimits) error {
 	// Open the compressed file
 	reader, err := os.Open(sourceFile)
 	if err != nil {
@@ -21,8 +33,20 @@ func Untar(sourceFile, outputPath string) error {
 		}
 	}(reader)
 
+	info, err := reader.Stat()
+	if err != nil {
+		return err
+	}
+
+	return untar(reader, info.Size(), sourceFile, outputPath, limits)
+}
+
+// untar
+//
+// Extracts the .tar.gz archive read from r, which is size bytes long, to outputPath, see UntarWithLimits.
+func untar(r io.Reader, size int64, sourceFile, outputPath string, limits ExtractLimits) (err error) {
 	// Create a gzip reader
-	gzipReader, err := gzip.NewReader(reader)
+	gzipReader, err := gzip.NewReader(r)
 	if err != nil {
 		return err
 	}
@@ -38,10 +62,17 @@ func Untar(sourceFile, outputPath string) error {
 	tarReader := tar.NewReader(gzipReader)
 
 	// try to create the output path in case it is not there yet
-	if err := os.MkdirAll(outputPath, 0755); err != nil {
+	x, err := newExtraction(sourceFile, size, outputPath, limits)
+	if err != nil {
 		return err
 	}
 
+	defer func() {
+		if err != nil {
+			x.cleanup()
+		}
+	}()
+
 	// Iterate through the files in the archive
 	for {
 		header, err := tarReader.Next()
@@ -52,34 +83,51 @@ func Untar(sourceFile, outputPath string) error {
 			return err
 		}
 
-		// Get the individual file name and path
-		fileName := filepath.Join(outputPath, header.Name)
+		// Get the individual file name and path, which must stay inside the output path
+		fileName, err := x.entry(header.Name)
+		if err != nil {
+			return err
+		}
 
 		// Handle directories and files differently
 		switch header.Typeflag {
 		case tar.TypeDir:
 			// Create the directory
-			if err := os.MkdirAll(fileName, 0755); err != nil {
+			if err := x.mkdirAll(fileName); err != nil {
 				return err
 			}
-		case tar.TypeReg:
-			// Create the file
-			writer, err := os.Create(fileName)
-			if err != nil {
+		case tar.TypeSymlink:
+			if err := checkSymlink(x.root, fileName, header.Linkname); err != nil {
 				return err
 			}
 
-			// Copy the file data
-			_, err = io.Copy(writer, tarReader)
-			if err != nil {
+			if err := x.mkdirAll(filepath.Dir(fileName)); err != nil {
 				return err
 			}
 
-			// Close the file
-			err = writer.Close()
+			if err := os.Symlink(header.Linkname, fileName); err != nil {
+				return err
+			}
+			x.link(fileName)
+		case tar.TypeLink:
+			target, err := hardlinkTarget(x.root, header.Linkname)
 			if err != nil {
 				return err
 			}
+
+			if err := x.mkdirAll(filepath.Dir(fileName)); err != nil {
+				return err
+			}
+
+			if err := os.Link(target, fileName); err != nil {
+				return err
+			}
+			x.link(fileName)
+		case tar.TypeReg:
+			// Create the file and copy the file data
+			if err := x.writeFile(fileName, tarReader, 0644); err != nil {
+				return err
+			}
 		}
 	}
 
@@ -0,0 +1,104 @@
+package pluginengine
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/gobwas/glob"
+)
+
+// topicPattern
+//
+// The event names a listener is registered for. Event names are hierarchical, with segments separated by dots (e.g.
+// editor.selection.changed), and a pattern matches them segment by segment:
+//
+//   - a segment without wildcards matches that segment only
+//   - * as a segment matches exactly one segment, so window.* matches window.focused but not window or window.a.b
+//   - within a segment, * matches any characters and ? one character, and [abc] and {a,b} may be used too, so
+//     editor.sel* matches editor.selection, but never crosses a dot
+//   - # as the last segment matches zero or more segments, so editor.selection.# matches editor.selection,
+//     editor.selection.changed and editor.selection.a.b, and # on its own matches every event
+//
+// A pattern without any wildcard is an exact event name.
+type topicPattern struct {
+	raw   string
+	exact bool
+	any   bool
+	glob  glob.Glob
+	// for a pattern ending in .#, matches the event name without any further segment
+	base glob.Glob
+}
+
+// compileTopicPattern
+//
+// Parses the pattern, returning an error when it is empty, has an empty segment, uses # anywhere but as the whole
+// last segment, or uses ** (# is how to match more than one segment).
+func compileTopicPattern(pattern string) (*topicPattern, error) {
+	if len(pattern) == 0 {
+		return nil, errors.New("event topic pattern is empty")
+	}
+
+	tp := &topicPattern{raw: pattern}
+	if !strings.ContainsAny(pattern, "*?[{#\\") {
+		tp.exact = true
+		return tp, nil
+	}
+
+	segments := strings.Split(pattern, ".")
+	multi := false
+	for i, segment := range segments {
+		switch {
+		case len(segment) == 0:
+			return nil, errors.New("event topic pattern has an empty segment: " + pattern)
+		case strings.Contains(segment, "**"):
+			return nil, errors.New("event topic pattern can not use **, use # to match more than one segment: " + pattern)
+		case strings.Contains(segment, "#"):
+			if segment != "#" || i != len(segments)-1 {
+				return nil, errors.New("# can only be the last segment of an event topic pattern: " + pattern)
+			}
+			multi = true
+		}
+	}
+
+	var err error
+	if !multi {
+		tp.glob, err = glob.Compile(pattern, '.')
+		if nil != err {
+			return nil, errors.New("invalid event topic pattern " + pattern + ": " + err.Error())
+		}
+
+		return tp, nil
+	}
+
+	if len(segments) == 1 {
+		tp.any = true
+		return tp, nil
+	}
+
+	base := strings.Join(segments[:len(segments)-1], ".")
+	if tp.base, err = glob.Compile(base, '.'); nil == err {
+		tp.glob, err = glob.Compile(base+".**", '.')
+	}
+
+	if nil != err {
+		return nil, errors.New("invalid event topic pattern " + pattern + ": " + err.Error())
+	}
+
+	return tp, nil
+}
+
+// match
+//
+// Returns true when the event name is matched by the pattern.
+func (tp *topicPattern) match(name string) bool {
+	switch {
+	case tp.exact:
+		return tp.raw == name
+	case tp.any:
+		return true
+	case nil != tp.base && tp.base.Match(name):
+		return true
+	default:
+		return tp.glob.Match(name)
+	}
+}
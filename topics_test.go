@@ -0,0 +1,47 @@
+package pluginengine
+
+import (
+	"testing"
+)
+
+func TestTopicPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		matches []string
+		misses  []string
+	}{
+		{"window.focused", []string{"window.focused"}, []string{"window.focus", "window.focused.x"}},
+		{"window.*", []string{"window.focused", "window.closed"}, []string{"window", "window.a.b", "windows.focused"}},
+		{"*.selection", []string{"editor.selection"}, []string{"selection", "a.b.selection"}},
+		{"editor.sel*", []string{"editor.selection", "editor.sel"}, []string{"editor.selection.changed"}},
+		{"editor.selection.#", []string{"editor.selection", "editor.selection.changed", "editor.selection.a.b"}, []string{"editor.selections", "editor"}},
+		{"*.selection.#", []string{"editor.selection", "files.selection.cleared"}, []string{"selection.cleared"}},
+		{"#", []string{"window", "window.focused", "a.b.c"}, nil},
+		{"window.{focused,closed}", []string{"window.focused", "window.closed"}, []string{"window.opened"}},
+	}
+
+	for _, test := range tests {
+		tp, err := compileTopicPattern(test.pattern)
+		if err != nil {
+			t.Fatalf("Expected %q to compile, but got %v", test.pattern, err)
+		}
+
+		for _, name := range test.matches {
+			if !tp.match(name) {
+				t.Errorf("Expected %q to match %s", test.pattern, name)
+			}
+		}
+
+		for _, name := range test.misses {
+			if tp.match(name) {
+				t.Errorf("Expected %q not to match %s", test.pattern, name)
+			}
+		}
+	}
+
+	for _, bad := range []string{"", "window..*", ".*", "window.#.focused", "window.a#", "window.**", "window.[a"} {
+		if _, err := compileTopicPattern(bad); err == nil {
+			t.Errorf("Expected %q to be refused", bad)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package pluginengine
+
+import (
+	"errors"
+)
+
+// ownedBy
+//
+// Returns true when the plugin copy held by an extension or extension point refers to plugin p.
+func ownedBy(owner plugin, p *plugin) bool {
+	return owner.Id == p.Id && owner.Version == p.Version
+}
+
+// dependsOnPlugin
+//
+// Returns true when p is anywhere in the dependency closure of plugin q.
+func (e *Engine) dependsOnPlugin(q, p *plugin) bool {
+	for _, dep := range e.startOrder([]*plugin{q}) {
+		if dep == p && q != p {
+			return true
+		}
+	}
+
+	return false
+}
+
+// runningDependents
+//
+// Returns every instantiated plugin that depends, directly or transitively, on plugin p, in reverse dependency order.
+// They are stopped before p is unloaded so no running plugin is left calling in to a plugin that is gone, and are
+// instantiated again on their next use if they are still resolved afterwards. It must be called with the engine lock
+// held.
+func (e *Engine) runningDependents(p *plugin) []*plugin {
+	running := make([]*plugin, 0)
+	for _, q := range e.sortedPlugins() {
+		if q.running() {
+			running = append(running, q)
+		}
+	}
+
+	dependents := make([]*plugin, 0)
+	order := e.startOrder(running)
+	for i := len(order) - 1; i >= 0; i-- {
+		if q := order[i]; q.running() && e.dependsOnPlugin(q, p) {
+			dependents = append(dependents, q)
+		}
+	}
+
+	return dependents
+}
+
+// unloadPlugin
+//
+// Removes the plugin, its extensions and its extension points from the engine. The extensions other plugins
+// contributed to its extension points are detached and go back to the unresolved list, so they resolve again if
+// another version of the extension point is (re)loaded. It must be called with the engine lock held, and the caller is
+// responsible for calling resolve afterwards and for stopping the plugin once the lock is released.
+func (e *Engine) unloadPlugin(p *plugin) {
+	// detach this plugin's own extensions, resolve routes their ids again (to another plugin or nowhere)
+	unresolved := make([]*extension, 0)
+	for _, ext := range e.unresolved {
+		if !ownedBy(ext.Plugin, p) {
+			unresolved = append(unresolved, ext)
+		}
+	}
+
+	// remove this plugin's extension points, remembering the extensions of other plugins that were attached to them
+	detached := make([]*extension, 0)
+	for epId, eps := range e.extensionPoints {
+		kept := make([]*extensionPoint, 0)
+		for _, ep := range eps {
+			if ownedBy(ep.Plugin, p) {
+				for _, ext := range ep.Extensions {
+					if !ownedBy(ext.Plugin, p) {
+						detached = append(detached, ext)
+					}
+				}
+			} else {
+				kept = append(kept, ep)
+			}
+		}
+
+		if len(kept) == 0 {
+			delete(e.extensionPoints, epId)
+		} else {
+			e.extensionPoints[epId] = kept
+		}
+	}
+
+	isDetached := make(map[*extension]bool)
+	for _, ext := range detached {
+		if !isDetached[ext] {
+			isDetached[ext] = true
+			ext.Resolved = false
+			unresolved = append(unresolved, ext)
+		}
+	}
+
+	// drop this plugin's extensions from the remaining extension points, as well as the detached ones since resolve
+	// attaches them again to every remaining version of their extension point
+	for _, eps := range e.extensionPoints {
+		for _, ep := range eps {
+			exts := make([]*extension, 0, len(ep.Extensions))
+			for _, ext := range ep.Extensions {
+				if !ownedBy(ext.Plugin, p) && !isDetached[ext] {
+					exts = append(exts, ext)
+				}
+			}
+			ep.Extensions = exts
+		}
+	}
+
+	e.unresolved = unresolved
+
+	// the plugin's event listeners, from its manifest and registered at runtime
+	e.removeListeners(func(l *listener) bool {
+		return l.plugin == p
+	})
+
+	if versions := e.plugins[p.Id]; nil != versions && versions[p.Version] == p {
+		delete(versions, p.Version)
+		if len(versions) == 0 {
+			delete(e.plugins, p.Id)
+		}
+	}
+
+	p.Resolved = false
+	p.dependsOn = nil
+}
+
+// UnloadPlugin
+//
+// This method removes the plugin at the id and version from the engine. Running plugins that depend on it are stopped
+// first, then the plugin itself is stopped, and its extensions and extension points are detached. Plugins that
+// depended on it move back to unresolved unless another loaded plugin satisfies their dependencies.
+func (e *Engine) UnloadPlugin(id, version string) error {
+	e.mu.Lock()
+	p := e.plugins[id][version]
+	if nil == p {
+		e.mu.Unlock()
+		return errors.New("plugin is not loaded: " + pluginKey(id, version))
+	}
+
+	stopping := append(e.runningDependents(p), p)
+	e.unloadPlugin(p)
+	e.resolve()
+	e.mu.Unlock()
+
+	e.notifyExtensionPoints()
+	e.stopPlugins(stopping)
+
+	return nil
+}
+
+// ReloadPlugin
+//
+// This method loads the archive the plugin at the id and version was loaded from again, picking up a new build that
+// replaced the archive. The plugin is only replaced once the archive has loaded it again: when the archive, or the
+// plugin's manifest or module in it, no longer loads, an error is returned and the plugin is left as it was.
+// Dependents are re-resolved against the reloaded plugin and, if the engine has been started, startOnLoad plugins are
+// started again.
+func (e *Engine) ReloadPlugin(id, version string) error {
+	e.mu.RLock()
+	p := e.plugins[id][version]
+	e.mu.RUnlock()
+
+	if nil == p {
+		return errors.New("plugin is not loaded: " + pluginKey(id, version))
+	}
+
+	if len(p.Archive) == 0 {
+		return errors.New("plugin was not loaded from an archive and can not be reloaded: " + pluginKey(id, version))
+	}
+
+	// the reloaded plugin replaces this one when it is added (see addPlugin)
+	if err := e.loadPluginArchive(p.Archive, ""); nil != err {
+		return err
+	}
+
+	e.mu.RLock()
+	reloaded := e.plugins[id][version]
+	started := e.started
+	e.mu.RUnlock()
+
+	if nil == reloaded || reloaded == p {
+		return errors.New("plugin archive did not load the plugin again: " + pluginKey(id, version))
+	}
+
+	if started {
+		e.startPlugins()
+	}
+
+	return nil
+}
@@ -0,0 +1,146 @@
+package pluginengine
+
+import (
+	"archive/tar"
+	"os"
+	"path/filepath"
+	"testing"
+
+	gopdk "github.com/spirefy/go-pdk"
+)
+
+func TestUnloadPlugin(t *testing.T) {
+	engine := newTestEngine(t)
+	engine.RegisterHostExtensionPoint("com.acme.menu", "Menu", "1.0.0", "", nil)
+
+	editor := &plugin{}
+	engine.addPlugin(editor, gopdk.Plugin{
+		Id:              "com.acme.editor",
+		Version:         "1.0.0",
+		Extensions:      []gopdk.Extension{{Id: "com.acme.editor.menu", ExtensionPoint: "com.acme.menu"}},
+		ExtensionPoints: []gopdk.ExtensionPoint{{Id: "com.acme.editor.actions", Version: "1.0.0"}},
+	})
+
+	spell := &plugin{Dependencies: []dependency{{Plugin: "com.acme.editor"}}}
+	engine.addPlugin(spell, gopdk.Plugin{
+		Id:         "com.acme.spell",
+		Version:    "1.0.0",
+		Extensions: []gopdk.Extension{{Id: "com.acme.spell.action", ExtensionPoint: "com.acme.editor.actions"}},
+	})
+
+	if !spell.Resolved || nil == engine.GetExtensionForId("com.acme.spell.action") {
+		t.Fatalf("Expected dependent plugin and its extension to be resolved")
+	}
+
+	if err := engine.UnloadPlugin("com.acme.editor", "1.0.0"); err != nil {
+		t.Fatalf("Expected no error unloading plugin, but got %v", err)
+	}
+
+	if nil != engine.GetPlugins()["com.acme.editor"] {
+		t.Errorf("Expected unloaded plugin to be removed")
+	}
+
+	if nil != engine.GetExtensionForId("com.acme.editor.menu") || nil != engine.callableExtensions["com.acme.editor.menu"] {
+		t.Errorf("Expected unloaded plugin's extension to be removed")
+	}
+
+	if exts, _ := engine.GetExtensionsForExtensionPoint("com.acme.menu", nil); len(exts) != 0 {
+		t.Errorf("Expected host extension point to have no extensions, but got %d", len(exts))
+	}
+
+	if nil != engine.extensionPoints["com.acme.editor.actions"] {
+		t.Errorf("Expected unloaded plugin's extension point to be removed")
+	}
+
+	if spell.Resolved || nil != engine.GetExtensionForId("com.acme.spell.action") || len(engine.unresolved) != 1 {
+		t.Errorf("Expected dependent plugin and its extension to move back to unresolved")
+	}
+
+	if err := engine.UnloadPlugin("com.acme.editor", "1.0.0"); err == nil {
+		t.Errorf("Expected error unloading a plugin that is not loaded")
+	}
+}
+
+func TestReloadPlugin(t *testing.T) {
+	engine := newTestEngine(t)
+
+	archive := filepath.Join(t.TempDir(), "reload.tar.gz")
+	if err := os.WriteFile(archive, createPluginArchive(t, "com.acme.reload", "1.0.0"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := engine.loadPluginArchive(archive, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	old := engine.GetPlugins()["com.acme.reload"]["1.0.0"]
+	if nil == old {
+		t.Fatalf("Expected plugin to be loaded")
+	}
+
+	if err := engine.ReloadPlugin("com.acme.reload", "1.0.0"); err != nil {
+		t.Fatalf("Expected no error reloading plugin, but got %v", err)
+	}
+
+	reloaded := engine.GetPlugins()["com.acme.reload"]["1.0.0"]
+	if nil == reloaded || reloaded == old {
+		t.Errorf("Expected plugin to be replaced by the reloaded archive")
+	}
+
+	if err := engine.ReloadPlugin("com.acme.missing", "1.0.0"); err == nil {
+		t.Errorf("Expected error reloading a plugin that is not loaded")
+	}
+}
+
+func TestReloadPlugin_BrokenManifest(t *testing.T) {
+	engine := newTestEngine(t)
+
+	archive := filepath.Join(t.TempDir(), "reload.tar.gz")
+	if err := os.WriteFile(archive, createPluginArchive(t, "com.acme.reload", "1.0.0"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := engine.loadPluginArchive(archive, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	old := engine.GetPlugins()["com.acme.reload"]["1.0.0"]
+	if nil == old {
+		t.Fatalf("Expected plugin to be loaded")
+	}
+
+	// a new build whose manifest no longer parses
+	writeTestTar(t, archive, []archiveEntry{
+		{name: "plugin.yaml", typeflag: tar.TypeReg, contents: "id: com.acme.reload\nversion: 1.0.0\ntimeout: soon\n"},
+		{name: "plugin.wasm", typeflag: tar.TypeReg, contents: "\x00asm"},
+	})
+
+	if err := engine.ReloadPlugin("com.acme.reload", "1.0.0"); err == nil {
+		t.Errorf("Expected error reloading a plugin whose manifest is broken")
+	}
+
+	if p := engine.GetPlugins()["com.acme.reload"]["1.0.0"]; p != old {
+		t.Errorf("Expected the old plugin to stay loaded when the reload fails, but got %+v", p)
+	}
+}
+
+func TestAddPlugin_ReplaceDoesNotDuplicate(t *testing.T) {
+	engine := newTestEngine(t)
+	engine.RegisterHostExtensionPoint("com.acme.toolbar", "Toolbar", "1.0.0", "", nil)
+
+	for i := 0; i < 2; i++ {
+		engine.addPlugin(&plugin{}, gopdk.Plugin{
+			Id:         "com.acme.tools",
+			Version:    "1.0.0",
+			Extensions: []gopdk.Extension{{Id: "com.acme.tools.button", ExtensionPoint: "com.acme.toolbar"}},
+		})
+	}
+
+	if exts, _ := engine.GetExtensionsForExtensionPoint("com.acme.toolbar", nil); len(exts) != 1 {
+		t.Errorf("Expected replaced plugin's extension once, but got %d", len(exts))
+	}
+
+	if engine.callableExtensions["com.acme.tools.button"] != engine.GetPlugins()["com.acme.tools"]["1.0.0"] {
+		t.Errorf("Expected extension to route to the replacement plugin")
+	}
+}
@@ -0,0 +1,340 @@
+package pluginengine
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// defaultWatchInterval is how often Watch polls the watched directory. An archive must also be unchanged for one full
+// interval before it is loaded, so a file that is still being copied in is not read half written.
+const defaultWatchInterval = 2 * time.Second
+
+type (
+	// WatchEventType is the kind of change Watch acted upon.
+	WatchEventType string
+
+	// WatchEvent is emitted to the listeners registered with OnWatchEvent each time Watch loads, reloads or unloads
+	// the plugins of an archive.
+	WatchEvent struct {
+		Type    WatchEventType
+		Archive string
+		// the plugins (id@version) that were loaded from, or unloaded with, the archive
+		Plugins []string
+		Err     error
+	}
+
+	// archiveState is what Watch compares between polls to spot a new or changed archive.
+	archiveState struct {
+		size    int64
+		modTime time.Time
+		since   time.Time
+	}
+)
+
+const (
+	// WatchInstalled means a new archive was found and its plugins loaded.
+	WatchInstalled WatchEventType = "installed"
+	// WatchUpdated means an archive changed and its plugins were reloaded.
+	WatchUpdated WatchEventType = "updated"
+	// WatchRemoved means an archive was deleted and its plugins unloaded.
+	WatchRemoved WatchEventType = "removed"
+	// WatchFailed means an archive could not be loaded, Err holds the reason.
+	WatchFailed WatchEventType = "failed"
+)
+
+func (s archiveState) same(o archiveState) bool {
+	return s.size == o.size && s.modTime.Equal(o.modTime)
+}
+
+// isPluginArchive
+//
+// Returns true for the archive formats plugins are distributed in.
+func isPluginArchive(path string) bool {
+	return strings.HasSuffix(path, ".tar.gz") || strings.HasSuffix(path, ".zip")
+}
+
+// OnWatchEvent
+//
+// Registers a listener that is called, from the watcher goroutine, for every change Watch acts upon.
+func (e *Engine) OnWatchEvent(listener func(WatchEvent)) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.watchListeners = append(e.watchListeners, listener)
+}
+
+func (e *Engine) emitWatchEvent(event WatchEvent) {
+	e.mu.RLock()
+	listeners := e.watchListeners
+	e.mu.RUnlock()
+
+	for _, listener := range listeners {
+		listener(event)
+	}
+}
+
+// archivePlugins
+//
+// Returns the plugins currently loaded from the archive. It must be called with the engine lock held.
+func (e *Engine) archivePlugins(archive string) []*plugin {
+	plugins := make([]*plugin, 0)
+	for _, p := range e.sortedPlugins() {
+		if p.Archive == archive {
+			plugins = append(plugins, p)
+		}
+	}
+
+	return plugins
+}
+
+func pluginKeys(plugins []*plugin) []string {
+	keys := make([]string, 0, len(plugins))
+	for _, p := range plugins {
+		keys = append(keys, pluginKey(p.Id, p.Version))
+	}
+
+	return keys
+}
+
+// unloadArchive
+//
+// Unloads every plugin that was loaded from the archive, other than the ones to keep, and returns them.
+func (e *Engine) unloadArchive(archive string, keep ...*plugin) []*plugin {
+	kept := make(map[*plugin]bool, len(keep))
+	for _, p := range keep {
+		kept[p] = true
+	}
+
+	e.mu.Lock()
+	plugins := make([]*plugin, 0)
+	stopping := make([]*plugin, 0)
+	for _, p := range e.archivePlugins(archive) {
+		if kept[p] {
+			continue
+		}
+
+		plugins = append(plugins, p)
+		stopping = append(stopping, e.runningDependents(p)...)
+		stopping = append(stopping, p)
+		e.unloadPlugin(p)
+	}
+
+	e.resolve()
+	e.mu.Unlock()
+
+	e.notifyExtensionPoints()
+	e.stopPlugins(stopping)
+
+	return plugins
+}
+
+// loadArchive
+//
+// Loads the archive's plugins, replacing any plugins previously loaded from it, and starts them if the engine has been
+// started. The plugins previously loaded from the archive are only unloaded once the new ones are loaded, so an update
+// that fails to load leaves them running.
+func (e *Engine) loadArchive(archive string) ([]*plugin, error) {
+	e.mu.RLock()
+	previous := make(map[*plugin]bool)
+	for _, p := range e.archivePlugins(archive) {
+		previous[p] = true
+	}
+	e.mu.RUnlock()
+
+	if err := e.loadPluginArchive(archive, ""); nil != err {
+		return nil, err
+	}
+
+	e.mu.RLock()
+	started := e.started
+	plugins := make([]*plugin, 0)
+	for _, p := range e.archivePlugins(archive) {
+		if !previous[p] {
+			plugins = append(plugins, p)
+		}
+	}
+	e.mu.RUnlock()
+
+	if len(plugins) == 0 {
+		return nil, errors.New("no plugin manifest found in archive: " + archive)
+	}
+
+	// plugins of the same id and version were replaced as they loaded, the ones that did not come back go
+	e.unloadArchive(archive, plugins...)
+
+	if started {
+		e.startPlugins()
+	}
+
+	return plugins, nil
+}
+
+// scanArchives
+//
+// Returns the current size and modification time of every plugin archive in the directory.
+func scanArchives(dir string) (map[string]archiveState, error) {
+	files, err := findFilesWithExtensions(dir, []string{".gz", ".zip"})
+	if nil != err {
+		return nil, err
+	}
+
+	archives := make(map[string]archiveState)
+	for _, file := range files {
+		if !isPluginArchive(file) {
+			continue
+		}
+
+		info, err := os.Stat(file)
+		if nil != err {
+			// deleted between the walk and the stat, the next poll sees it as removed
+			continue
+		}
+
+		archives[file] = archiveState{size: info.Size(), modTime: info.ModTime()}
+	}
+
+	return archives, nil
+}
+
+// Watch
+//
+// This method watches the directory for plugin archives being added, changed or deleted and loads, reloads or unloads
+// the corresponding plugins, emitting a WatchEvent for each to the listeners registered with OnWatchEvent. The
+// directory is polled every defaultWatchInterval, or the WithWatchInterval option's interval, and a new or changed
+// archive is only acted upon once it has been unchanged for a full interval, so partially written files are skipped.
+// Archives already loaded (e.g. by a previous Load of the same directory) are not loaded again. Watching runs in its
+// own goroutine until ctx is done.
+func (e *Engine) Watch(ctx context.Context, dir string) error {
+	// the real path, the same one Load records the archives it loads from the directory with
+	absDir, err := realPath(dir)
+	if nil != err {
+		return err
+	}
+
+	info, err := os.Stat(absDir)
+	if nil != err {
+		return err
+	}
+
+	if !info.IsDir() {
+		return errors.New("plugin watch path is not a directory: " + absDir)
+	}
+
+	current, err := scanArchives(absDir)
+	if nil != err {
+		return err
+	}
+
+	// archives that are already loaded are known, anything else found now is installed on the first polls
+	known := make(map[string]archiveState)
+	e.mu.RLock()
+	for archive, state := range current {
+		if len(e.archivePlugins(archive)) > 0 {
+			known[archive] = state
+		}
+	}
+	e.mu.RUnlock()
+
+	interval := e.watchInterval
+	pending := make(map[string]archiveState)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			e.pollArchives(absDir, known, pending, interval)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	return nil
+}
+
+// pollArchives
+//
+// Compares the directory against the known (loaded) and pending (waiting to settle) archives and acts on the changes.
+func (e *Engine) pollArchives(dir string, known, pending map[string]archiveState, settle time.Duration) {
+	current, err := scanArchives(dir)
+	if nil != err {
+		e.logger.Error("error scanning watched plugin directory", "path", dir, "error", err)
+		return
+	}
+
+	now := time.Now()
+	archives := make([]string, 0, len(current))
+	for archive := range current {
+		archives = append(archives, archive)
+	}
+	sort.Strings(archives)
+
+	for _, archive := range archives {
+		state := current[archive]
+
+		if k, ok := known[archive]; ok && k.same(state) {
+			delete(pending, archive)
+			continue
+		}
+
+		// wait until the archive has stopped changing
+		p, ok := pending[archive]
+		if !ok || !p.same(state) {
+			state.since = now
+			pending[archive] = state
+			continue
+		}
+
+		if now.Sub(p.since) < settle {
+			continue
+		}
+
+		delete(pending, archive)
+
+		eventType := WatchInstalled
+		if _, ok := known[archive]; ok {
+			eventType = WatchUpdated
+		}
+
+		// remember the state even on failure, so a broken archive is not retried until it changes again
+		known[archive] = state
+
+		plugins, err := e.loadArchive(archive)
+		if nil != err {
+			e.logger.Error("error loading watched plugin archive", "archive", archive, "error", err)
+			e.emitWatchEvent(WatchEvent{Type: WatchFailed, Archive: archive, Err: err})
+			continue
+		}
+
+		e.emitWatchEvent(WatchEvent{Type: eventType, Archive: archive, Plugins: pluginKeys(plugins)})
+	}
+
+	for archive := range pending {
+		if _, ok := current[archive]; !ok {
+			delete(pending, archive)
+		}
+	}
+
+	removed := make([]string, 0)
+	for archive := range known {
+		if _, ok := current[archive]; !ok {
+			removed = append(removed, archive)
+		}
+	}
+	sort.Strings(removed)
+
+	for _, archive := range removed {
+		delete(known, archive)
+
+		plugins := e.unloadArchive(archive)
+		e.emitWatchEvent(WatchEvent{Type: WatchRemoved, Archive: archive, Plugins: pluginKeys(plugins)})
+	}
+}
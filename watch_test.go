@@ -0,0 +1,212 @@
+package pluginengine
+
+import (
+	"archive/tar"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	extism "github.com/extism/go-sdk"
+)
+
+func waitForWatchEvent(t *testing.T, events <-chan WatchEvent) WatchEvent {
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for watch event")
+	}
+
+	return WatchEvent{}
+}
+
+func TestWatch(t *testing.T) {
+	engine, err := NewPluginEngine(nil, extism.LogLevelOff, t.TempDir(), WithWatchInterval(20*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events := make(chan WatchEvent, 10)
+	engine.OnWatchEvent(func(event WatchEvent) {
+		events <- event
+	})
+
+	dir := t.TempDir()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := engine.Watch(ctx, dir); err != nil {
+		t.Fatal(err)
+	}
+
+	archive := filepath.Join(dir, "watched.tar.gz")
+	if err := os.WriteFile(archive, createPluginArchive(t, "com.acme.watched", "1.0.0"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	event := waitForWatchEvent(t, events)
+	if event.Type != WatchInstalled || len(event.Plugins) != 1 || event.Plugins[0] != "com.acme.watched@1.0.0" {
+		t.Fatalf("Expected installed event for the plugin, but got %v", event)
+	}
+
+	// replace the archive with a new version of the plugin
+	if err := os.WriteFile(archive, createPluginArchive(t, "com.acme.watched", "1.1.0"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(archive, time.Now(), time.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	event = waitForWatchEvent(t, events)
+	if event.Type != WatchUpdated || len(event.Plugins) != 1 || event.Plugins[0] != "com.acme.watched@1.1.0" {
+		t.Fatalf("Expected updated event for the new plugin version, but got %v", event)
+	}
+
+	if err := os.Remove(archive); err != nil {
+		t.Fatal(err)
+	}
+
+	event = waitForWatchEvent(t, events)
+	if event.Type != WatchRemoved || len(event.Plugins) != 1 {
+		t.Fatalf("Expected removed event, but got %v", event)
+	}
+
+	cancel()
+
+	if len(engine.GetPlugins()) != 0 {
+		t.Errorf("Expected removed archive's plugin to be unloaded")
+	}
+}
+
+func TestWatch_LoadedArchives(t *testing.T) {
+	engine, err := NewPluginEngine(nil, extism.LogLevelOff, t.TempDir(), WithWatchInterval(20*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events := make(chan WatchEvent, 10)
+	engine.OnWatchEvent(func(event WatchEvent) {
+		events <- event
+	})
+
+	// a directory name that is not lower case, loaded through a relative path
+	dir := filepath.Join(t.TempDir(), "Plugins")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	archive := filepath.Join(dir, "loaded.tar.gz")
+	if err := os.WriteFile(archive, createPluginArchive(t, "com.acme.loaded", "1.0.0"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rel, err := filepath.Rel(wd, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := engine.Load(rel); err != nil {
+		t.Fatal(err)
+	}
+	if nil == engine.GetPlugins()["com.acme.loaded"]["1.0.0"] {
+		t.Fatalf("Expected the plugin to be loaded from the directory")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := engine.Watch(ctx, dir); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Remove(archive); err != nil {
+		t.Fatal(err)
+	}
+
+	// the archive Load loaded is known, so it is not installed again but its removal is acted upon
+	event := waitForWatchEvent(t, events)
+	if event.Type != WatchRemoved || len(event.Plugins) != 1 || event.Plugins[0] != "com.acme.loaded@1.0.0" {
+		t.Fatalf("Expected removed event for the loaded plugin, but got %v", event)
+	}
+}
+
+func TestWatch_BrokenUpdate(t *testing.T) {
+	engine, err := NewPluginEngine(nil, extism.LogLevelOff, t.TempDir(), WithWatchInterval(20*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events := make(chan WatchEvent, 10)
+	engine.OnWatchEvent(func(event WatchEvent) {
+		events <- event
+	})
+
+	engine.RegisterHostExtensionPoint("com.acme.menu", "Menu", "1.0.0", "", nil)
+	if err := engine.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = engine.Shutdown(context.Background())
+	}()
+
+	dir := t.TempDir()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := engine.Watch(ctx, dir); err != nil {
+		t.Fatal(err)
+	}
+
+	// written elsewhere and moved in, so the watcher never sees it part written
+	staged := filepath.Join(t.TempDir(), "wasm.tar.gz")
+	writeTestTar(t, staged, []archiveEntry{
+		{name: "plugin.yaml", typeflag: tar.TypeReg, contents: "id: com.acme.wasm\nversion: 1.0.0\nextensions:\n  - id: com.acme.wasm.run\n    extensionPoint: com.acme.menu\n    func: run\n"},
+		{name: "plugin.wasm", typeflag: tar.TypeReg, contents: string(testWasmModule(0))},
+	})
+
+	archive := filepath.Join(dir, "wasm.tar.gz")
+	if err := os.Rename(staged, archive); err != nil {
+		t.Fatal(err)
+	}
+
+	if event := waitForWatchEvent(t, events); event.Type != WatchInstalled {
+		t.Fatalf("Expected installed event for the plugin, but got %v", event)
+	}
+	if _, err := engine.CallExtensionFunc("com.acme.wasm.run", nil); err != nil {
+		t.Fatalf("Expected the installed plugin to run, but got %v", err)
+	}
+
+	// an update that cannot be extracted
+	if err := os.WriteFile(archive, []byte("not an archive"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(archive, time.Now(), time.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	if event := waitForWatchEvent(t, events); event.Type != WatchFailed {
+		t.Fatalf("Expected failed event for the broken update, but got %v", event)
+	}
+
+	p := engine.GetPlugins()["com.acme.wasm"]["1.0.0"]
+	if nil == p || !p.running() {
+		t.Fatalf("Expected the old plugin to be left running after a broken update, but got %+v", p)
+	}
+	if _, err := engine.CallExtensionFunc("com.acme.wasm.run", nil); err != nil {
+		t.Errorf("Expected the old plugin to still run, but got %v", err)
+	}
+}
+
+func TestWatch_Errors(t *testing.T) {
+	engine := newTestEngine(t)
+
+	if err := engine.Watch(context.Background(), filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Errorf("Expected error watching a missing directory")
+	}
+}
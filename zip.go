@@ -8,70 +8,127 @@ import (
 	"path/filepath"
 )
 
+// Unzip
+//
+// Extracts the .zip archive to outputPath within DefaultExtractLimits, see UnzipWithLimits.
 func Unzip(sourceFile, outputPath string) error {
+	return UnzipWithLimits(sourceFile, outputPath, DefaultExtractLimits())
+}
+
+// UnzipWithLimits
+//
+// Extracts the .zip archive to outputPath. Entries that would be written outside outputPath are refused with an error
+// wrapping ErrUnsafeArchivePath, and an archive that exceeds the limits with one wrapping ErrArchiveLimit. What was
+// extracted before an error is removed again.
+func UnzipWithLimits(sourceFile, outputPath string, limits ExtractLimits) (err error) {
 	// Open the zip file
-	reader, err := zip.OpenReader(sourceFile)
+	file, err := os.Open(sourceFile)
 	if err != nil {
 		return err
 	}
 
-	defer func(reader *zip.ReadCloser) {
-		err := reader.Close()
-		if err != nil {
-			fmt.Println("Error in defer close of zip")
+	defer func(file *os.File) {
+		if closeErr := file.Close(); nil == err {
+			err = closeErr
 		}
-	}(reader)
+	}(file)
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	return unzip(file, info.Size(), sourceFile, outputPath, limits)
+}
+
+// unzip
+//
+// Extracts the .zip archive read from r, which is size bytes long, to outputPath, see UnzipWithLimits.
+func unzip(r io.ReaderAt, size int64, sourceFile, outputPath string, limits ExtractLimits) (err error) {
+	reader, err := zip.NewReader(r, size)
+	if err != nil {
+		return err
+	}
+
+	// the central directory lists every entry, so too many of them are refused before anything is written
+	if limits.MaxEntries > 0 && len(reader.File) > limits.MaxEntries {
+		return fmt.Errorf("%w: more than %d entries", ErrArchiveLimit, limits.MaxEntries)
+	}
 
 	// Get the individual file name and path
 	// try to create the output path in case it is not there yet
-	if err := os.MkdirAll(outputPath, 0755); err != nil {
+	x, err := newExtraction(sourceFile, size, outputPath, limits)
+	if err != nil {
 		return err
 	}
 
+	defer func() {
+		if err != nil {
+			x.cleanup()
+		}
+	}()
+
 	// Iterate through the files in the archive
 	for _, file := range reader.File {
-		// Get the individual file path
-		filePath := filepath.Join(outputPath, file.Name)
-
-		// Check for directories
-		if file.FileInfo().IsDir() {
-			// Create the directory
-			err := os.MkdirAll(filePath, os.ModePerm)
-			if err != nil {
-				return err
-			}
-			continue
+		if err := unzipFile(x, file); err != nil {
+			return err
 		}
+	}
 
-		// Open the file within the zip
-		fileReader, err := file.Open()
+	return nil
+}
+
+// unzipFile
+//
+// Extracts a single entry of a zip archive.
+func unzipFile(x *extraction, file *zip.File) error {
+	// Get the individual file path, which must stay inside the output path
+	filePath, err := x.entry(file.Name)
+	if err != nil {
+		return err
+	}
+
+	// Check for directories
+	if file.FileInfo().IsDir() {
+		// Create the directory
+		return x.mkdirAll(filePath)
+	}
+
+	// Open the file within the zip
+	fileReader, err := file.Open()
+	if err != nil {
+		return err
+	}
+
+	defer func(fileReader io.ReadCloser) {
+		err := fileReader.Close()
 		if err != nil {
-			return err
 		}
+	}(fileReader)
 
-		defer func(fileReader io.ReadCloser) {
-			err := fileReader.Close()
-			if err != nil {
-			}
-		}(fileReader)
-
-		// Create the target file
-		targetFile, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, file.Mode())
+	// the contents of a symlink entry are its target
+	if file.Mode()&os.ModeSymlink != 0 {
+		target, err := io.ReadAll(io.LimitReader(fileReader, 4096))
 		if err != nil {
 			return err
 		}
-		defer func(targetFile *os.File) {
-			err := targetFile.Close()
-			if err != nil {
 
-			}
-		}(targetFile)
+		if err := checkSymlink(x.root, filePath, string(target)); err != nil {
+			return err
+		}
+
+		if err := x.mkdirAll(filepath.Dir(filePath)); err != nil {
+			return err
+		}
 
-		// Copy the file data
-		if _, err := io.Copy(targetFile, fileReader); err != nil {
+		if err := os.Symlink(string(target), filePath); err != nil {
 			return err
 		}
+
+		x.link(filePath)
+		return nil
 	}
 
-	return nil
+	// Create the target file and copy the file data
+	return x.writeFile(filePath, fileReader, file.Mode().Perm())
 }